@@ -14,6 +14,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,11 +24,13 @@ const (
 	oauthRedirectPath   = "/oauth/callback"
 	oauthCallbackHost   = "127.0.0.1"
 	oauthScope          = "emails:send"
+	oauthScopeDomains   = "domains:read"
 	tokenRefreshRefresh = 5 * time.Minute
 
 	// OAuth string constants (goconst).
 	oauthGrantTypeAuthCode     = "authorization_code"
 	oauthGrantTypeRefreshToken = "refresh_token"
+	oauthGrantTypeDeviceCode   = "urn:ietf:params:oauth:grant-type:device_code"
 	oauthResponseType          = "code"
 	oauthParamClientID         = "client_id"
 	oauthParamRedirectURI      = "redirect_uri"
@@ -48,6 +51,36 @@ type OAuthToken struct {
 	AccessToken  string    `json:"access_token"`
 	RefreshToken string    `json:"refresh_token"`
 	ExpiresAt    time.Time `json:"expires_at"`
+	Scope        string    `json:"scope,omitempty"`
+}
+
+// update stores the tokens from a token response. Providers may omit the
+// refresh token when refreshing, in which case the old one stays valid.
+func (t *OAuthToken) update(resp *tokenResponse) {
+	t.AccessToken = resp.AccessToken
+	if resp.RefreshToken != "" {
+		t.RefreshToken = resp.RefreshToken
+	}
+	if resp.Scope != "" {
+		t.Scope = resp.Scope
+	}
+	t.ExpiresAt = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+}
+
+// saveTokenResponse stores the tokens from a successful authorization as the
+// login's token.
+func saveTokenResponse(login string, resp *tokenResponse) error {
+	unlock, err := lockAuth(login)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	token := &OAuthToken{}
+	token.update(resp)
+	if err := saveAuth(login, token); err != nil {
+		return fmt.Errorf("saving auth: %w", err)
+	}
+	return nil
 }
 
 // expired returns true if the access token has expired or is about to expire.
@@ -55,8 +88,79 @@ func (t *OAuthToken) expired() bool {
 	return time.Now().Add(tokenRefreshRefresh).After(t.ExpiresAt)
 }
 
-// authFilePath returns the path to the OAuth token storage file.
-func authFilePath() (string, error) {
+// loginKey identifies a stored OAuth token: the provider's name, followed by
+// the login's name for additional logins with the same provider, e.g.
+// "resend" or "resend.work".
+func loginKey(provider, name string) string {
+	if name == "" {
+		return provider
+	}
+	return provider + "." + name
+}
+
+// splitLoginKey splits a login key into its provider and login name.
+func splitLoginKey(login string) (provider, name string) {
+	provider, name, _ = strings.Cut(login, ".")
+	return provider, name
+}
+
+// validateLoginName checks that a login name can be used in file names and
+// login keys.
+func validateLoginName(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("invalid login name %q: use letters, digits, - and _", name)
+		}
+	}
+	return nil
+}
+
+// checkNamedLogin checks that a login with the given name can be made with
+// the provider. Only Resend supports named logins, since SMTP picks its token
+// by provider.
+func checkNamedLogin(provider, name string) error {
+	if name != "" && provider != providerResend {
+		return fmt.Errorf("named logins are only supported for Resend, not %s", provider)
+	}
+	return validateLoginName(name)
+}
+
+// loginLabel returns a login's display name, e.g. "Resend (work)".
+func loginLabel(login string) string {
+	provider, name := splitLoginKey(login)
+	label := provider
+	if p, err := lookupOAuthProvider(provider); err == nil {
+		label = p.Label
+	} else if provider == accountSecretProvider {
+		label = "Account secret"
+	}
+	if name != "" {
+		label += " (" + name + ")"
+	}
+	return label
+}
+
+// authFilePath returns the path to the given login's OAuth token file.
+// Resend's default login lives in auth.json, and other logins next to it in
+// auth-<login>.json.
+func authFilePath(login string) (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	name := "auth.json"
+	if login != providerResend {
+		name = "auth-" + login + ".json"
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// dataDir returns pop's data directory, where OAuth token files and the sent
+// history are kept, creating it if needed.
+func dataDir() (string, error) {
 	dataDir, err := os.UserConfigDir()
 	if err != nil {
 		return "", fmt.Errorf("getting data directory: %w", err)
@@ -71,55 +175,45 @@ func authFilePath() (string, error) {
 	if err := os.MkdirAll(dir, 0o700); err != nil { //nolint:gosec // G703: dataDir is from a trusted source
 		return "", fmt.Errorf("creating data directory: %w", err)
 	}
-	return filepath.Join(dir, "auth.json"), nil
+	return dir, nil
 }
 
-// loadAuth reads the persisted OAuth token from disk.
-func loadAuth() (*OAuthToken, error) {
-	path, err := authFilePath()
+// loadAuth reads the given login's persisted OAuth token from the active
+// token store.
+func loadAuth(login string) (*OAuthToken, error) {
+	store, err := activeTokenStore()
 	if err != nil {
 		return nil, err
 	}
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, err //nolint:wrapcheck // caller checks os.IsNotExist
-		}
-		return nil, fmt.Errorf("reading auth file: %w", err)
-	}
-	var token OAuthToken
-	if err := json.Unmarshal(data, &token); err != nil {
-		return nil, fmt.Errorf("parsing auth file: %w", err)
-	}
-	return &token, nil
+	return store.Load(login) //nolint:wrapcheck // caller checks os.IsNotExist
 }
 
-// saveAuth writes the OAuth token to disk with restrictive permissions.
-func saveAuth(token *OAuthToken) error {
-	path, err := authFilePath()
+// saveAuth writes the given login's OAuth token to the active token store.
+func saveAuth(login string, token *OAuthToken) error {
+	store, err := activeTokenStore()
 	if err != nil {
 		return err
 	}
-	data, err := json.MarshalIndent(token, "", "  ") //nolint:gosec
+	return store.Save(login, token) //nolint:wrapcheck
+}
+
+// authLocation describes where the given login's token is kept in the
+// active token store.
+func authLocation(login string) string {
+	store, err := activeTokenStore()
 	if err != nil {
-		return fmt.Errorf("marshaling auth: %w", err)
-	}
-	if err := os.WriteFile(path, data, 0o600); err != nil {
-		return fmt.Errorf("writing auth file: %w", err)
+		return ""
 	}
-	return nil
+	return store.Location(login)
 }
 
-// deleteAuth removes the persisted OAuth token.
-func deleteAuth() error {
-	path, err := authFilePath()
+// deleteAuth removes the given login's persisted OAuth token.
+func deleteAuth(login string) error {
+	store, err := activeTokenStore()
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(path); err != nil {
-		return fmt.Errorf("deleting auth file: %w", err)
-	}
-	return nil
+	return store.Delete(login) //nolint:wrapcheck // caller checks os.IsNotExist
 }
 
 // generateCodeVerifier generates a cryptographically random PKCE code verifier.
@@ -146,16 +240,42 @@ func generateState() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-// HTTPError represents a non-successful HTTP response from the Resend API.
+// HTTPError represents a non-successful HTTP response from Resend or an
+// OAuth provider.
 type HTTPError struct {
 	Status string // e.g. "429 Too Many Requests"
 	Body   string // response body
+	// RetryAfter is how long the server asked to wait before trying again,
+	// from its Retry-After header, if it sent one.
+	RetryAfter time.Duration
 }
 
 func (e *HTTPError) Error() string {
 	return e.Status + ": " + e.Body
 }
 
+// StatusCode returns the numeric HTTP status, e.g. 429.
+func (e *HTTPError) StatusCode() int {
+	code, _, _ := strings.Cut(e.Status, " ")
+	n, _ := strconv.Atoi(code)
+	return n
+}
+
+// parseRetryAfter parses a Retry-After header, which holds either a number
+// of seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(0, time.Duration(secs)*time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(0, time.Until(t))
+	}
+	return 0
+}
+
 // tokenResponse is the response from the token endpoint.
 type tokenResponse struct {
 	AccessToken  string `json:"access_token"`
@@ -166,64 +286,70 @@ type tokenResponse struct {
 }
 
 // exchangeCode exchanges an authorization code for tokens.
-func exchangeCode(ctx context.Context, clientID, code, redirectURI, codeVerifier string) (*tokenResponse, error) {
+func exchangeCode(ctx context.Context, p *oauthProvider, code, redirectURI, codeVerifier string) (*tokenResponse, error) {
 	form := url.Values{
 		"grant_type":          {oauthGrantTypeAuthCode},
-		oauthParamClientID:    {clientID},
 		oauthResponseType:     {code},
 		oauthParamRedirectURI: {redirectURI},
 		"code_verifier":       {codeVerifier},
 	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, resendBaseURL()+"/oauth/token", strings.NewReader(form.Encode()))
-	if err != nil {
-		return nil, fmt.Errorf("creating token request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	return doTokenRequest(req)
+	return doTokenRequest(ctx, p, form)
 }
 
 // refreshToken exchanges a refresh token for a new access token.
-func refreshToken(ctx context.Context, clientID, refreshTok string) (*tokenResponse, error) {
+func refreshToken(ctx context.Context, p *oauthProvider, refreshTok string) (*tokenResponse, error) {
 	form := url.Values{
-		"grant_type":       {oauthGrantTypeRefreshToken},
-		oauthParamClientID: {clientID},
-		"refresh_token":    {refreshTok},
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, resendBaseURL()+"/oauth/token", strings.NewReader(form.Encode()))
-	if err != nil {
-		return nil, fmt.Errorf("creating refresh request: %w", err)
+		"grant_type":    {oauthGrantTypeRefreshToken},
+		"refresh_token": {refreshTok},
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	return doTokenRequest(req)
+	return doTokenRequest(ctx, p, form)
 }
 
-// revokeToken revokes a refresh token.
-func revokeToken(ctx context.Context, clientID, token string) error {
+// revokeToken revokes a refresh token and returns the revocation endpoint's
+// HTTP status. A status other than 2xx is also returned as an *HTTPError. It's
+// a no-op returning an empty status for providers without a revocation
+// endpoint.
+func revokeToken(ctx context.Context, p *oauthProvider, token string) (string, error) {
+	if p.RevokeURL == "" {
+		return "", nil
+	}
 	form := url.Values{
-		oauthParamClientID: {clientID},
+		oauthParamClientID: {p.ClientID},
 		"token":            {token},
 		"token_type_hint":  {oauthGrantTypeRefreshToken},
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, resendBaseURL()+"/oauth/revoke", strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.RevokeURL, strings.NewReader(form.Encode()))
 	if err != nil {
-		return fmt.Errorf("creating revoke request: %w", err)
+		return "", fmt.Errorf("creating revoke request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("revoking token: %w", err)
+		return "", fmt.Errorf("revoking token: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
-	return nil
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Status, &HTTPError{Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	}
+	return resp.Status, nil
 }
 
-func doTokenRequest(req *http.Request) (*tokenResponse, error) {
+// doTokenRequest posts form to the provider's token endpoint along with the
+// client credentials.
+func doTokenRequest(ctx context.Context, p *oauthProvider, form url.Values) (*tokenResponse, error) {
+	form.Set(oauthParamClientID, p.ClientID)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("token request: %w", err)
@@ -249,40 +375,185 @@ type callbackResult struct {
 	err  error
 }
 
-// openCallbackListener binds an ephemeral loopback port for the OAuth redirect
-// and returns the listener along with the redirect URI to advertise to the
-// provider.
-func openCallbackListener(ctx context.Context) (net.Listener, string, error) {
+// openCallbackListener binds a loopback port for the OAuth redirect and
+// returns the listener along with the redirect URI to advertise to the
+// provider. A port of 0 picks an ephemeral one; a fixed port makes it
+// possible to forward the callback over SSH.
+func openCallbackListener(ctx context.Context, port int) (net.Listener, string, error) {
 	lc := net.ListenConfig{}
-	listener, err := lc.Listen(ctx, "tcp", oauthCallbackHost+":0")
+	listener, err := lc.Listen(ctx, "tcp", net.JoinHostPort(oauthCallbackHost, strconv.Itoa(port)))
 	if err != nil {
 		return nil, "", fmt.Errorf("starting callback server: %w", err)
 	}
-	port := listener.Addr().(*net.TCPAddr).Port
+	port = listener.Addr().(*net.TCPAddr).Port
 	redirectURI := fmt.Sprintf("http://%s:%d%s", oauthCallbackHost, port, oauthRedirectPath)
 	return listener, redirectURI, nil
 }
 
-// buildAuthURL constructs the Resend authorization endpoint URL with the
+// buildAuthURL constructs the provider's authorization endpoint URL with the
 // PKCE parameters required by the code flow.
-func buildAuthURL(clientID, redirectURI, state, codeChallenge string) (string, error) {
-	authURL, err := url.Parse(resendBaseURL() + "/oauth/authorize")
+func buildAuthURL(p *oauthProvider, redirectURI, state, codeChallenge string) (string, error) {
+	authURL, err := url.Parse(p.AuthURL)
 	if err != nil {
 		return "", fmt.Errorf("parsing authorization URL: %w", err)
 	}
-	params := url.Values{
-		oauthParamClientID:      {clientID},
-		"response_type":         {oauthResponseType},
-		oauthParamRedirectURI:   {redirectURI},
-		oauthParamScope:         {oauthScope},
-		"state":                 {state},
-		"code_challenge":        {codeChallenge},
-		"code_challenge_method": {"S256"},
+	params := authURL.Query()
+	params.Set(oauthParamClientID, p.ClientID)
+	params.Set("response_type", oauthResponseType)
+	params.Set(oauthParamRedirectURI, redirectURI)
+	params.Set(oauthParamScope, strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+	for k, v := range p.AuthParams {
+		params.Set(k, v)
 	}
 	authURL.RawQuery = params.Encode()
 	return authURL.String(), nil
 }
 
+// parseCallbackInput extracts the authorization code from what the user
+// pasted in manual mode: either the whole URL the provider redirected to, or
+// just the code. The state is checked when the URL carries one.
+func parseCallbackInput(input, state string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", errors.New("nothing pasted")
+	}
+	if !strings.Contains(input, "code=") && !strings.Contains(input, "error=") {
+		return input, nil
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		return "", fmt.Errorf("parsing pasted URL: %w", err)
+	}
+	query := u.Query()
+	if u.RawQuery == "" {
+		// A bare query string, e.g. "code=...&state=...".
+		query, _ = url.ParseQuery(input)
+	}
+	if errVal := query.Get("error"); errVal != "" {
+		return "", fmt.Errorf("OAuth error: %s", errVal)
+	}
+	code := query.Get("code")
+	if code == "" {
+		return "", errors.New("missing authorization code")
+	}
+	if got := query.Get("state"); got != "" && got != state {
+		return "", errors.New("state mismatch")
+	}
+	return code, nil
+}
+
+// deviceAuthorization is the response from a device authorization endpoint
+// (RFC 8628).
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+
+	// VerificationURL is Google's name for verification_uri.
+	VerificationURL string `json:"verification_url"`
+}
+
+// verificationURI returns the URL the user visits to enter the code.
+func (d *deviceAuthorization) verificationURI() string {
+	if d.VerificationURI != "" {
+		return d.VerificationURI
+	}
+	return d.VerificationURL
+}
+
+// requestDeviceCode starts the device authorization grant.
+func requestDeviceCode(ctx context.Context, p *oauthProvider) (*deviceAuthorization, error) {
+	if p.DeviceAuthURL == "" {
+		return nil, fmt.Errorf("%s doesn't support device authorization", p.Label)
+	}
+	form := url.Values{
+		oauthParamClientID: {p.ClientID},
+		oauthParamScope:    {strings.Join(p.Scopes, " ")},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.DeviceAuthURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating device authorization request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &HTTPError{Status: resp.Status, Body: string(body)}
+	}
+	var dev deviceAuthorization
+	if err := json.NewDecoder(resp.Body).Decode(&dev); err != nil {
+		return nil, fmt.Errorf("decoding device authorization response: %w", err)
+	}
+	if dev.DeviceCode == "" || dev.UserCode == "" {
+		return nil, errors.New("device authorization response is missing the device or user code")
+	}
+	return &dev, nil
+}
+
+// deviceFlowSecond is the unit of the intervals and expiry in a device
+// authorization. It's a variable so tests can poll without waiting seconds.
+var deviceFlowSecond = time.Second
+
+// pollDeviceToken polls the token endpoint until the user approves or denies
+// the device authorization, or it expires.
+func pollDeviceToken(ctx context.Context, p *oauthProvider, dev *deviceAuthorization) (*tokenResponse, error) {
+	interval := time.Duration(dev.Interval) * deviceFlowSecond
+	if interval <= 0 {
+		interval = 5 * deviceFlowSecond //nolint:mnd
+	}
+	if dev.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(dev.ExpiresIn)*deviceFlowSecond)
+		defer cancel()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errors.New("device authorization timed out")
+		case <-time.After(interval):
+		}
+
+		tokResp, err := doTokenRequest(ctx, p, url.Values{
+			"grant_type":  {oauthGrantTypeDeviceCode},
+			"device_code": {dev.DeviceCode},
+		})
+		if err == nil {
+			return tokResp, nil
+		}
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) {
+			return nil, err
+		}
+		var oauthErr struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal([]byte(httpErr.Body), &oauthErr)
+		switch oauthErr.Error {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * deviceFlowSecond //nolint:mnd
+		case "access_denied":
+			return nil, errors.New("authorization denied")
+		case "expired_token":
+			return nil, errors.New("device code expired")
+		default:
+			return nil, err
+		}
+	}
+}
+
 // newCallbackServer builds the OAuth callback HTTP server and starts serving
 // on the given listener in a goroutine. It pushes the authorization code or
 // an error onto the returned channel once the callback fires. The caller is
@@ -326,10 +597,11 @@ func newCallbackServer(state string, listener net.Listener) (*http.Server, chan
 	return server, resultCh
 }
 
-// getValidAccessToken returns a valid access token, refreshing if necessary.
-// If no stored auth exists, it returns an empty string and no error.
-func getValidAccessToken() (string, error) {
-	token, err := loadAuth()
+// getValidAccessToken returns a valid access token for the given login,
+// refreshing if necessary. If no stored auth exists, it returns an empty
+// string and no error.
+func getValidAccessToken(login string) (string, error) {
+	token, err := loadAuth(login)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return "", nil
@@ -341,23 +613,101 @@ func getValidAccessToken() (string, error) {
 		return token.AccessToken, nil
 	}
 
-	// Refresh the token.
+	provider, _ := splitLoginKey(login)
+	p, err := lookupOAuthProvider(provider)
+	if err != nil {
+		return "", err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	tokResp, err := refreshToken(ctx, oauthClientID, token.RefreshToken)
+	token, err = refreshAuth(ctx, p, login)
+	if err != nil && refreshRejected(err) {
+		// The refresh token is no good any more: the user needs to
+		// re-authenticate.
+		return "", &reauthError{
+			login: login,
+			err:   fmt.Errorf("token refresh failed, please run '%s' again: %w", authCommand(login), err),
+		}
+	}
 	if err != nil {
-		// Refresh failed — the user needs to re-authenticate.
-		return "", fmt.Errorf("token refresh failed, please run 'pop auth' again: %w", err)
+		// The provider couldn't be reached, or had a problem of its own:
+		// trying again later may work.
+		return "", fmt.Errorf("refreshing %s token: %w", login, err)
 	}
+	return token.AccessToken, nil
+}
 
-	token.AccessToken = tokResp.AccessToken
-	token.RefreshToken = tokResp.RefreshToken
-	token.ExpiresAt = time.Now().Add(time.Duration(tokResp.ExpiresIn) * time.Second)
+// refreshRejected reports whether the token endpoint turned a refresh down
+// for good, with a 4xx such as invalid_grant or invalid_client, rather than
+// failing for a reason that may pass, like a network error, a timeout, a 5xx
+// or a 429.
+func refreshRejected(err error) bool {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	code := httpErr.StatusCode()
+	return code >= 400 && code < 500 && code != http.StatusTooManyRequests
+}
 
-	if err := saveAuth(token); err != nil {
-		return "", fmt.Errorf("saving refreshed token: %w", err)
+// refreshAuth refreshes the login's stored token with provider p. It holds
+// the login's lock throughout and re-reads the token once it has it, so
+// concurrent pop processes don't all spend the same refresh token: with
+// providers that rotate refresh tokens, every process but the first would be
+// logged out.
+func refreshAuth(ctx context.Context, p *oauthProvider, login string) (*OAuthToken, error) {
+	unlock, err := lockAuth(login)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
 
-	return token.AccessToken, nil
+	token, err := loadAuth(login)
+	if err != nil {
+		return nil, err
+	}
+	if !token.expired() {
+		// Another process refreshed it while we waited for the lock.
+		return token, nil
+	}
+	tokResp, err := refreshToken(ctx, p, token.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	token.update(tokResp)
+	if err := saveAuth(login, token); err != nil {
+		return nil, fmt.Errorf("saving refreshed token: %w", err)
+	}
+	return token, nil
+}
+
+// reauthError is returned when a login's stored token is missing or can't be
+// refreshed, and the user has to authenticate again.
+type reauthError struct {
+	login string
+	err   error
+}
+
+func (e *reauthError) Error() string { return e.err.Error() }
+
+func (e *reauthError) Unwrap() error { return e.err }
+
+// authCommand returns the command that authenticates the given login.
+func authCommand(login string) string {
+	return "pop " + strings.Join(authArgs(login), " ")
+}
+
+// authArgs returns the arguments to pop that authenticate the given login.
+func authArgs(login string) []string {
+	provider, name := splitLoginKey(login)
+	args := []string{"auth"}
+	if provider != providerResend {
+		args = append(args, provider)
+	}
+	if name != "" {
+		args = append(args, "--name", name)
+	}
+	return args
 }
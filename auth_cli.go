@@ -1,14 +1,37 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/pkg/browser"
 )
 
+// authOptions controls how the OAuth flow reaches the user's browser.
+type authOptions struct {
+	// noBrowser skips opening a browser and just shows the URL.
+	noBrowser bool
+	// device uses the device authorization grant instead of a redirect.
+	device bool
+	// manual has the user paste the redirected URL or code back in.
+	manual bool
+	// callbackPort is the loopback port for the redirect, or 0 for an
+	// ephemeral one.
+	callbackPort int
+	// name stores the token as a named login, alongside the provider's
+	// default one.
+	name string
+}
+
+// login returns the key of the login the flow authenticates with provider p.
+func (o authOptions) login(p *oauthProvider) string {
+	return loginKey(p.Name, o.name)
+}
+
 // openBrowser opens the given URL in the user's default browser. If the
 // browser can't be opened, the URL is printed for the user to open manually.
 func openBrowser(rawURL string) {
@@ -23,28 +46,55 @@ func openBrowser(rawURL string) {
 // It is used when stdin is not a terminal (piped input, SSH sessions with port
 // forwarding, or anywhere a Bubble Tea program is unsuitable). For interactive
 // terminal sessions, auth_ui.go runs an equivalent flow with a TUI.
-func startOAuthFlow() error {
+func startOAuthFlow(p *oauthProvider, opts authOptions) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	listener, redirectURI, err := openCallbackListener(ctx)
+	var (
+		tokResp *tokenResponse
+		err     error
+	)
+	if opts.device {
+		tokResp, err = deviceFlow(ctx, p)
+	} else {
+		tokResp, err = codeFlow(ctx, p, opts)
+	}
 	if err != nil {
 		return err
 	}
 
+	if err := saveTokenResponse(opts.login(p), tokResp); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully authenticated with %s!\n", loginLabel(opts.login(p)))
+	return nil
+}
+
+// codeFlow runs the authorization code flow, waiting for the loopback
+// callback or, in manual mode, for the user to paste the redirected URL.
+func codeFlow(ctx context.Context, p *oauthProvider, opts authOptions) (*tokenResponse, error) {
+	listener, redirectURI, err := openCallbackListener(ctx, opts.callbackPort)
+	if err != nil {
+		return nil, err
+	}
+
 	codeVerifier, err := generateCodeVerifier()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	codeChallenge := generateCodeChallenge(codeVerifier)
 	state, err := generateState()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	authURL, err := buildAuthURL(oauthClientID, redirectURI, state, codeChallenge)
+	authURL, err := buildAuthURL(p, redirectURI, state, codeChallenge)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	server, resultCh := newCallbackServer(state, listener)
@@ -54,35 +104,51 @@ func startOAuthFlow() error {
 		_ = server.Shutdown(shutdownCtx)
 	}()
 
-	fmt.Printf("Opening browser for authorization...\n")
-	openBrowser(authURL)
-	fmt.Printf("Browser not opening? Pay a visit to:\n  %s\n", authURL)
+	if opts.manual {
+		fmt.Printf("Open this URL in a browser on any machine:\n  %s\n", authURL)
+		fmt.Printf("Once you've approved access the browser will try to load a %s page that won't open. Paste its full URL (or just the code) here:\n", oauthCallbackHost)
+		go func() {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			res := callbackResult{err: fmt.Errorf("reading pasted URL: %w", err)}
+			if line != "" {
+				res.code, res.err = parseCallbackInput(line, state)
+			}
+			// The callback may have fired in the meantime.
+			select {
+			case resultCh <- res:
+			default:
+			}
+		}()
+	} else {
+		fmt.Printf("Opening browser for authorization...\n")
+		if !opts.noBrowser {
+			openBrowser(authURL)
+		}
+		fmt.Printf("Browser not opening? Pay a visit to:\n  %s\n", authURL)
+	}
 
 	var authCode string
 	select {
 	case res := <-resultCh:
 		if res.err != nil {
-			return res.err
+			return nil, res.err
 		}
 		authCode = res.code
 	case <-ctx.Done():
-		return errors.New("authorization timed out")
+		return nil, errors.New("authorization timed out")
 	}
 
-	tokResp, err := exchangeCode(ctx, oauthClientID, authCode, redirectURI, codeVerifier)
-	if err != nil {
-		return err
-	}
+	return exchangeCode(ctx, p, authCode, redirectURI, codeVerifier)
+}
 
-	token := &OAuthToken{
-		AccessToken:  tokResp.AccessToken,
-		RefreshToken: tokResp.RefreshToken,
-		ExpiresAt:    time.Now().Add(time.Duration(tokResp.ExpiresIn) * time.Second),
-	}
-	if err := saveAuth(token); err != nil {
-		return fmt.Errorf("saving auth: %w", err)
+// deviceFlow runs the device authorization grant: show a code for the user to
+// enter on another device, then poll until they approve.
+func deviceFlow(ctx context.Context, p *oauthProvider) (*tokenResponse, error) {
+	dev, err := requestDeviceCode(ctx, p)
+	if err != nil {
+		return nil, err
 	}
-
-	fmt.Println("Successfully authenticated with Resend!")
-	return nil
+	fmt.Printf("On any device, visit:\n  %s\nand enter the code:\n  %s\n", dev.verificationURI(), dev.UserCode)
+	fmt.Printf("Waiting for authorization...\n")
+	return pollDeviceToken(ctx, p, dev)
 }
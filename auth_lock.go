@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockAuth takes an exclusive lock on the given provider's token, waiting for
+// other pop processes to release it, and returns a function that releases
+// it. The lock is a file next to the token files, so it covers every token
+// store.
+func lockAuth(provider string) (func(), error) {
+	path, err := authFilePath(provider)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("opening auth lock: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("locking auth: %w", err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
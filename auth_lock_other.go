@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// Platforms without file locking don't guard against concurrent refreshes.
+
+func lockFile(*os.File) error { return nil }
+
+func unlockFile(*os.File) error { return nil }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rotatingTokenServer is a token endpoint that rotates refresh tokens, like
+// Microsoft's: each refresh token can be exchanged once.
+type rotatingTokenServer struct {
+	mu        sync.Mutex
+	current   string
+	exchanges atomic.Int32
+}
+
+func (s *rotatingTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Give concurrent refreshes time to pile up.
+	time.Sleep(50 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.FormValue("grant_type") != oauthGrantTypeRefreshToken || r.FormValue("refresh_token") != s.current {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+	n := s.exchanges.Add(1)
+	s.current = fmt.Sprintf("refresh-%d", n)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  fmt.Sprintf("access-%d", n),
+		"refresh_token": s.current,
+		"expires_in":    3600,
+	})
+}
+
+// useRotatingTokenServer starts a rotating token endpoint for Google logins,
+// with an expired token stored whose refresh token it takes.
+func useRotatingTokenServer(t *testing.T) *rotatingTokenServer {
+	t.Helper()
+	useTestDirs(t)
+	ts := &rotatingTokenServer{current: "old-refresh"}
+	srv := httptest.NewServer(ts)
+	t.Cleanup(srv.Close)
+	t.Setenv("POP_GOOGLE_CLIENT_ID", "test-client")
+	t.Setenv("POP_GOOGLE_TOKEN_URL", srv.URL)
+	saveExpiredToken(t, providerGoogle)
+	return ts
+}
+
+func TestRefreshAuthConcurrentGoroutines(t *testing.T) {
+	ts := useRotatingTokenServer(t)
+	p, err := lookupOAuthProvider(providerGoogle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	tokens := make([]string, n)
+	errs := make([]error, n)
+	for i := range n {
+		wg.Go(func() {
+			token, err := refreshAuth(context.Background(), p, providerGoogle)
+			if err == nil {
+				tokens[i] = token.AccessToken
+			}
+			errs[i] = err
+		})
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("refresh %d: %v", i, err)
+		} else if tokens[i] != "access-1" {
+			t.Errorf("refresh %d got access token %q, want access-1", i, tokens[i])
+		}
+	}
+	if got := ts.exchanges.Load(); got != 1 {
+		t.Errorf("refresh token exchanged %d times, want 1", got)
+	}
+}
+
+// refreshHelperEnv makes the test binary refresh the stored token and exit,
+// standing in for another pop process.
+const refreshHelperEnv = "POP_TEST_REFRESH_HELPER"
+
+func TestRefreshAuthConcurrentProcesses(t *testing.T) {
+	if os.Getenv(refreshHelperEnv) != "" {
+		t.Skip("running as a helper process")
+	}
+	ts := useRotatingTokenServer(t)
+
+	const n = 4
+	cmds := make([]*exec.Cmd, n)
+	for i := range cmds {
+		cmds[i] = exec.Command(os.Args[0], "-test.run=^TestRefreshAuthHelperProcess$") //nolint:gosec
+		cmds[i].Env = append(os.Environ(), refreshHelperEnv+"=1")
+		if err := cmds[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("process %d: %v", i, err)
+		}
+	}
+
+	if got := ts.exchanges.Load(); got != 1 {
+		t.Errorf("refresh token exchanged %d times, want 1", got)
+	}
+	token, err := loadAuth(providerGoogle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.RefreshToken != "refresh-1" {
+		t.Errorf("stored refresh token = %q, want refresh-1", token.RefreshToken)
+	}
+}
+
+// TestRefreshAuthHelperProcess is run by TestRefreshAuthConcurrentProcesses
+// in separate processes.
+func TestRefreshAuthHelperProcess(t *testing.T) {
+	if os.Getenv(refreshHelperEnv) == "" {
+		t.Skip("only run as a helper process")
+	}
+	p, err := lookupOAuthProvider(providerGoogle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := refreshAuth(context.Background(), p, providerGoogle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" {
+		t.Fatalf("access token = %q, want access-1", token.AccessToken)
+	}
+}
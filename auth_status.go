@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/spf13/cobra"
+)
+
+var authStatusJSON bool
+
+// AuthStatusCmd is the cobra command that shows the stored OAuth logins.
+var AuthStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show stored OAuth logins",
+	Long:  `Shows every OAuth login in the token store: when its token expires, what it's scoped to, the account it belongs to and where it's kept. Expired tokens aren't refreshed.`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+		defer cancel()
+		status, err := authStatus(ctx)
+		if err != nil {
+			return err
+		}
+		if authStatusJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(status); err != nil {
+				return fmt.Errorf("encoding status: %w", err)
+			}
+			return nil
+		}
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		_, _ = fmt.Fprint(w, status.String())
+		return nil
+	},
+}
+
+// loginStatus describes one stored OAuth login.
+type loginStatus struct {
+	Login     string    `json:"login"`
+	Provider  string    `json:"provider"`
+	Name      string    `json:"name,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Expired   bool      `json:"expired"`
+	Scope     string    `json:"scope,omitempty"`
+	// Account describes the account the token belongs to, as far as the
+	// provider tells: for Resend, its domains.
+	Account  string `json:"account,omitempty"`
+	Location string `json:"location"`
+	Error    string `json:"error,omitempty"`
+}
+
+// authStatusReport is the output of `pop auth status`.
+type authStatusReport struct {
+	Store  string        `json:"store"`
+	Logins []loginStatus `json:"logins"`
+}
+
+// authStatus describes every login in the active token store.
+func authStatus(ctx context.Context) (*authStatusReport, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	store, err := activeTokenStore()
+	if err != nil {
+		return nil, err
+	}
+	logins, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &authStatusReport{Store: configuredTokenStore(cfg), Logins: []loginStatus{}}
+	for _, login := range logins {
+		provider, name := splitLoginKey(login)
+		if provider == accountSecretProvider {
+			continue
+		}
+		ls := loginStatus{Login: login, Provider: provider, Name: name, Location: store.Location(login)}
+		token, err := store.Load(login)
+		if err != nil {
+			ls.Error = err.Error()
+			report.Logins = append(report.Logins, ls)
+			continue
+		}
+		ls.ExpiresAt = token.ExpiresAt
+		ls.Expired = token.expired()
+		ls.Scope = token.Scope
+		if provider == providerResend && !ls.Expired {
+			ls.Account, err = resendAccount(ctx, token.AccessToken)
+			if err != nil {
+				ls.Error = err.Error()
+			}
+		}
+		report.Logins = append(report.Logins, ls)
+	}
+	return report, nil
+}
+
+// resendAccount describes the Resend account an access token belongs to by
+// its domains, since the API has no way to ask for the account itself.
+func resendAccount(ctx context.Context, accessToken string) (string, error) {
+	domains, err := listResendDomains(ctx, accessToken)
+	if errors.Is(err, errDomainsUnavailable) {
+		return "unknown (sign in again to let pop read domains)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(domains) == 0 {
+		return "no domains", nil
+	}
+	names := make([]string, len(domains))
+	for i, d := range domains {
+		names[i] = d.Name
+	}
+	return strings.Join(names, ", "), nil
+}
+
+// String renders the status for humans.
+func (r *authStatusReport) String() string {
+	const gap = "  "
+
+	var s strings.Builder
+	s.WriteString("\n")
+	label := labelStyle.Width(10) //nolint:mnd
+	row := func(name, value string) {
+		fmt.Fprintf(&s, "%s%s%s %s\n", gap, gap, label.Render(name), value)
+	}
+
+	fmt.Fprintf(&s, "%s%s %s\n\n", gap, labelStyle.Render("token store"), r.Store)
+	if len(r.Logins) == 0 {
+		fmt.Fprintf(&s, "%s%s\n\n", gap, placeholderStyle.Render("No OAuth logins. Run `pop auth` to sign in."))
+		return s.String()
+	}
+	for _, ls := range r.Logins {
+		fmt.Fprintf(&s, "%s%s\n", gap, activeLabelStyle.Render(loginLabel(ls.Login)))
+		if !ls.ExpiresAt.IsZero() {
+			expires := ls.ExpiresAt.Local().Format(time.DateTime)
+			if ls.Expired {
+				expires = errorStyle.Render("expired "+expires) + commentStyle.Render("(refreshed on next use)")
+			} else {
+				expires += commentStyle.Render("(in " + strings.TrimSuffix(time.Until(ls.ExpiresAt).Round(time.Minute).String(), "0s") + ")")
+			}
+			row("expires", expires)
+			row("scope", ordefault(ls.Scope, placeholderStyle.Render("unknown")))
+		}
+		if ls.Account != "" {
+			row("account", ls.Account)
+		}
+		row("location", tildePath(ls.Location))
+		if ls.Error != "" {
+			row("error", errorStyle.Render(ls.Error))
+		}
+		if ls.Provider == providerResend {
+			send := "pop --oauth"
+			if ls.Name != "" {
+				send = "pop --oauth.name " + ls.Name
+			}
+			row("send with", inlineCodeStyle.Render(send))
+		}
+		row("sign in", inlineCodeStyle.Render(authCommand(ls.Login)))
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+// completeLoginNames completes the names of the stored Resend OAuth logins.
+func completeLoginNames(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	store, err := activeTokenStore()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	logins, err := store.List()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, login := range logins {
+		if provider, name := splitLoginKey(login); provider == providerResend && name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	AuthStatusCmd.Flags().BoolVar(&authStatusJSON, "json", false, "Print the status as JSON")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthStatus(t *testing.T) {
+	useTestDirs(t)
+	useAccounts(t, "")
+	useResendAuthServer(t, &fakeAuthServer{}, &domainsStandIn{domains: map[string]map[string]string{
+		"resend-access": {"example.com": "verified"},
+	}})
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	tokens := map[string]*OAuthToken{
+		providerResend:                       {AccessToken: "resend-access", RefreshToken: "r", ExpiresAt: expires, Scope: "emails:send domains:read"},
+		loginKey(providerResend, "work"):     {AccessToken: "work-access", RefreshToken: "r", ExpiresAt: time.Now().Add(-time.Hour)},
+		loginKey(providerResend, "side"):     {AccessToken: "side-access", RefreshToken: "r", ExpiresAt: expires},
+		providerGoogle:                       {AccessToken: "google-access", RefreshToken: "r", ExpiresAt: expires, Scope: "https://mail.google.com/"},
+		loginKey(accountSecretProvider, "x"): {AccessToken: "password"},
+	}
+	for login, token := range tokens {
+		if err := saveAuth(login, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := authStatus(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Store != tokenStoreFile {
+		t.Errorf("store = %q, want %q", report.Store, tokenStoreFile)
+	}
+	got := map[string]loginStatus{}
+	for _, ls := range report.Logins {
+		got[ls.Login] = ls
+	}
+	if len(got) != 4 {
+		t.Errorf("listed %d logins, want the 4 OAuth ones", len(report.Logins))
+	}
+	if ls := got[providerResend]; ls.Expired || !ls.ExpiresAt.Equal(expires) || ls.Scope != "emails:send domains:read" || ls.Account != "example.com" || ls.Error != "" || ls.Location == "" {
+		t.Errorf("Resend login = %+v, want it valid on the example.com account", ls)
+	}
+	if ls := got["resend.work"]; !ls.Expired || ls.Name != "work" || ls.Account != "" || ls.Error != "" {
+		t.Errorf("expired work login = %+v, want it expired and left alone", ls)
+	}
+	// The stand-in turns down tokens it doesn't know, like a sending-only
+	// one.
+	if ls := got["resend.side"]; ls.Expired || !strings.HasPrefix(ls.Account, "unknown") {
+		t.Errorf("side login = %+v, want its account unknown", ls)
+	}
+	if ls := got[providerGoogle]; ls.Provider != providerGoogle || ls.Account != "" || ls.Scope != "https://mail.google.com/" {
+		t.Errorf("Google login = %+v", ls)
+	}
+
+	out := report.String()
+	for _, want := range []string{"Resend (work)", "expired", "example.com", "pop --oauth.name side", "pop auth google"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status doesn't mention %q:\n%s", want, out)
+		}
+	}
+}
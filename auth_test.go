@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useTestDirs points pop's config, data and cache directories at fresh
+// temporary ones, with tokens in the plaintext file store.
+func useTestDirs(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv(PopTokenStore, tokenStoreFile)
+}
+
+// saveExpiredToken stores an expired token for the login, so the next use
+// refreshes it.
+func saveExpiredToken(t *testing.T, login string) {
+	t.Helper()
+	err := saveAuth(login, &OAuthToken{
+		AccessToken:  "old-access",
+		RefreshToken: "old-refresh",
+		ExpiresAt:    time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("saving token: %v", err)
+	}
+}
+
+func TestGetValidAccessTokenRefreshFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantReauth bool
+	}{
+		{"invalid grant", http.StatusBadRequest, `{"error":"invalid_grant"}`, true},
+		{"invalid client", http.StatusUnauthorized, `{"error":"invalid_client"}`, true},
+		{"rate limited", http.StatusTooManyRequests, `{"error":"slow_down"}`, false},
+		{"server error", http.StatusInternalServerError, `oops`, false},
+		{"unavailable", http.StatusServiceUnavailable, ``, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDirs(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			t.Setenv("POP_GOOGLE_CLIENT_ID", "test-client")
+			t.Setenv("POP_GOOGLE_TOKEN_URL", srv.URL)
+			saveExpiredToken(t, providerGoogle)
+
+			_, err := getValidAccessToken(providerGoogle)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var reauth *reauthError
+			if got := errors.As(err, &reauth); got != tt.wantReauth {
+				t.Errorf("reauthError = %v, want %v (error: %v)", got, tt.wantReauth, err)
+			}
+		})
+	}
+}
+
+func TestGetValidAccessTokenUnreachable(t *testing.T) {
+	useTestDirs(t)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	t.Setenv("POP_GOOGLE_CLIENT_ID", "test-client")
+	t.Setenv("POP_GOOGLE_TOKEN_URL", url)
+	saveExpiredToken(t, providerGoogle)
+
+	_, err := getValidAccessToken(providerGoogle)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	var reauth *reauthError
+	if errors.As(err, &reauth) {
+		t.Errorf("a network error asked to sign in again: %v", err)
+	}
+}
+
+// fakeAuthServer is an authorization server for the code flow with PKCE. It
+// approves every request, and only issues a token for the code it handed out
+// when the verifier matches the challenge it was given.
+type fakeAuthServer struct {
+	mu          sync.Mutex
+	challenge   string
+	method      string
+	redirectURI string
+	// revokeStatus is the status revocations get, 200 OK if unset, and
+	// revoked the tokens it was asked to revoke.
+	revokeStatus int
+	revoked      []string
+}
+
+const fakeAuthCode = "the-code"
+
+func (s *fakeAuthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/authorize":
+		q := r.URL.Query()
+		if q.Get("client_id") != "test-client" || q.Get("response_type") != "code" {
+			http.Error(w, "bad authorization request", http.StatusBadRequest)
+			return
+		}
+		s.challenge, s.method, s.redirectURI = q.Get("code_challenge"), q.Get("code_challenge_method"), q.Get("redirect_uri")
+		http.Redirect(w, r, s.redirectURI+"?"+url.Values{"code": {fakeAuthCode}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	case "/token":
+		if r.FormValue("grant_type") != oauthGrantTypeAuthCode ||
+			r.FormValue("code") != fakeAuthCode ||
+			r.FormValue("redirect_uri") != s.redirectURI ||
+			s.method != "S256" ||
+			generateCodeChallenge(r.FormValue("code_verifier")) != s.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"access","refresh_token":"refresh","expires_in":3600}`))
+	case "/revoke":
+		s.revoked = append(s.revoked, r.FormValue("token"))
+		if s.revokeStatus != 0 && s.revokeStatus != http.StatusOK {
+			w.WriteHeader(s.revokeStatus)
+			_, _ = w.Write([]byte(`{"error":"temporarily_unavailable"}`))
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// useResendAuthServer points Resend at a stand-in serving auth's OAuth
+// endpoints under /oauth and domains' /domains.
+func useResendAuthServer(t *testing.T, auth *fakeAuthServer, domains *domainsStandIn) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle("/oauth/", http.StripPrefix("/oauth", auth))
+	mux.Handle("/domains", domains)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Setenv("RESEND_BASE_URL", srv.URL)
+}
+
+// authorize runs the browser's part of the code flow: it follows authURL to
+// the callback server and returns the code the callback server got.
+func authorize(t *testing.T, authURL string, results chan callbackResult) string {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, authURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback status = %s", resp.Status)
+	}
+	result := <-results
+	if result.err != nil {
+		t.Fatalf("callback: %v", result.err)
+	}
+	return result.code
+}
+
+func TestCodeFlowPKCE(t *testing.T) {
+	srv := httptest.NewServer(&fakeAuthServer{})
+	t.Cleanup(srv.Close)
+	p := &oauthProvider{
+		Name:     "test",
+		ClientID: "test-client",
+		AuthURL:  srv.URL + "/authorize",
+		TokenURL: srv.URL + "/token",
+	}
+
+	tests := []struct {
+		name string
+		// verifier returns the verifier sent with the code, given the
+		// one the challenge was made from.
+		verifier func(string) string
+		wantErr  bool
+	}{
+		{name: "matching verifier", verifier: func(v string) string { return v }},
+		{name: "other verifier", verifier: func(string) string {
+			v, _ := generateCodeVerifier()
+			return v
+		}, wantErr: true},
+		{name: "challenge instead of verifier", verifier: generateCodeChallenge, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := generateCodeVerifier()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// RFC 7636 allows 43 to 128 characters.
+			if len(verifier) < 43 || len(verifier) > 128 {
+				t.Fatalf("verifier is %d characters long", len(verifier))
+			}
+			state, err := generateState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			listener, redirectURI, err := openCallbackListener(t.Context(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, results := newCallbackServer(state, listener)
+			t.Cleanup(func() { _ = server.Close() })
+
+			authURL, err := buildAuthURL(p, redirectURI, state, generateCodeChallenge(verifier))
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := authorize(t, authURL, results)
+
+			resp, err := exchangeCode(context.Background(), p, code, redirectURI, tt.verifier(verifier))
+			var httpErr *HTTPError
+			switch {
+			case tt.wantErr && (!errors.As(err, &httpErr) || !strings.Contains(httpErr.Body, "invalid_grant")):
+				t.Fatalf("exchangeCode error = %v, want invalid_grant", err)
+			case !tt.wantErr && err != nil:
+				t.Fatalf("exchangeCode: %v", err)
+			case !tt.wantErr && resp.AccessToken != "access":
+				t.Errorf("access token = %q, want access", resp.AccessToken)
+			}
+		})
+	}
+}
+
+func TestCallbackStateMismatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"other state", url.Values{"code": {fakeAuthCode}, "state": {"forged"}}},
+		{"no state", url.Values{"code": {fakeAuthCode}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, redirectURI, err := openCallbackListener(t.Context(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, results := newCallbackServer("expected", listener)
+			t.Cleanup(func() { _ = server.Close() })
+
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, redirectURI+"?"+tt.query.Encode(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("callback status = %s, want 400", resp.Status)
+			}
+			if result := <-results; result.err == nil || result.code != "" {
+				t.Errorf("callback result = %+v, want a state mismatch", result)
+			}
+		})
+	}
+
+	t.Run("pasted URL", func(t *testing.T) {
+		_, err := parseCallbackInput("http://127.0.0.1:1/oauth/callback?code="+fakeAuthCode+"&state=forged", "expected")
+		if err == nil || !strings.Contains(err.Error(), "state mismatch") {
+			t.Errorf("parseCallbackInput error = %v, want a state mismatch", err)
+		}
+	})
+}
+
+// fakeDeviceServer is a device authorization server that answers the first
+// polls with the given errors before issuing a token.
+type fakeDeviceServer struct {
+	mu      sync.Mutex
+	answers []string
+	polls   []time.Time
+}
+
+func (s *fakeDeviceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/device":
+		_ = json.NewEncoder(w).Encode(deviceAuthorization{
+			DeviceCode:      "device-code",
+			UserCode:        "ABCD-EFGH",
+			VerificationURL: "https://example.com/device",
+			ExpiresIn:       600,
+			Interval:        1,
+		})
+	case "/token":
+		if r.FormValue("grant_type") != oauthGrantTypeDeviceCode || r.FormValue("device_code") != "device-code" {
+			http.Error(w, "bad token request", http.StatusBadRequest)
+			return
+		}
+		s.polls = append(s.polls, time.Now())
+		if len(s.polls) <= len(s.answers) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"error":"`+s.answers[len(s.polls)-1]+`"}`)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"access","expires_in":3600}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDeviceFlowPolling(t *testing.T) {
+	old := deviceFlowSecond
+	t.Cleanup(func() { deviceFlowSecond = old })
+	deviceFlowSecond = 20 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		answers []string
+		wantErr string
+		// wantGaps are the minimum waits before each poll after the
+		// first, in device flow seconds.
+		wantGaps []int
+	}{
+		{
+			name:     "pending then approved",
+			answers:  []string{"authorization_pending", "authorization_pending"},
+			wantGaps: []int{1, 1},
+		},
+		{
+			name:     "slowing down",
+			answers:  []string{"authorization_pending", "slow_down", "authorization_pending"},
+			wantGaps: []int{1, 6, 6},
+		},
+		{
+			name:    "denied",
+			answers: []string{"authorization_pending", "access_denied"},
+			wantErr: "authorization denied",
+		},
+		{
+			name:    "expired",
+			answers: []string{"expired_token"},
+			wantErr: "device code expired",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &fakeDeviceServer{answers: tt.answers}
+			srv := httptest.NewServer(ds)
+			t.Cleanup(srv.Close)
+			p := &oauthProvider{
+				Label:         "Test",
+				ClientID:      "test-client",
+				TokenURL:      srv.URL + "/token",
+				DeviceAuthURL: srv.URL + "/device",
+			}
+
+			dev, err := requestDeviceCode(context.Background(), p)
+			if err != nil {
+				t.Fatalf("requestDeviceCode: %v", err)
+			}
+			if dev.verificationURI() != "https://example.com/device" {
+				t.Errorf("verification URI = %q", dev.verificationURI())
+			}
+			resp, err := pollDeviceToken(context.Background(), p, dev)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("pollDeviceToken error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pollDeviceToken: %v", err)
+			}
+			if resp.AccessToken != "access" {
+				t.Errorf("access token = %q, want access", resp.AccessToken)
+			}
+
+			ds.mu.Lock()
+			defer ds.mu.Unlock()
+			if len(ds.polls) != len(tt.answers)+1 {
+				t.Fatalf("polled %d times, want %d", len(ds.polls), len(tt.answers)+1)
+			}
+			for i, want := range tt.wantGaps {
+				gap := ds.polls[i+1].Sub(ds.polls[i])
+				if least := time.Duration(want) * deviceFlowSecond; gap < least {
+					t.Errorf("poll %d came %s after the last one, want at least %s", i+2, gap, least)
+				}
+			}
+		})
+	}
+}
+
+func TestRevokeNamedLogin(t *testing.T) {
+	useTestDirs(t)
+	auth := &fakeAuthServer{}
+	useResendAuthServer(t, auth, &domainsStandIn{})
+	for _, login := range []string{providerResend, loginKey(providerResend, "work"), loginKey(providerResend, "side"), providerGoogle} {
+		if err := saveAuth(login, &OAuthToken{AccessToken: login + "-access", RefreshToken: login + "-refresh", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { revokeName = "" })
+	revoke := func(name string) (string, error) {
+		t.Helper()
+		revokeName = name
+		var err error
+		out := captureOutput(t, &os.Stdout, func() {
+			err = RevokeCmd.RunE(RevokeCmd, nil)
+		})
+		return out, err
+	}
+	stored := func(login string) bool {
+		t.Helper()
+		_, err := loadAuth(login)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	out, err := revoke("work")
+	if err != nil {
+		t.Fatalf("revoking the work login: %v", err)
+	}
+	if !strings.Contains(out, "Successfully revoked OAuth authentication with Resend (work)") {
+		t.Errorf("revoke printed %q", out)
+	}
+	if want := []string{"resend.work-refresh"}; !slices.Equal(auth.revoked, want) {
+		t.Errorf("revoked %v, want %v", auth.revoked, want)
+	}
+	if stored("resend.work") {
+		t.Error("the revoked login is still stored")
+	}
+	for _, login := range []string{providerResend, "resend.side", providerGoogle} {
+		if !stored(login) {
+			t.Errorf("revoking the work login removed %s", login)
+		}
+	}
+
+	// A revocation the provider turns down is reported, but the token is
+	// forgotten all the same.
+	auth.revokeStatus = http.StatusServiceUnavailable
+	out, err = revoke("side")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Status != "503 Service Unavailable" {
+		t.Fatalf("revoke error = %v, want the provider's 503", err)
+	}
+	if !strings.Contains(out, "didn't confirm the revocation") {
+		t.Errorf("revoke printed %q, want it to say the revocation wasn't confirmed", out)
+	}
+	if stored("resend.side") {
+		t.Error("the login whose revocation failed is still stored")
+	}
+	for _, login := range []string{providerResend, providerGoogle} {
+		if !stored(login) {
+			t.Errorf("revoking the side login removed %s", login)
+		}
+	}
+
+	if _, err := revoke("no such login"); err == nil {
+		t.Error("revoking an invalid login name succeeded")
+	}
+	out, err = revoke("gone")
+	if err != nil || !strings.Contains(out, "No OAuth token found for Resend (gone)") {
+		t.Errorf("revoking a login that isn't stored = %q, %v", out, err)
+	}
+}
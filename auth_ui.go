@@ -10,6 +10,7 @@ import (
 	"charm.land/bubbles/v2/help"
 	"charm.land/bubbles/v2/key"
 	"charm.land/bubbles/v2/spinner"
+	"charm.land/bubbles/v2/textinput"
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
 	"github.com/charmbracelet/x/exp/charmtone"
@@ -23,6 +24,8 @@ const (
 	authStateIntro authState = iota
 	authStatePreparing
 	authStateWaiting
+	authStateManual
+	authStateDevice
 	authStateExchanging
 	authStateError
 )
@@ -30,6 +33,8 @@ const (
 // authKeyMap represents the key bindings for the OAuth TUI.
 type authKeyMap struct {
 	Continue key.Binding
+	Device   key.Binding
+	Manual   key.Binding
 	Cancel   key.Binding
 }
 
@@ -40,6 +45,14 @@ func defaultAuthKeybinds() authKeyMap {
 			key.WithKeys("enter"),
 			key.WithHelp("enter", "continue"),
 		),
+		Device: key.NewBinding(
+			key.WithKeys("d"),
+			key.WithHelp("d", "use a code instead"),
+		),
+		Manual: key.NewBinding(
+			key.WithKeys("m"),
+			key.WithHelp("m", "paste the URL"),
+		),
 		Cancel: key.NewBinding(
 			key.WithKeys("ctrl+c", "esc"),
 			key.WithHelp("ctrl+c", "cancel"),
@@ -49,32 +62,41 @@ func defaultAuthKeybinds() authKeyMap {
 
 // ShortHelp returns the key bindings for the short help screen.
 func (k authKeyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Continue, k.Cancel}
+	return []key.Binding{k.Continue, k.Device, k.Manual, k.Cancel}
 }
 
 // FullHelp returns the key bindings for the full help screen.
 func (k authKeyMap) FullHelp() [][]key.Binding {
-	return [][]key.Binding{{k.Continue, k.Cancel}}
+	return [][]key.Binding{{k.Continue, k.Device, k.Manual, k.Cancel}}
 }
 
 // updateAuthKeymap enables/disables key bindings based on the current state.
 func (m *authModel) updateAuthKeymap() {
-	m.keymap.Continue.SetEnabled(m.state == authStateIntro)
+	m.keymap.Continue.SetEnabled(m.state == authStateIntro || m.state == authStateManual)
+	m.keymap.Device.SetEnabled(m.state == authStateIntro && m.provider.DeviceAuthURL != "")
+	m.keymap.Manual.SetEnabled(m.state == authStateIntro)
 }
 
 // authModel is the Bubble Tea model for the OAuth authorization flow.
 type authModel struct {
 	state authState
+	opts  authOptions
 
 	spinner spinner.Model
+	input   textinput.Model
 
-	clientID      string
+	provider      *oauthProvider
 	codeVerifier  string
 	oauthState    string
 	redirectURI   string
 	authURL       string
 	browserFailed bool
 
+	// device is the pending device authorization, and stopPolling stops
+	// polling for it.
+	device      *deviceAuthorization
+	stopPolling context.CancelFunc
+
 	resultCh chan callbackResult
 	server   *http.Server
 
@@ -82,6 +104,9 @@ type authModel struct {
 	keymap authKeyMap
 
 	authCode string
+	// pasteErr is shown when the pasted URL can't be used, so the user
+	// can try again.
+	pasteErr error
 	err      error
 	canceled bool
 	quitting bool
@@ -94,7 +119,6 @@ type authModel struct {
 type authCallbackMsg = callbackResult
 
 type authReadyMsg struct {
-	clientID     string
 	codeVerifier string
 	oauthState   string
 	redirectURI  string
@@ -103,6 +127,11 @@ type authReadyMsg struct {
 	server       *http.Server
 }
 
+// authDeviceMsg is sent once the device authorization has been requested.
+type authDeviceMsg struct {
+	device *deviceAuthorization
+}
+
 type authTokenMsg struct {
 	err error
 }
@@ -111,25 +140,38 @@ type authErrMsg struct {
 	err error
 }
 
-func newAuthModel(noBrowser bool) authModel {
+func newAuthModel(p *oauthProvider, opts authOptions) authModel {
 	s := spinner.New()
 	s.Style = lipgloss.NewStyle().Foreground(charmtone.Julep)
 	s.Spinner = spinner.Dot
+	input := textinput.New()
+	input.Placeholder = "http://" + oauthCallbackHost + "/…?code=…"
+	input.SetWidth(setupInputWidth)
 	m := authModel{
 		state:         authStateIntro,
+		opts:          opts,
+		provider:      p,
 		spinner:       s,
+		input:         input,
 		help:          help.New(),
 		keymap:        defaultAuthKeybinds(),
-		browserFailed: noBrowser,
+		browserFailed: opts.noBrowser,
 	}
 	m.updateAuthKeymap()
 	return m
 }
 
 // startOAuthFlowTUI runs the OAuth authorization flow with a small TUI that
-// guides the user through opening a browser and waiting for the callback.
-func startOAuthFlowTUI(noBrowser bool) error {
-	p := tea.NewProgram(newAuthModel(noBrowser))
+// guides the user through opening a browser and waiting for the callback, or
+// through one of the headless modes.
+func startOAuthFlowTUI(provider *oauthProvider, opts authOptions) error {
+	if err := provider.validate(); err != nil {
+		return err
+	}
+	if err := unlockTokenStore(true); err != nil {
+		return err
+	}
+	p := tea.NewProgram(newAuthModel(provider, opts))
 	final, err := p.Run()
 	if err != nil {
 		return fmt.Errorf("running auth program: %w", err)
@@ -145,12 +187,12 @@ func startOAuthFlowTUI(noBrowser bool) error {
 		fmt.Println(authCanceledView())
 		return nil
 	}
-	fmt.Println(authSuccessView())
+	fmt.Println(authSuccessView(opts.login(provider)))
 	return nil
 }
 
-func authSuccessView() string {
-	return fmt.Sprintf("\n  %s %s\n", noticeHeaderStyle.SetString("OKAY!"), "You’re now authenticated with Resend")
+func authSuccessView(login string) string {
+	return fmt.Sprintf("\n  %s %s\n", noticeHeaderStyle.SetString("OKAY!"), "You’re now authenticated with "+loginLabel(login))
 }
 
 func authCanceledView() string {
@@ -166,13 +208,20 @@ func (m authModel) Init() tea.Cmd {
 func (m authModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case authReadyMsg:
-		m.clientID = msg.clientID
 		m.codeVerifier = msg.codeVerifier
 		m.oauthState = msg.oauthState
 		m.redirectURI = msg.redirectURI
 		m.authURL = msg.authURL
 		m.resultCh = msg.resultCh
 		m.server = msg.server
+		if m.opts.manual {
+			// The callback may still reach us, e.g. through a
+			// forwarded port, so keep listening while the user
+			// pastes.
+			m.state = authStateManual
+			m.updateAuthKeymap()
+			return m, tea.Batch(waitForCallbackCmd(m.resultCh), m.input.Focus())
+		}
 		if !m.browserFailed {
 			m.browserFailed = browser.OpenURL(m.authURL) != nil
 		}
@@ -180,49 +229,43 @@ func (m authModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.updateAuthKeymap()
 		return m, tea.Batch(waitForCallbackCmd(m.resultCh), m.spinner.Tick)
 
+	case authDeviceMsg:
+		ctx, cancel := context.WithCancel(context.Background())
+		m.device = msg.device
+		m.stopPolling = cancel
+		m.state = authStateDevice
+		m.updateAuthKeymap()
+		return m, tea.Batch(pollDeviceTokenCmd(ctx, m.provider, m.opts.login(m.provider), m.device), m.spinner.Tick)
+
 	case authCallbackMsg:
 		if msg.err != nil {
-			m.shutdownServer()
-			m.err = msg.err
-			m.state = authStateError
-			m.updateAuthKeymap()
-			m.quitting = true
-			return m, tea.Quit
+			return m.fail(msg.err)
 		}
 		m.shutdownServer()
 		m.authCode = msg.code
 		m.state = authStateExchanging
 		m.updateAuthKeymap()
 		return m, tea.Batch(
-			exchangeTokenCmd(m.clientID, m.authCode, m.redirectURI, m.codeVerifier),
+			exchangeTokenCmd(m.provider, m.opts.login(m.provider), m.authCode, m.redirectURI, m.codeVerifier),
 			m.spinner.Tick,
 		)
 
 	case authTokenMsg:
 		m.shutdownServer()
 		if msg.err != nil {
-			m.err = msg.err
-			m.state = authStateError
-			m.updateAuthKeymap()
-			m.quitting = true
-			return m, tea.Quit
+			return m.fail(msg.err)
 		}
 		m.quitting = true
 		return m, tea.Quit
 
 	case authErrMsg:
-		m.shutdownServer()
-		m.err = msg.err
-		m.state = authStateError
-		m.updateAuthKeymap()
-		m.quitting = true
-		return m, tea.Quit
+		return m.fail(msg.err)
 
 	case spinner.TickMsg:
 		var cmd tea.Cmd
 		m.spinner, cmd = m.spinner.Update(msg)
 		switch m.state {
-		case authStatePreparing, authStateWaiting, authStateExchanging:
+		case authStatePreparing, authStateWaiting, authStateDevice, authStateExchanging:
 			return m, cmd
 		default:
 			return m, nil
@@ -240,24 +283,69 @@ func (m authModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.canceled = true
 			m.quitting = true
 			return m, tea.Quit
+		case key.Matches(msg, m.keymap.Device):
+			m.opts.device = true
+			return m.start()
+		case key.Matches(msg, m.keymap.Manual):
+			m.opts.manual = true
+			return m.start()
 		case key.Matches(msg, m.keymap.Continue):
-			if m.state == authStateIntro {
-				m.state = authStatePreparing
-				m.updateAuthKeymap()
-				return m, tea.Batch(setupOAuthCmd(), m.spinner.Tick)
+			switch m.state {
+			case authStateIntro:
+				return m.start()
+			case authStateManual:
+				code, err := parseCallbackInput(m.input.Value(), m.oauthState)
+				if err != nil {
+					m.pasteErr = err
+					m.input.Reset()
+					return m, nil
+				}
+				return m.Update(authCallbackMsg{code: code})
 			}
 		}
+		if m.state == authStateManual {
+			var cmd tea.Cmd
+			m.input, cmd = m.input.Update(msg)
+			return m, cmd
+		}
 		// Update help model for full-help toggle.
 		var cmd tea.Cmd
 		m.help, cmd = m.help.Update(msg)
 		return m, cmd
+
+	case tea.PasteMsg:
+		if m.state == authStateManual {
+			var cmd tea.Cmd
+			m.input, cmd = m.input.Update(msg)
+			return m, cmd
+		}
 	}
 
 	return m, nil
 }
 
-func authHeader() string {
-	return fmt.Sprintf("\n  %s %s", noticeHeaderStyle.SetString("Charm Pop"), "Let’s auth Resend")
+// start leaves the intro and begins the flow selected by the options.
+func (m authModel) start() (tea.Model, tea.Cmd) {
+	m.state = authStatePreparing
+	m.updateAuthKeymap()
+	if m.opts.device {
+		return m, tea.Batch(requestDeviceCodeCmd(m.provider), m.spinner.Tick)
+	}
+	return m, tea.Batch(setupOAuthCmd(m.provider, m.opts.callbackPort), m.spinner.Tick)
+}
+
+// fail stops the flow with the given error.
+func (m authModel) fail(err error) (tea.Model, tea.Cmd) {
+	m.shutdownServer()
+	m.err = err
+	m.state = authStateError
+	m.updateAuthKeymap()
+	m.quitting = true
+	return m, tea.Quit
+}
+
+func (m authModel) authHeader() string {
+	return fmt.Sprintf("\n  %s %s", noticeHeaderStyle.SetString("Charm Pop"), "Let’s auth "+m.provider.Label)
 }
 
 // authWidth returns the usable text width for the auth TUI, accounting for
@@ -279,25 +367,46 @@ func (m authModel) View() tea.View {
 
 	var content string
 	wrap := lipgloss.NewStyle().MaxWidth(m.authWidth())
+	urlStyle := lipgloss.NewStyle().
+		Foreground(charmtone.Guac).
+		Underline(true).
+		MaxWidth(m.authWidth())
 	switch m.state {
 	case authStateIntro:
-		content = authHeader() + "\n\n  " + wrap.Render("To authenticate we’re going to open the browser. Ready?")
+		intro := "To authenticate we’re going to open the browser. Ready?"
+		switch {
+		case m.opts.device:
+			intro = "To authenticate we’ll show you a code to enter on another device. Ready?"
+		case m.opts.manual:
+			intro = "To authenticate we’ll give you a URL to open in any browser. Ready?"
+		}
+		content = m.authHeader() + "\n\n  " + wrap.Render(intro)
 	case authStatePreparing:
-		content = authHeader() + "\n\n  " + m.spinner.View() + wrap.Render("Preparing...")
+		content = m.authHeader() + "\n\n  " + m.spinner.View() + wrap.Render("Preparing...")
 	case authStateWaiting:
-		content = authHeader() + "\n\n  " + m.spinner.View() + wrap.Render("Waiting for authorization...")
+		content = m.authHeader() + "\n\n  " + m.spinner.View() + wrap.Render("Waiting for authorization...")
 		if m.browserFailed {
-			urlStyle := lipgloss.NewStyle().
-				Foreground(charmtone.Guac).
-				Underline(true).
-				Hyperlink(m.authURL).
-				MaxWidth(m.authWidth())
-			content += "\n\n  " + wrap.Render("Visit the following URL to authenticate:") + "\n  " + urlStyle.Render(m.authURL)
+			content += "\n\n  " + wrap.Render("Visit the following URL to authenticate:") + "\n  " + urlStyle.Hyperlink(m.authURL).Render(m.authURL)
 		}
+	case authStateManual:
+		content = m.authHeader() + "\n\n  " + wrap.Render("Open this URL in a browser on any machine:") +
+			"\n  " + urlStyle.Hyperlink(m.authURL).Render(m.authURL) +
+			"\n\n  " + wrap.Render("Then paste the "+oauthCallbackHost+" URL it redirects to, or just the code:") +
+			"\n\n  " + m.input.View()
+		if m.pasteErr != nil {
+			content += "\n\n  " + errorStyle.Render(wrap.Render(m.pasteErr.Error()))
+		}
+	case authStateDevice:
+		verify := m.device.verificationURI()
+		content = m.authHeader() + "\n\n  " + wrap.Render("On any device, visit") +
+			"\n  " + urlStyle.Hyperlink(verify).Render(verify) +
+			"\n\n  " + wrap.Render("and enter the code") +
+			"\n  " + noticeHeaderStyle.SetString(m.device.UserCode).String() +
+			"\n\n  " + m.spinner.View() + wrap.Render("Waiting for authorization...")
 	case authStateExchanging:
-		content = authHeader() + "\n\n  " + m.spinner.View() + wrap.Render("Exchanging token...")
+		content = m.authHeader() + "\n\n  " + m.spinner.View() + wrap.Render("Exchanging token...")
 	case authStateError:
-		content = authHeader() + "\n\n  " + errorStyle.Render(wrap.Render(m.err.Error()))
+		content = m.authHeader() + "\n\n  " + errorStyle.Render(wrap.Render(m.err.Error()))
 	default:
 		return tea.NewView("")
 	}
@@ -307,6 +416,9 @@ func (m authModel) View() tea.View {
 }
 
 func (m authModel) shutdownServer() {
+	if m.stopPolling != nil {
+		m.stopPolling()
+	}
 	if m.server == nil {
 		return
 	}
@@ -317,12 +429,12 @@ func (m authModel) shutdownServer() {
 
 // setupOAuthCmd performs PKCE generation and starts the callback HTTP server,
 // returning everything needed to continue.
-func setupOAuthCmd() tea.Cmd {
+func setupOAuthCmd(p *oauthProvider, callbackPort int) tea.Cmd {
 	return func() tea.Msg {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		listener, redirectURI, err := openCallbackListener(ctx)
+		listener, redirectURI, err := openCallbackListener(ctx, callbackPort)
 		if err != nil {
 			return authErrMsg{err: err}
 		}
@@ -337,7 +449,7 @@ func setupOAuthCmd() tea.Cmd {
 			return authErrMsg{err: err}
 		}
 
-		authURL, err := buildAuthURL(oauthClientID, redirectURI, state, codeChallenge)
+		authURL, err := buildAuthURL(p, redirectURI, state, codeChallenge)
 		if err != nil {
 			return authErrMsg{err: err}
 		}
@@ -345,7 +457,6 @@ func setupOAuthCmd() tea.Cmd {
 		server, resultCh := newCallbackServer(state, listener)
 
 		return authReadyMsg{
-			clientID:     oauthClientID,
 			codeVerifier: codeVerifier,
 			oauthState:   state,
 			redirectURI:  redirectURI,
@@ -356,6 +467,35 @@ func setupOAuthCmd() tea.Cmd {
 	}
 }
 
+// requestDeviceCodeCmd starts the device authorization grant.
+func requestDeviceCodeCmd(p *oauthProvider) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		dev, err := requestDeviceCode(ctx, p)
+		if err != nil {
+			return authErrMsg{err: err}
+		}
+		return authDeviceMsg{device: dev}
+	}
+}
+
+// pollDeviceTokenCmd polls until the device authorization is approved, then
+// persists the tokens as the given login's.
+func pollDeviceTokenCmd(ctx context.Context, p *oauthProvider, login string, dev *deviceAuthorization) tea.Cmd {
+	return func() tea.Msg {
+		tokResp, err := pollDeviceToken(ctx, p, dev)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Canceled by the user.
+				return nil
+			}
+			return authTokenMsg{err: err}
+		}
+		return authTokenMsg{err: saveTokenResponse(login, tokResp)}
+	}
+}
+
 // waitForCallbackCmd blocks until the OAuth callback fires or the flow times
 // out.
 func waitForCallbackCmd(resultCh chan callbackResult) tea.Cmd {
@@ -370,23 +510,15 @@ func waitForCallbackCmd(resultCh chan callbackResult) tea.Cmd {
 }
 
 // exchangeTokenCmd exchanges the authorization code for tokens and persists
-// them to disk.
-func exchangeTokenCmd(clientID, code, redirectURI, codeVerifier string) tea.Cmd {
+// them as the given login's.
+func exchangeTokenCmd(p *oauthProvider, login, code, redirectURI, codeVerifier string) tea.Cmd {
 	return func() tea.Msg {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
-		tokResp, err := exchangeCode(ctx, clientID, code, redirectURI, codeVerifier)
+		tokResp, err := exchangeCode(ctx, p, code, redirectURI, codeVerifier)
 		if err != nil {
 			return authTokenMsg{err: err}
 		}
-		token := &OAuthToken{
-			AccessToken:  tokResp.AccessToken,
-			RefreshToken: tokResp.RefreshToken,
-			ExpiresAt:    time.Now().Add(time.Duration(tokResp.ExpiresIn) * time.Second),
-		}
-		if err := saveAuth(token); err != nil {
-			return authTokenMsg{err: fmt.Errorf("saving auth: %w", err)}
-		}
-		return authTokenMsg{}
+		return authTokenMsg{err: saveTokenResponse(login, tokResp)}
 	}
 }
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/spf13/cobra"
+)
+
+// batchSize is the most messages pop batch reads before sending them
+// together, e.g. in one Resend batch request.
+const batchSize = resendBatchSize
+
+// BatchCmd is the cobra command that sends messages read as JSON Lines.
+var BatchCmd = &cobra.Command{
+	Use:   "batch [file]",
+	Short: "Send messages read as JSON, one per line",
+	Long: `Sends every message in a JSON Lines file, or stdin, and prints one JSON
+result per message, in the same order.
+
+Each line is an object with from, to, cc, bcc, subject, body (Markdown),
+attachments, headers, and optionally plaintext and send_at. to, cc, bcc and
+attachments are arrays, or strings separated by commas. from defaults to
+--from or the account's.
+
+Messages go out up to ` + fmt.Sprint(batchSize) + ` at a time, or as many as have been read when the
+input pauses: through Resend's batch endpoint, or one SMTP connection kept open
+for all of them. Resend's batch endpoint doesn't take attachments or send_at,
+so those messages are sent one by one.
+
+A result's status is sent, scheduled, queued (in the outbox, to be retried),
+failed, or canceled if pop was interrupted before sending it. id is the
+provider's ID when sent, and the outbox or history ID otherwise.`,
+	Example: `  pop batch < messages.jsonl
+  jq -c '.[]' messages.json | pop batch --account work`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		in := io.Reader(os.Stdin)
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("opening messages: %w", err)
+			}
+			defer func() { _ = f.Close() }()
+			in = f
+		} else if term.IsTerminal(os.Stdin.Fd()) {
+			return errors.New("pipe in messages as JSON, one per line")
+		}
+
+		_, account, err := useAccount(accountName)
+		if err != nil {
+			return err
+		}
+		transport, err := pickTransport()
+		if err != nil {
+			return err
+		}
+		if transport.Name == transportSMTP && from == "" && smtpUsername != "" {
+			from = smtpUsername
+		}
+		sender, err := transport.New()
+		if err != nil {
+			return err
+		}
+		if c, ok := sender.(io.Closer); ok {
+			defer func() { _ = c.Close() }()
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+
+		r := bufio.NewReader(in)
+		var line, total, failed int
+		for ctx.Err() == nil {
+			results, msgs, eof, err := readBatch(r, &line)
+			if err != nil {
+				return err
+			}
+			var sendable []Message
+			var indexes []int
+			for i, msg := range msgs {
+				if results[i].Status == "" {
+					sendable = append(sendable, msg)
+					indexes = append(indexes, i)
+				}
+			}
+			deliveries, errs := deliverBatch(ctx, account, sender, sendable)
+			for n, i := range indexes {
+				results[i].setOutcome(deliveries[n], errs[n])
+			}
+			for _, result := range results {
+				total++
+				if result.Status == historyFailed || result.Status == historyCanceled {
+					failed++
+				}
+				if err := enc.Encode(result); err != nil {
+					return fmt.Errorf("writing result: %w", err)
+				}
+			}
+			if eof {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("interrupted after %d message(s)", total)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d message(s) couldn't be sent", failed, total)
+		}
+		return nil
+	},
+}
+
+// batchMessage is one line of pop batch's input.
+type batchMessage struct {
+	From        string            `json:"from"`
+	To          stringList        `json:"to"`
+	Cc          stringList        `json:"cc"`
+	Bcc         stringList        `json:"bcc"`
+	Subject     string            `json:"subject"`
+	Body        string            `json:"body"`
+	Plaintext   *bool             `json:"plaintext"`
+	Attachments stringList        `json:"attachments"`
+	Headers     map[string]string `json:"headers"`
+	SendAt      string            `json:"send_at"`
+}
+
+// stringList is a JSON array of strings, or a string of them separated by
+// commas.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = compactAddresses(strings.Split(s, ToSeparator))
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("expected a string or an array of strings")
+	}
+	*l = compactAddresses(list)
+	return nil
+}
+
+// batchResult is what pop batch prints for each message.
+type batchResult struct {
+	// Line is the message's line in the input.
+	Line    int      `json:"line"`
+	Status  string   `json:"status"`
+	ID      string   `json:"id,omitempty"`
+	Receipt *Receipt `json:"receipt,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// readBatch reads up to batchSize messages, counting lines in line. It stops
+// early when reading more would wait for input, so messages piped in slowly
+// aren't held back. Lines that aren't valid messages get a failed result, and
+// the others an empty one. eof is set when the input is used up.
+func readBatch(r *bufio.Reader, line *int) (results []batchResult, msgs []Message, eof bool, err error) {
+	for len(msgs) < batchSize {
+		data, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, nil, false, fmt.Errorf("reading messages: %w", err)
+		}
+		eof = errors.Is(err, io.EOF)
+		if len(data) > 0 {
+			*line++
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			msg, parseErr := parseBatchMessage(data)
+			result := batchResult{Line: *line}
+			if parseErr != nil {
+				result.Status = historyFailed
+				result.Error = parseErr.Error()
+			}
+			results = append(results, result)
+			msgs = append(msgs, msg)
+		}
+		if eof || len(results) > 0 && r.Buffered() == 0 {
+			break
+		}
+	}
+	return results, msgs, eof, nil
+}
+
+// parseBatchMessage parses one line of input into a message, filling in the
+// defaults.
+func parseBatchMessage(data []byte) (Message, error) {
+	var in batchMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return Message{}, fmt.Errorf("parsing message: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return Message{}, errors.New("parsing message: unexpected data after the message")
+	}
+	msg := Message{
+		From:        ordefault(in.From, from),
+		To:          in.To,
+		Cc:          in.Cc,
+		Bcc:         in.Bcc,
+		Subject:     in.Subject,
+		Body:        in.Body,
+		Plaintext:   plaintext,
+		Attachments: in.Attachments,
+		Headers:     in.Headers,
+	}
+	if in.Plaintext != nil {
+		msg.Plaintext = *in.Plaintext
+	}
+	switch {
+	case msg.From == "":
+		return msg, errors.New("no from: set it in the message, or with --from")
+	case len(msg.To) == 0:
+		return msg, errors.New("no to")
+	case msg.Subject == "":
+		return msg, errors.New("no subject")
+	case msg.Body == "":
+		return msg, errors.New("no body")
+	}
+	for _, a := range msg.Attachments {
+		if _, err := os.Stat(a); err != nil {
+			return msg, fmt.Errorf("attachment: %w", err)
+		}
+	}
+	if in.SendAt != "" {
+		t, err := parseSendAt(in.SendAt, time.Now())
+		if err != nil {
+			return msg, fmt.Errorf("send_at: %w", err)
+		}
+		msg.SendAt = t
+	}
+	return msg, nil
+}
+
+// setOutcome fills in the result from the message's delivery.
+func (r *batchResult) setOutcome(d delivery, err error) {
+	switch {
+	case canceledUnsent(err):
+		r.Status = historyCanceled
+		r.Error = err.Error()
+	case err != nil:
+		r.Status = historyFailed
+		r.Error = err.Error()
+		if d.entry != nil {
+			r.ID = d.entry.ID
+		}
+	case d.queued != nil:
+		r.Status = historyQueued
+		if d.queued.scheduled() {
+			r.Status = historyScheduled
+		}
+		r.ID = d.queued.ID
+		r.Error = d.queued.LastError
+	default:
+		r.Status = historySent
+		if !d.receipt.ScheduledAt.IsZero() {
+			r.Status = historyScheduled
+		}
+		r.ID = d.receipt.ID
+		r.Receipt = d.receipt
+	}
+}
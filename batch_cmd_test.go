@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBatchMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		line    string
+		want    Message
+		wantErr string
+	}{
+		{
+			name: "to as a string",
+			line: `{"from":"me@example.com","to":"ada@example.com","subject":"Hi","body":"Hello"}`,
+			want: Message{From: "me@example.com", To: []string{"ada@example.com"}, Subject: "Hi", Body: "Hello"},
+		},
+		{
+			name: "to as an array",
+			line: `{"from":"me@example.com","to":["ada@example.com"," bob@example.com"],"cc":[],"subject":"Hi","body":"Hello"}`,
+			want: Message{From: "me@example.com", To: []string{"ada@example.com", "bob@example.com"}, Subject: "Hi", Body: "Hello"},
+		},
+		{
+			name: "to separated by commas",
+			line: `{"from":"me@example.com","to":"ada@example.com, bob@example.com,","bcc":"carol@example.com","subject":"Hi","body":"Hello"}`,
+			want: Message{
+				From:    "me@example.com",
+				To:      []string{"ada@example.com", "bob@example.com"},
+				Bcc:     []string{"carol@example.com"},
+				Subject: "Hi",
+				Body:    "Hello",
+			},
+		},
+		{
+			name: "from defaults to --from",
+			from: "default@example.com",
+			line: `{"to":"ada@example.com","subject":"Hi","body":"Hello","plaintext":true}`,
+			want: Message{From: "default@example.com", To: []string{"ada@example.com"}, Subject: "Hi", Body: "Hello", Plaintext: true},
+		},
+		{
+			name:    "unknown field",
+			line:    `{"from":"me@example.com","to":"ada@example.com","subjet":"Hi","body":"Hello"}`,
+			wantErr: `unknown field "subjet"`,
+		},
+		{
+			name:    "to as a number",
+			line:    `{"from":"me@example.com","to":42,"subject":"Hi","body":"Hello"}`,
+			wantErr: "expected a string or an array of strings",
+		},
+		{
+			name:    "data after the message",
+			line:    `{"from":"me@example.com","to":"ada@example.com","subject":"Hi","body":"Hello"} {"to":"bob@example.com"}`,
+			wantErr: "unexpected data after the message",
+		},
+		{
+			name:    "no from",
+			line:    `{"to":"ada@example.com","subject":"Hi","body":"Hello"}`,
+			wantErr: "no from",
+		},
+		{
+			name:    "empty to",
+			line:    `{"from":"me@example.com","to":" , ","subject":"Hi","body":"Hello"}`,
+			wantErr: "no to",
+		},
+		{
+			name:    "bad send_at",
+			line:    `{"from":"me@example.com","to":"ada@example.com","subject":"Hi","body":"Hello","send_at":"someday"}`,
+			wantErr: "send_at",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFrom(t, tt.from)
+			got, err := parseBatchMessage([]byte(tt.line))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseBatchMessage error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBatchMessage: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBatchMessage = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	useFrom(t, "me@example.com")
+	const msg = `{"to":"ada@example.com","subject":"Hi","body":"Hello"}`
+	input := "\n" +
+		msg + "\r\n" +
+		"   \n" +
+		"\n" +
+		`{"to":` + "\n" +
+		msg
+
+	var line int
+	results, msgs, eof, err := readBatch(bufio.NewReader(strings.NewReader(input)), &line)
+	if err != nil {
+		t.Fatalf("readBatch: %v", err)
+	}
+	if !eof {
+		t.Error("readBatch didn't report the end of the input")
+	}
+	want := []batchResult{
+		{Line: 2},
+		{Line: 5, Status: historyFailed},
+		{Line: 6},
+	}
+	if len(results) != len(want) || len(msgs) != len(want) {
+		t.Fatalf("got %d results and %d messages, want %d: %+v", len(results), len(msgs), len(want), results)
+	}
+	for i, w := range want {
+		if results[i].Line != w.Line || results[i].Status != w.Status {
+			t.Errorf("result %d = line %d %q, want line %d %q", i, results[i].Line, results[i].Status, w.Line, w.Status)
+		}
+	}
+	if results[1].Error == "" {
+		t.Error("the bad line has no error")
+	}
+	if line != 6 {
+		t.Errorf("counted %d lines, want 6", line)
+	}
+}
+
+func TestReadBatchPause(t *testing.T) {
+	useFrom(t, "me@example.com")
+	const msg = `{"to":"ada@example.com","subject":"Hi","body":"Hello"}` + "\n"
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { _ = pr.Close() })
+	go func() {
+		_, _ = io.WriteString(pw, msg+msg)
+		// Hold the pipe open, as a slow producer would.
+	}()
+
+	var line int
+	r := bufio.NewReader(pr)
+	results, _, eof, err := readBatch(r, &line)
+	if err != nil {
+		t.Fatalf("readBatch: %v", err)
+	}
+	if eof {
+		t.Error("readBatch reported the end of an open input")
+	}
+	if len(results) != 2 || results[1].Line != 2 {
+		t.Fatalf("got %+v, want the two lines written so far", results)
+	}
+
+	go func() {
+		_, _ = io.WriteString(pw, "\n"+msg)
+		_ = pw.Close()
+	}()
+	results, _, eof, err = readBatch(r, &line)
+	if err != nil {
+		t.Fatalf("readBatch: %v", err)
+	}
+	if len(results) != 1 || results[0].Line != 4 {
+		t.Fatalf("got %+v, want line 4", results)
+	}
+	if !eof {
+		results, _, eof, err = readBatch(r, &line)
+		if err != nil || !eof || len(results) != 0 {
+			t.Errorf("got %+v (eof %v, error %v), want the end of the input", results, eof, err)
+		}
+	}
+}
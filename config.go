@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+)
+
+// PopConfig is the environment variable that overrides the path to the
+// config file.
+const PopConfig = "POP_CONFIG"
+
+// PopAccount is the environment variable that selects a named account from
+// the config file.
+const PopAccount = "POP_ACCOUNT"
+
+// Config is pop's configuration file.
+//
+// Settings are resolved in the following order, from highest to lowest
+// precedence:
+//
+//  1. Command line flags
+//  2. Environment variables
+//  3. The selected account in the config file
+//  4. Built-in defaults
+//
+// The account is selected with --account, then $POP_ACCOUNT, then the
+// config's default.
+type Config struct {
+	// Default is the account used when none is selected explicitly.
+	Default string `toml:"default,omitempty"`
+
+	// TokenStore is where OAuth tokens are kept: "file" (the default),
+	// "encrypted-file", "keyring" or "keyctl".
+	TokenStore string `toml:"token_store,omitempty"`
+	// TokenPassphraseCmd prints the passphrase of the encrypted-file token
+	// store.
+	TokenPassphraseCmd string `toml:"token_passphrase_cmd,omitempty"`
+
+	// WebhookSecretCmd prints the signing secret of the Resend webhook
+	// `pop events listen` receives.
+	WebhookSecretCmd string `toml:"webhook_secret_cmd,omitempty"`
+
+	// UndoSend is how long the TUI waits before sending, e.g. "10s", so a
+	// send can be undone. "0" sends right away.
+	UndoSend string `toml:"undo_send,omitempty"`
+
+	// Accounts holds the named accounts.
+	Accounts map[string]Account `toml:"accounts,omitempty"`
+}
+
+// Account is a named set of delivery settings.
+type Account struct {
+	// Method is the name of the transport, e.g. "smtp", "resend" or
+	// "resend-oauth".
+	Method    string `toml:"method,omitempty"`
+	From      string `toml:"from,omitempty"`
+	Signature string `toml:"signature,omitempty"`
+
+	SMTP   SMTPAccount   `toml:"smtp,omitempty"`
+	Resend ResendAccount `toml:"resend,omitempty"`
+
+	// name is the account's name in the config file, set when it's
+	// looked up.
+	name string
+}
+
+// SMTPAccount holds the SMTP settings of an account.
+type SMTPAccount struct {
+	// Provider names a preset, e.g. "gmail", that fills in the host, port
+	// and encryption left unset.
+	Provider    string `toml:"provider,omitempty"`
+	Host        string `toml:"host,omitempty"`
+	Port        int    `toml:"port,omitempty"`
+	Username    string `toml:"username,omitempty"`
+	Password    string `toml:"password,omitempty"`
+	PasswordCmd string `toml:"password_cmd,omitempty"`
+	// PasswordInTokenStore is set when the password is kept in the token
+	// store, where `pop setup` puts it, rather than in this file.
+	PasswordInTokenStore bool   `toml:"password_in_token_store,omitempty"`
+	Encryption           string `toml:"encryption,omitempty"`
+	InsecureSkipVerify   bool   `toml:"insecure_skip_verify,omitempty"`
+	// Auth is the SASL mechanism, e.g. "xoauth2".
+	Auth string `toml:"auth,omitempty"`
+	// TokenCmd prints an OAuth bearer token for xoauth2 and oauthbearer.
+	TokenCmd string `toml:"token_cmd,omitempty"`
+}
+
+// ResendAccount holds the Resend settings of an account.
+type ResendAccount struct {
+	APIKey    string `toml:"api_key,omitempty"`
+	APIKeyCmd string `toml:"api_key_cmd,omitempty"`
+	// APIKeyInTokenStore is set when the API key is kept in the token
+	// store, where `pop setup` puts it, rather than in this file.
+	APIKeyInTokenStore bool `toml:"api_key_in_token_store,omitempty"`
+	// OAuthName selects a named OAuth login, as stored by
+	// `pop auth --name`, for the resend-oauth method.
+	OAuthName string `toml:"oauth_name,omitempty"`
+}
+
+// configFilePath returns the path to the config file. It doesn't check
+// whether the file exists.
+func configFilePath() (string, error) {
+	if path := os.Getenv(PopConfig); path != "" {
+		return path, nil
+	}
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		var err error
+		configDir, err = os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("getting config directory: %w", err)
+		}
+	}
+	return filepath.Join(configDir, "pop", "config.toml"), nil
+}
+
+// loadConfig reads the config file. A missing config file isn't an error and
+// yields an empty config.
+func loadConfig() (*Config, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &cfg, nil
+		}
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	return &cfg, nil
+}
+
+// saveAccount adds the named account to the config file, or replaces it,
+// and makes it the default if there's no default yet. The rest of the file
+// is kept as it is, comments and all, and the file is replaced in one go, so
+// a crash can't leave it half written.
+func saveAccount(name string, acct Account) error {
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path) //nolint:gosec // G304: the config file
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	var cfg Config
+	if _, err := toml.Decode(string(data), &cfg); err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var table bytes.Buffer
+	enc := toml.NewEncoder(&table)
+	enc.Indent = ""
+	if err := enc.Encode(map[string]map[string]Account{"accounts": {name: acct}}); err != nil {
+		return fmt.Errorf("writing config file: %w", err)
+	}
+	// The file may define [accounts] itself, and it can't be defined twice.
+	encoded := strings.TrimPrefix(table.String(), "[accounts]\n")
+
+	lines := removeTOMLTable(strings.Split(string(data), "\n"), "accounts", name)
+	_, hasDefault := cfg.Accounts[cfg.Default]
+	if !hasDefault {
+		lines = setTOMLDefault(lines, name)
+	}
+	content := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if content != "" {
+		content += "\n\n"
+	}
+	content += encoded
+
+	// Make sure the edit did what it should before replacing the file.
+	var check Config
+	_, err = toml.Decode(content, &check)
+	if err != nil || !reflect.DeepEqual(check.Accounts[name], acct) || !hasDefault && check.Default != name {
+		return fmt.Errorf("couldn't add account %q to %s: add it by hand", name, path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	if err := writeFileAtomic(path, []byte(content)); err != nil {
+		return fmt.Errorf("writing config file: %w", err)
+	}
+	return nil
+}
+
+// removeTOMLTable returns the lines of a TOML document without the table
+// with the given key, and its subtables.
+func removeTOMLTable(lines []string, key ...string) []string {
+	kept := make([]string, 0, len(lines))
+	inside := false
+	for _, line := range lines {
+		if header, ok := tomlTableHeader(line); ok {
+			inside = len(header) >= len(key) && slices.Equal(header[:len(key)], key)
+		}
+		if !inside {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+// setTOMLDefault returns the lines of a TOML document with its top-level
+// default key set to the named account.
+func setTOMLDefault(lines []string, name string) []string {
+	line := "default = " + strconv.Quote(name)
+	for i, l := range lines {
+		if _, ok := tomlTableHeader(l); ok {
+			// Top-level keys must come before the first table, and
+			// the comments above it.
+			for i > 0 && strings.HasPrefix(strings.TrimSpace(lines[i-1]), "#") {
+				i--
+			}
+			return slices.Insert(lines, i, line, "")
+		}
+		if k, _, ok := strings.Cut(l, "="); ok && strings.TrimSpace(k) == "default" {
+			lines[i] = line
+			return lines
+		}
+	}
+	return append(lines, line)
+}
+
+// tomlTableHeader returns the key of the table a line such as
+// [accounts.work] or [accounts."my work".smtp] starts, if it starts one.
+func tomlTableHeader(line string) ([]string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[") {
+		return nil, false
+	}
+	line = strings.TrimLeft(line, "[")
+	var (
+		key    []string
+		part   strings.Builder
+		quote  rune
+		closed bool
+	)
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			part.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '.' || r == ']':
+			key = append(key, strings.TrimSpace(part.String()))
+			part.Reset()
+			closed = r == ']'
+		default:
+			part.WriteRune(r)
+		}
+		if closed {
+			break
+		}
+	}
+	return key, closed
+}
+
+// accountNames returns the names of the configured accounts, sorted.
+func (c *Config) accountNames() []string {
+	names := make([]string, 0, len(c.Accounts))
+	for name := range c.Accounts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// account returns the named account, or the default account when name is
+// empty. It returns an empty name when there's nothing to select.
+func (c *Config) account(name string) (string, Account, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" && len(c.Accounts) == 1 {
+		name = c.accountNames()[0]
+	}
+	if name == "" {
+		return "", Account{}, nil
+	}
+	acct, ok := c.Accounts[name]
+	if !ok {
+		return "", Account{}, fmt.Errorf("unknown account %q", name)
+	}
+	acct.name = name
+	if _, ok := lookupTransport(acct.Method); !ok {
+		return "", Account{}, fmt.Errorf("account %q: unknown method %q", name, acct.Method)
+	}
+	return name, acct, nil
+}
+
+// accountFlagEnv maps the flags an account can set to the environment
+// variables that override them.
+var accountFlagEnv = map[string]string{
+	"from":            PopFrom,
+	"signature":       PopSignature,
+	"smtp.provider":   PopSMTPProvider,
+	"smtp.host":       PopSMTPHost,
+	"smtp.port":       PopSMTPPort,
+	"smtp.username":   PopSMTPUsername,
+	"smtp.password":   PopSMTPPassword,
+	"smtp.encryption": PopSMTPEncryption,
+	"smtp.insecure":   PopSMTPInsecureSkipVerify,
+	"smtp.auth":       PopSMTPAuth,
+	"smtp.token-cmd":  PopSMTPTokenCmd,
+	"resend.key":      ResendAPIKey,
+	"oauth":           PopOAuthResend,
+	"oauth.name":      PopOAuthName,
+}
+
+// accountSetFlags records the flags set from an account by the last call to
+// applyAccount, so `pop config show` can tell where a setting came from.
+var accountSetFlags = map[string]bool{}
+
+// applyAccount sets the flags in fs from the given account. Flags set on the
+// command line or through the environment are left alone, and everything
+// else the account doesn't set is reset to its default, so applying a second
+// account doesn't leak settings from the first.
+func applyAccount(fs *pflag.FlagSet, acct Account) error {
+	clear(accountSetFlags)
+	for name, env := range accountFlagEnv {
+		f := fs.Lookup(name)
+		if f == nil || f.Changed || os.Getenv(env) != "" {
+			continue
+		}
+		v, err := acct.flagValue(name)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			v = f.DefValue
+		} else {
+			accountSetFlags[name] = true
+		}
+		if err := f.Value.Set(v); err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// settingExplicit reports whether the named flag was set on the command line,
+// through the environment or by the applied account, rather than left at its
+// default.
+func settingExplicit(name string) bool {
+	if f := accountFlags.Lookup(name); f != nil && f.Changed {
+		return true
+	}
+	if env := accountFlagEnv[name]; env != "" && os.Getenv(env) != "" {
+		return true
+	}
+	return accountSetFlags[name]
+}
+
+// flagValue returns the account's value for the named flag, or an empty
+// string if the account doesn't set it. Only the settings of the account's
+// method are returned.
+func (a Account) flagValue(name string) (string, error) {
+	switch name {
+	case "from":
+		return a.From, nil
+	case "signature":
+		return a.Signature, nil
+	}
+
+	switch a.Method {
+	case transportSMTP:
+		switch name {
+		case "smtp.provider":
+			return a.SMTP.Provider, nil
+		case "smtp.host":
+			return a.SMTP.Host, nil
+		case "smtp.port":
+			if a.SMTP.Port == 0 {
+				return "", nil
+			}
+			return strconv.Itoa(a.SMTP.Port), nil
+		case "smtp.username":
+			return a.SMTP.Username, nil
+		case "smtp.password":
+			if a.SMTP.PasswordCmd != "" {
+				return runSecretCmd(a.SMTP.PasswordCmd)
+			}
+			if a.SMTP.PasswordInTokenStore {
+				return loadAccountSecret(a.name)
+			}
+			return a.SMTP.Password, nil
+		case "smtp.encryption":
+			return a.SMTP.Encryption, nil
+		case "smtp.insecure":
+			if !a.SMTP.InsecureSkipVerify {
+				return "", nil
+			}
+			return envTrue, nil
+		case "smtp.auth":
+			return a.SMTP.Auth, nil
+		case "smtp.token-cmd":
+			return a.SMTP.TokenCmd, nil
+		}
+	case transportResend:
+		if name == "resend.key" {
+			if a.Resend.APIKeyCmd != "" {
+				return runSecretCmd(a.Resend.APIKeyCmd)
+			}
+			if a.Resend.APIKeyInTokenStore {
+				return loadAccountSecret(a.name)
+			}
+			return a.Resend.APIKey, nil
+		}
+	case transportResendOAuth:
+		switch name {
+		case "oauth":
+			return envTrue, nil
+		case "oauth.name":
+			return a.Resend.OAuthName, nil
+		}
+	}
+	return "", nil
+}
+
+// runSecretCmd runs a shell command and returns its trimmed output. It's
+// used to fetch secrets from a password manager rather than storing them in
+// the config file.
+func runSecretCmd(command string) (string, error) {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	out, err := exec.Command(shell, flag, command).Output() //nolint:gosec,noctx
+	if err != nil {
+		return "", fmt.Errorf("running %q: %w", command, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// accountSecretProvider is the provider part of the login keys that account
+// secrets are kept under in the token store, e.g. "secret.work". Keeping them
+// as tokens means they're protected like tokens, and move with them when the
+// token store changes.
+const accountSecretProvider = "secret"
+
+// loadAccountSecret returns the SMTP password or Resend API key kept in the
+// token store for the named account.
+func loadAccountSecret(account string) (string, error) {
+	token, err := loadAuth(loginKey(accountSecretProvider, account))
+	if err != nil {
+		return "", fmt.Errorf("loading the account's secret from the token store: %w", err)
+	}
+	return token.AccessToken, nil
+}
+
+// storeAccountSecret moves the account's SMTP password or Resend API key out
+// of acct and into the token store, so it isn't written to the config file.
+func storeAccountSecret(name string, acct *Account) error {
+	key := loginKey(accountSecretProvider, name)
+	var secret string
+	switch {
+	case acct.SMTP.Password != "":
+		secret, acct.SMTP.Password, acct.SMTP.PasswordInTokenStore = acct.SMTP.Password, "", true
+	case acct.Resend.APIKey != "":
+		secret, acct.Resend.APIKey, acct.Resend.APIKeyInTokenStore = acct.Resend.APIKey, "", true
+	default:
+		// Don't leave the secret of an account this one replaces behind.
+		if err := deleteAuth(key); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return saveAuth(key, &OAuthToken{AccessToken: secret})
+}
+
+// accountFlags is the flag set accounts are applied to. It's the root
+// command's flag set, and is set in init to avoid an initialization cycle.
+var accountFlags *pflag.FlagSet
+
+// useAccount loads the config file and applies the named account (or the
+// default account, or none for noAccount) to the root command's flags. It
+// returns the config and the name of the account that was applied, if any.
+func useAccount(name string) (*Config, string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	if name == noAccount {
+		// Just flags and the environment, like the email it was
+		// recorded for.
+		return cfg, "", applyAccount(accountFlags, Account{})
+	}
+	name, acct, err := cfg.account(name)
+	if err != nil || name == "" {
+		return cfg, "", err
+	}
+	if err := applyAccount(accountFlags, acct); err != nil {
+		return nil, "", fmt.Errorf("account %q: %w", name, err)
+	}
+	return cfg, name, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/spf13/cobra"
+)
+
+// Sources a setting can come from.
+const (
+	sourceFlag       = "flag"
+	sourceEnv        = "env"
+	sourceConfigFile = "config_file"
+	sourceOAuthStore = "oauth_store"
+	sourcePreset     = "preset"
+	sourceInferred   = "inferred"
+	sourceDiscovered = "discovered"
+	sourceDefault    = "default"
+)
+
+// redacted replaces secrets in `pop config show` output.
+const redacted = "********"
+
+// setting describes a setting shown by `pop config show`.
+type setting struct {
+	flag   string
+	env    string
+	secret bool
+}
+
+// settings are the settings shown by `pop config show`, in order.
+var settings = []setting{
+	{flag: "from", env: PopFrom},
+	{flag: "signature", env: PopSignature},
+	{flag: "plaintext", env: PopPlaintext},
+	{flag: "unsafe", env: PopUnsafeHTML},
+	{flag: "smtp.provider", env: PopSMTPProvider},
+	{flag: "smtp.host", env: PopSMTPHost},
+	{flag: "smtp.port", env: PopSMTPPort},
+	{flag: "smtp.username", env: PopSMTPUsername},
+	{flag: "smtp.password", env: PopSMTPPassword, secret: true},
+	{flag: "smtp.encryption", env: PopSMTPEncryption},
+	{flag: "smtp.insecure", env: PopSMTPInsecureSkipVerify},
+	{flag: "smtp.auth", env: PopSMTPAuth},
+	{flag: "smtp.token-cmd", env: PopSMTPTokenCmd},
+	{flag: "resend.key", env: ResendAPIKey, secret: true},
+	{flag: "oauth", env: PopOAuthResend},
+	{flag: "oauth.name", env: PopOAuthName},
+}
+
+// resolvedSetting is an effective setting along with where it came from.
+type resolvedSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	// Origin is the specific flag, environment variable, account or file
+	// the value came from.
+	Origin string `json:"origin,omitempty"`
+}
+
+// resolvedConfig is the output of `pop config show`.
+type resolvedConfig struct {
+	ConfigFile string            `json:"config_file"`
+	Account    string            `json:"account,omitempty"`
+	Transport  string            `json:"transport,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Settings   []resolvedSetting `json:"settings"`
+}
+
+var configShowJSON bool
+
+// ConfigCmd is the parent command for inspecting pop's configuration.
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect Pop's configuration",
+	Args:  cobra.NoArgs,
+}
+
+// ConfigShowCmd prints the effective configuration and where each setting
+// came from.
+var ConfigShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the resolved configuration",
+	Long:  `Show every effective setting and where it came from: a flag, the environment, the config file, the OAuth token store, or a built-in default. Secrets are redacted.`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		resolved, err := resolveConfig()
+		if err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+		if configShowJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(resolved); err != nil {
+				return fmt.Errorf("encoding config: %w", err)
+			}
+			return nil
+		}
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		_, _ = fmt.Fprint(w, resolved.String())
+		return nil
+	},
+}
+
+// resolveConfig applies the selected account and works out the effective
+// value and source of every setting.
+func resolveConfig() (*resolvedConfig, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+	_, account, err := useAccount(accountName)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := &resolvedConfig{
+		ConfigFile: path,
+		Account:    account,
+	}
+
+	// Work out the transport without refreshing any stored OAuth token, so
+	// showing the config never has side effects.
+	transport, err := selectTransport()
+	if errors.Is(err, errNoTransport) {
+		switch _, authErr := loadAuth(providerResend); {
+		case authErr == nil:
+			transport, _ = lookupTransport(transportResendOAuth)
+			err = nil
+		case !os.IsNotExist(authErr):
+			err = authErr
+		}
+	}
+	if err != nil {
+		resolved.Error = err.Error()
+	}
+	resolved.Transport = transport.Name
+
+	// The SMTP preset fills in host, port and encryption the user didn't set.
+	preset, hasPreset := (&SMTPSender{Provider: smtpProvider, Host: smtpHost, Username: smtpUsername}).preset()
+	// Otherwise DNS discovery may, but only when sending through SMTP: there's
+	// no point in DNS lookups for a Resend account.
+	var (
+		discovered    discoveredSMTP
+		hasDiscovered bool
+	)
+	if transport.Name == transportSMTP && smtpHost == "" {
+		discovered, hasDiscovered = discoverSMTPServer()
+	}
+
+	fs := accountFlags
+	for _, s := range settings {
+		f := fs.Lookup(s.flag)
+		if f == nil {
+			continue
+		}
+		rs := resolvedSetting{
+			Name:  s.flag,
+			Value: f.Value.String(),
+		}
+		switch {
+		case f.Changed:
+			rs.Source, rs.Origin = sourceFlag, "--"+s.flag
+		case os.Getenv(s.env) != "":
+			rs.Source, rs.Origin = sourceEnv, "$"+s.env
+		case accountSetFlags[s.flag]:
+			rs.Source, rs.Origin = sourceConfigFile, account
+		default:
+			rs.Source = sourceDefault
+		}
+		if hasPreset && rs.Source == sourceDefault {
+			switch s.flag {
+			case "smtp.provider":
+				rs.Value, rs.Source, rs.Origin = preset.Name, sourceInferred, "smtp.username"
+			case "smtp.host":
+				rs.Value, rs.Source, rs.Origin = preset.Host, sourcePreset, preset.Name
+			case "smtp.port":
+				rs.Value, rs.Source, rs.Origin = strconv.Itoa(preset.Port), sourcePreset, preset.Name
+			case "smtp.encryption":
+				rs.Value, rs.Source, rs.Origin = preset.Encryption, sourcePreset, preset.Name
+			}
+		}
+		if hasDiscovered && rs.Source == sourceDefault {
+			switch s.flag {
+			case "smtp.host":
+				rs.Value, rs.Source, rs.Origin = discovered.Host, sourceDiscovered, discovered.Record
+			case "smtp.port":
+				rs.Value, rs.Source, rs.Origin = strconv.Itoa(discovered.Port), sourceDiscovered, discovered.Record
+			case "smtp.encryption":
+				rs.Value, rs.Source, rs.Origin = discovered.Encryption, sourceDiscovered, discovered.Record
+			}
+		}
+		if s.secret && rs.Value != "" {
+			rs.Value = redacted
+		}
+		resolved.Settings = append(resolved.Settings, rs)
+	}
+
+	if transport.Name == transportResendOAuth {
+		login, loginErr := resendLogin()
+		if loginErr != nil {
+			resolved.Error = loginErr.Error()
+			return resolved, nil
+		}
+		authPath := authLocation(login)
+		token, authErr := loadAuth(login)
+		switch {
+		case authErr == nil:
+			resolved.Settings = append(resolved.Settings,
+				resolvedSetting{Name: "oauth.token", Value: redacted, Source: sourceOAuthStore, Origin: authPath},
+				resolvedSetting{Name: "oauth.expires_at", Value: token.ExpiresAt.Format(time.RFC3339), Source: sourceOAuthStore, Origin: authPath},
+			)
+		case os.IsNotExist(authErr):
+			resolved.Error = errNoOAuthToken.Error()
+		default:
+			resolved.Error = authErr.Error()
+		}
+	}
+
+	return resolved, nil
+}
+
+// sourceLabel returns a human-readable description of a setting's source.
+func (s resolvedSetting) sourceLabel() string {
+	switch s.Source {
+	case sourceFlag:
+		return "flag " + s.Origin
+	case sourceEnv:
+		return "env " + s.Origin
+	case sourceConfigFile:
+		return "config file (" + s.Origin + ")"
+	case sourceOAuthStore:
+		return "OAuth store"
+	case sourcePreset:
+		return "preset (" + s.Origin + ")"
+	case sourceInferred:
+		return "inferred from " + s.Origin
+	case sourceDiscovered:
+		return "discovered (" + s.Origin + ")"
+	default:
+		return "default"
+	}
+}
+
+// String renders the resolved config for humans.
+func (c *resolvedConfig) String() string {
+	const gap = "  "
+
+	var s strings.Builder
+	s.WriteString("\n")
+	label := labelStyle.Width(18) //nolint:mnd
+	row := func(name, value, source string) {
+		fmt.Fprintf(&s, "%s%s %s%s\n", gap, label.Render(name), value, commentStyle.Render(source))
+	}
+
+	configFile := tildePath(c.ConfigFile)
+	if _, err := os.Stat(c.ConfigFile); err != nil {
+		configFile += commentStyle.Render("(not found)")
+	}
+	row("config file", configFile, "")
+	row("account", ordefault(c.Account, "none"), "")
+	row("transport", ordefault(c.Transport, "none"), "")
+	if c.Error != "" {
+		fmt.Fprintf(&s, "%s%s %s\n", gap, label.Render("error"), errorStyle.Render(c.Error))
+	}
+	s.WriteString("\n")
+	for _, rs := range c.Settings {
+		row(rs.Name, ordefault(rs.Value, placeholderStyle.Render("unset")), rs.sourceLabel())
+	}
+	s.WriteString("\n")
+	return s.String()
+}
+
+// ordefault returns s, or def if s is empty.
+func ordefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// shareSettingsFlags adds the root command's delivery settings flags to cmd,
+// so subcommands resolve settings exactly the way sending does. The flags are
+// shared rather than copied, so setting one on cmd marks it as changed on the
+// root command too.
+func shareSettingsFlags(cmd *cobra.Command) {
+	for _, s := range settings {
+		if f := accountFlags.Lookup(s.flag); f != nil {
+			cmd.Flags().AddFlag(f)
+		}
+	}
+}
+
+func init() {
+	ConfigCmd.AddCommand(ConfigShowCmd)
+	ConfigShowCmd.Flags().BoolVar(&configShowJSON, "json", false, "Print the configuration as JSON")
+}
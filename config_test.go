@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestSaveAccount(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		account  string
+		want     []string
+		dropped  []string
+		wantDef  string
+	}{
+		{
+			name:    "new file",
+			account: "work",
+			want:    []string{`default = "work"`, "[accounts.work]"},
+			wantDef: "work",
+		},
+		{
+			name: "keeps comments and other accounts",
+			existing: `# my pop config
+default = "home"
+token_store = "keyring" # don't change
+
+[accounts.home]
+method = "resend-oauth" # via OAuth
+`,
+			account: "work",
+			want:    []string{"# my pop config", `token_store = "keyring" # don't change`, `method = "resend-oauth" # via OAuth`, "[accounts.work]"},
+			wantDef: "home",
+		},
+		{
+			name: "replaces the account and its subtables",
+			existing: `# accounts
+[accounts.work]
+method = "resend"
+[accounts.work.resend]
+api_key = "re_old"
+
+[accounts.home]
+method = "resend-oauth"
+`,
+			account: "work",
+			want:    []string{"# accounts", "[accounts.home]", "[accounts.work.smtp]"},
+			dropped: []string{"re_old"},
+			wantDef: "work",
+		},
+		{
+			name: "points a dangling default at the account",
+			existing: `default = "gone"
+
+[accounts]
+[accounts.home]
+method = "resend-oauth"
+`,
+			account: "work",
+			want:    []string{"[accounts]\n", `default = "work"`},
+			dropped: []string{`"gone"`},
+			wantDef: "work",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			t.Setenv(PopConfig, path)
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0o644); err != nil { //nolint:gosec
+					t.Fatal(err)
+				}
+			}
+			acct := Account{
+				Method: transportSMTP,
+				From:   "me@example.com",
+				SMTP:   SMTPAccount{Host: "smtp.example.com", Port: 587, PasswordInTokenStore: true},
+			}
+			if err := saveAccount(tt.account, acct); err != nil {
+				t.Fatalf("saveAccount: %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(string(data), s) {
+					t.Errorf("config doesn't contain %q:\n%s", s, data)
+				}
+			}
+			for _, s := range tt.dropped {
+				if strings.Contains(string(data), s) {
+					t.Errorf("config still contains %q:\n%s", s, data)
+				}
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != 0o600 {
+				t.Errorf("config file mode = %o, want 600", perm)
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if cfg.Default != tt.wantDef {
+				t.Errorf("default = %q, want %q", cfg.Default, tt.wantDef)
+			}
+			if got := cfg.Accounts[tt.account]; got.SMTP.Host != "smtp.example.com" || !got.SMTP.PasswordInTokenStore {
+				t.Errorf("saved account = %+v", got)
+			}
+		})
+	}
+}
+
+func TestStoreAccountSecret(t *testing.T) {
+	useTestDirs(t)
+	acct := Account{
+		Method: transportSMTP,
+		SMTP:   SMTPAccount{Username: "me", Password: "hunter2"},
+	}
+	if err := storeAccountSecret("work", &acct); err != nil {
+		t.Fatalf("storeAccountSecret: %v", err)
+	}
+	if acct.SMTP.Password != "" || !acct.SMTP.PasswordInTokenStore {
+		t.Errorf("password left in the account: %+v", acct.SMTP)
+	}
+	acct.name = "work"
+	got, err := acct.flagValue("smtp.password")
+	if err != nil {
+		t.Fatalf("flagValue: %v", err)
+	}
+	if got != "hunter2" {
+		t.Errorf("password = %q, want hunter2", got)
+	}
+}
+
+// countingSRVResolver counts the lookups made through it.
+type countingSRVResolver struct {
+	srvResolver
+	lookups int
+}
+
+func (r *countingSRVResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.lookups++
+	return r.srvResolver.LookupSRV(ctx, service, proto, name)
+}
+
+func TestResolveConfigDiscovery(t *testing.T) {
+	const config = `[accounts.resend]
+method = "resend"
+from = "me@example.org"
+[accounts.resend.resend]
+api_key = "re_test"
+
+[accounts.discover]
+method = "smtp"
+[accounts.discover.smtp]
+username = "me@example.org"
+
+[accounts.host]
+method = "smtp"
+[accounts.host.smtp]
+host = "smtp.example.net"
+username = "me@example.org"
+`
+	tests := []struct {
+		account  string
+		wantHost string
+		lookups  bool
+	}{
+		{account: "resend", wantHost: ""},
+		{account: "discover", wantHost: "mail.example.org", lookups: true},
+		{account: "host", wantHost: "smtp.example.net"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.account, func(t *testing.T) {
+			useTestDirs(t)
+			path := filepath.Join(t.TempDir(), "config.toml")
+			t.Setenv(PopConfig, path)
+			if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			resolver := &countingSRVResolver{srvResolver: fakeSRVResolver{
+				"submission": {addrs: []*net.SRV{{Target: "mail.example.org.", Port: 587}}},
+			}}
+			oldResolver, oldAccount := smtpResolver, accountName
+			t.Cleanup(func() {
+				smtpResolver, accountName = oldResolver, oldAccount
+				_ = applyAccount(accountFlags, Account{})
+			})
+			smtpResolver, accountName = resolver, tt.account
+
+			resolved, err := resolveConfig()
+			if err != nil {
+				t.Fatalf("resolveConfig: %v", err)
+			}
+			if got := resolver.lookups > 0; got != tt.lookups {
+				t.Errorf("made %d SRV lookups, want lookups = %v", resolver.lookups, tt.lookups)
+			}
+			for _, s := range resolved.Settings {
+				if s.Name == "smtp.host" && s.Value != tt.wantHost {
+					t.Errorf("smtp.host = %q, want %q", s.Value, tt.wantHost)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyAccountPrecedence(t *testing.T) {
+	acct := Account{
+		Method: transportSMTP,
+		From:   "account@example.com",
+		SMTP:   SMTPAccount{Host: "smtp.account.example", Port: 2525, Encryption: encryptionSSL},
+	}
+	tests := []struct {
+		name string
+		// flag and env are the flag's value on the command line and in
+		// its environment variable, if any.
+		flag, env string
+		acct      Account
+		want      string
+		explicit  bool
+	}{
+		{name: "default", acct: Account{Method: transportSMTP}, want: "starttls"},
+		{name: "account", acct: acct, want: encryptionSSL, explicit: true},
+		{name: "environment beats account", env: encryptionNone, acct: acct, want: encryptionNone, explicit: true},
+		{name: "flag beats environment", flag: encryptionSTARTTLS, env: encryptionNone, acct: acct, want: encryptionSTARTTLS, explicit: true},
+		{name: "flag beats account", flag: encryptionNone, acct: acct, want: encryptionNone, explicit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(PopSMTPEncryption, tt.env)
+			t.Setenv(PopSMTPInsecureSkipVerify, "")
+			t.Cleanup(func() { clear(accountSetFlags) })
+			// Like the root command, the flag's default comes from the
+			// environment.
+			fs := pflag.NewFlagSet("pop", pflag.ContinueOnError)
+			fs.String("smtp.encryption", ordefault(tt.env, "starttls"), "")
+			fs.Bool("smtp.insecure", false, "")
+			if tt.flag != "" {
+				if err := fs.Set("smtp.encryption", tt.flag); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := applyAccount(fs, tt.acct); err != nil {
+				t.Fatal(err)
+			}
+			if got := fs.Lookup("smtp.encryption").Value.String(); got != tt.want {
+				t.Errorf("smtp.encryption = %q, want %q", got, tt.want)
+			}
+			explicit := fs.Lookup("smtp.encryption").Changed || tt.env != "" || accountSetFlags["smtp.encryption"]
+			if explicit != tt.explicit {
+				t.Errorf("smtp.encryption set explicitly = %v, want %v", explicit, tt.explicit)
+			}
+			if accountSetFlags["smtp.insecure"] {
+				t.Error("smtp.insecure is recorded as set by an account that doesn't set it")
+			}
+		})
+	}
+
+	t.Run("insecure set by the account", func(t *testing.T) {
+		t.Setenv(PopSMTPInsecureSkipVerify, "")
+		t.Cleanup(func() { clear(accountSetFlags) })
+		fs := pflag.NewFlagSet("pop", pflag.ContinueOnError)
+		insecure := fs.Bool("smtp.insecure", false, "")
+		if err := applyAccount(fs, Account{Method: transportSMTP, SMTP: SMTPAccount{InsecureSkipVerify: true}}); err != nil {
+			t.Fatal(err)
+		}
+		if !*insecure || !accountSetFlags["smtp.insecure"] {
+			t.Errorf("smtp.insecure = %v (set by the account: %v), want true from the account", *insecure, accountSetFlags["smtp.insecure"])
+		}
+	})
+}
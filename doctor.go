@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/spf13/cobra"
+)
+
+// checkStatus is the outcome of a single diagnostic step.
+type checkStatus int
+
+const (
+	checkPass checkStatus = iota
+	checkWarn
+	checkFail
+	checkSkip
+)
+
+// doctorCheck is the result of a single diagnostic step.
+type doctorCheck struct {
+	name   string
+	status checkStatus
+	detail string
+	hint   string
+}
+
+// doctorReport collects the results of the diagnostic steps.
+type doctorReport struct {
+	checks []doctorCheck
+}
+
+func (r *doctorReport) pass(name, detail string) {
+	r.checks = append(r.checks, doctorCheck{name: name, status: checkPass, detail: detail})
+}
+
+func (r *doctorReport) warn(name, detail, hint string) {
+	r.checks = append(r.checks, doctorCheck{name: name, status: checkWarn, detail: detail, hint: hint})
+}
+
+func (r *doctorReport) fail(name, detail, hint string) {
+	r.checks = append(r.checks, doctorCheck{name: name, status: checkFail, detail: detail, hint: hint})
+}
+
+func (r *doctorReport) skip(name, detail string) {
+	r.checks = append(r.checks, doctorCheck{name: name, status: checkSkip, detail: detail})
+}
+
+// failed reports whether any step failed.
+func (r *doctorReport) failed() bool {
+	for _, c := range r.checks {
+		if c.status == checkFail {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the report.
+func (r *doctorReport) String() string {
+	const gap = "  "
+
+	label := labelStyle.Width(10) //nolint:mnd
+	var s strings.Builder
+	for _, c := range r.checks {
+		var mark string
+		switch c.status {
+		case checkPass:
+			mark = activeLabelStyle.Render("✓")
+		case checkWarn:
+			mark = attachmentsTitleActiveStyle.Render("!")
+		case checkFail:
+			mark = errorStyle.Render("✗")
+		case checkSkip:
+			mark = placeholderStyle.Render("-")
+		}
+		fmt.Fprintf(&s, "%s%s %s %s\n", gap, mark, label.Render(c.name), c.detail)
+		if c.hint != "" {
+			fmt.Fprintf(&s, "%s  %s %s\n", gap, label.Render(""), commentStyle.Render("→ "+c.hint))
+		}
+	}
+	return s.String()
+}
+
+// diagnoser is implemented by senders that can check their own
+// configuration and connectivity for `pop doctor`.
+type diagnoser interface {
+	diagnose(ctx context.Context, r *doctorReport)
+}
+
+var doctorTimeout time.Duration
+
+// DoctorCmd walks through the configured transport step by step and reports
+// where things go wrong.
+var DoctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose connectivity and credential problems",
+	Long: `Check the configured delivery method step by step.
+
+For SMTP this resolves the host, connects, lists the server's capabilities,
+negotiates TLS, authenticates and checks that the server will relay for the
+sender. For Resend it checks the API key or OAuth token.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+		w := colorprofile.NewWriter(os.Stderr, os.Environ())
+
+		if _, _, err := useAccount(accountName); err != nil {
+			_, _ = fmt.Fprintf(w, "\n  %s %s\n\n", errorHeaderStyle.String(), err)
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), doctorTimeout)
+		defer cancel()
+
+		var r doctorReport
+		runDoctor(ctx, &r)
+
+		_, _ = fmt.Fprintf(w, "\n%s\n", r.String())
+		if r.failed() {
+			return errors.New("doctor found problems")
+		}
+		return nil
+	},
+}
+
+// runDoctor picks the configured transport and diagnoses it.
+func runDoctor(ctx context.Context, r *doctorReport) {
+	transport, err := selectTransport()
+	if errors.Is(err, errNoTransport) {
+		if _, authErr := loadAuth(providerResend); authErr == nil {
+			transport, _ = lookupTransport(transportResendOAuth)
+			err = nil
+		}
+	}
+	if err != nil {
+		r.fail("Transport", err.Error(), "Run `pop config show` to see where each setting comes from.")
+		return
+	}
+	r.pass("Transport", transport.Name)
+
+	if transport.Name == transportResendOAuth {
+		login, err := resendLogin()
+		if err != nil {
+			r.fail("Login", err.Error(), "")
+			return
+		}
+		if !diagnoseOAuthToken(ctx, r, login) {
+			return
+		}
+	}
+
+	sender, err := transport.New()
+	if err != nil {
+		r.fail("Setup", err.Error(), "")
+		return
+	}
+	d, ok := sender.(diagnoser)
+	if !ok {
+		r.skip("Checks", "no diagnostics available for "+transport.Name)
+		return
+	}
+	d.diagnose(ctx, r)
+}
+
+// diagnoseOAuthToken checks the login's stored OAuth token, refreshing it if
+// it has expired. It reports whether the token is usable.
+func diagnoseOAuthToken(ctx context.Context, r *doctorReport, login string) bool {
+	provider, _ := splitLoginKey(login)
+	p, err := lookupOAuthProvider(provider)
+	if err != nil {
+		r.fail("Token", err.Error(), "")
+		return false
+	}
+	reauth := "Run `" + authCommand(login) + "` to authenticate with " + loginLabel(login) + " again."
+	token, err := loadAuth(login)
+	if os.IsNotExist(err) {
+		r.fail("Token", "no OAuth token stored", "Run `"+authCommand(login)+"` to authenticate with "+loginLabel(login)+".")
+		return false
+	}
+	if err != nil {
+		r.fail("Token", err.Error(), reauth)
+		return false
+	}
+	if !token.expired() {
+		r.pass("Token", "valid until "+token.ExpiresAt.Local().Format(time.DateTime))
+		return true
+	}
+
+	r.warn("Token", "expired at "+token.ExpiresAt.Local().Format(time.DateTime), "")
+	token, err = refreshAuth(ctx, p, login)
+	if err != nil {
+		r.fail("Refresh", err.Error(), reauth)
+		return false
+	}
+	r.pass("Refresh", "valid until "+token.ExpiresAt.Local().Format(time.DateTime))
+	return true
+}
+
+// diagnose checks that Resend accepts the API key or OAuth token.
+func (s *ResendSender) diagnose(ctx context.Context, r *doctorReport) {
+	base := strings.TrimSuffix(resendBaseURL(), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/domains", nil)
+	if err != nil {
+		r.fail("API", err.Error(), "Check RESEND_BASE_URL.")
+		return
+	}
+	apiKey, err := s.apiKey()
+	if err != nil {
+		r.fail("API", err.Error(), "")
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.fail("API", err.Error(), "Check your network connection and RESEND_BASE_URL.")
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		r.pass("API", base+" accepted the credentials")
+	case resp.StatusCode == http.StatusUnauthorized && strings.Contains(string(body), "restricted"):
+		// Sending-only keys can't list domains, but they're valid.
+		r.pass("API", base+" accepted the credentials (sending access only)")
+		r.skip("From", "a sending-only API key can't list domains to check the From address")
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		r.fail("API", resp.Status+": "+strings.TrimSpace(string(body)),
+			"The API key or token was rejected. Create a new key at https://resend.com/api-keys or run `pop auth` again.")
+	default:
+		r.fail("API", resp.Status+": "+strings.TrimSpace(string(body)), "Check RESEND_BASE_URL or try again later.")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	domains, err := s.verifiedDomains(ctx, true)
+	switch {
+	case errors.Is(err, errDomainsUnavailable):
+		r.skip("From", "the credentials can't list domains to check the From address")
+	case err != nil:
+		r.warn("From", err.Error(), "")
+	case from == "":
+		r.skip("From", "no From address set; verified domains: "+ordefault(strings.Join(domains, ", "), "none"))
+	default:
+		if err := s.checkFrom(ctx, from); err != nil {
+			r.fail("From", err.Error(), "Send from an address on a verified domain, or verify "+addressDomain(from)+" at https://resend.com/domains.")
+			return
+		}
+		r.pass("From", addressDomain(from)+" is verified")
+	}
+}
+
+// diagnose walks through an SMTP session without sending anything.
+func (s *SMTPSender) diagnose(ctx context.Context, r *doctorReport) {
+	host, port := s.addr()
+	if host == "" {
+		r.fail("Resolve", "no SMTP host configured", "Set --smtp.host or $"+PopSMTPHost+", or pick a preset with --smtp.provider.")
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	// Resolve.
+	if ip := net.ParseIP(host); ip != nil {
+		r.skip("Resolve", host+" is an IP address")
+	} else {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			r.fail("Resolve", err.Error(), "Check --smtp.host for typos.")
+			return
+		}
+		r.pass("Resolve", host+" → "+strings.Join(addrs, ", "))
+	}
+
+	// Connect.
+	start := time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		r.fail("Connect", err.Error(), "Check --smtp.port. Submission usually uses 587 (starttls) or 465 (ssl); a firewall may also block outbound SMTP.")
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	r.pass("Connect", fmt.Sprintf("%s in %s", conn.RemoteAddr(), time.Since(start).Round(time.Millisecond)))
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: s.insecureSkipVerify(), //nolint:gosec
+		ServerName:         host,
+	}
+	encryption := s.encryption()
+	if encryption == encryptionSSL {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			r.fail("TLS", err.Error(), tlsHint(err, encryption))
+			return
+		}
+		conn = tlsConn
+		r.pass("TLS", "implicit TLS, "+tlsDetail(tlsConn.ConnectionState(), s.insecureSkipVerify()))
+	}
+
+	// Greeting.
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		r.fail("Greeting", err.Error(), "The server didn't greet us. If it expects implicit TLS use --smtp.encryption ssl (usually port 465); otherwise use starttls (usually port 587).")
+		return
+	}
+	defer func() { _ = client.Close() }()
+	r.pass("Greeting", "server is ready")
+
+	// EHLO. We send it ourselves so we can show the raw capabilities;
+	// net/smtp sends its own EHLO the first time it needs them.
+	caps, err := ehlo(client)
+	if err != nil {
+		r.fail("EHLO", err.Error(), "The server rejected EHLO.")
+		return
+	}
+	r.pass("EHLO", strings.Join(caps, ", "))
+
+	// TLS.
+	switch encryption {
+	case encryptionSTARTTLS:
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			r.fail("TLS", "server doesn't offer STARTTLS", "Try --smtp.encryption ssl on port 465, or none if you trust the network.")
+			return
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			r.fail("TLS", err.Error(), tlsHint(err, encryption))
+			return
+		}
+		state, _ := client.TLSConnectionState()
+		r.pass("TLS", "STARTTLS, "+tlsDetail(state, s.insecureSkipVerify()))
+	case encryptionNone:
+		r.warn("TLS", "encryption disabled", "Credentials and mail are sent in the clear.")
+	}
+
+	// Authentication.
+	if s.Username == "" {
+		r.skip("Auth", "no username configured")
+	} else {
+		ok, mechanisms := client.Extension("AUTH")
+		if !ok {
+			r.fail("Auth", "server doesn't advertise AUTH", "The server may only allow AUTH after TLS, or it may be a relay that doesn't need credentials.")
+			return
+		}
+		auth, err := s.auth(mechanisms)
+		if err != nil {
+			r.fail("Auth", err.Error(), "Pick a mechanism the server offers with --smtp.auth, or check $"+PopSMTPTokenCmd+".")
+			return
+		}
+		mechanism, _, _ := auth.Start(nil)
+		if err := client.Auth(auth); err != nil {
+			hint := "Check --smtp.username and --smtp.password. Gmail, iCloud and others require an app password."
+			if mechanism == saslXOAUTH2 || mechanism == saslOAuthBearer {
+				hint = "The OAuth token was rejected. Check $" + PopSMTPTokenCmd + " or that the token grants SMTP access."
+			}
+			r.fail("Auth", err.Error(), hint)
+			return
+		}
+		r.pass("Auth", mechanism+" as "+s.Username)
+	}
+
+	// Relay. Ask the server to accept mail from and to the sender, then
+	// reset without sending anything.
+	if from == "" {
+		r.skip("Relay", "no From address configured")
+	} else {
+		if err := client.Mail(from); err != nil {
+			r.fail("Relay", "MAIL FROM: "+err.Error(), "The server won't accept mail from "+from+". It may need to match your account or a verified alias.")
+			return
+		}
+		if err := client.Rcpt(from); err != nil {
+			r.fail("Relay", "RCPT TO: "+err.Error(), "The server refused to relay. You may need to authenticate, or the From address isn't allowed.")
+			return
+		}
+		_ = client.Reset()
+		r.pass("Relay", "server accepts mail from "+from)
+	}
+
+	_ = client.Quit()
+}
+
+// ehlo sends EHLO and returns the server's capabilities.
+func ehlo(client *smtp.Client) ([]string, error) {
+	id, err := client.Text.Cmd("EHLO localhost")
+	if err != nil {
+		return nil, fmt.Errorf("sending EHLO: %w", err)
+	}
+	client.Text.StartResponse(id)
+	defer client.Text.EndResponse(id)
+	_, msg, err := client.Text.ReadResponse(250) //nolint:mnd
+	if err != nil {
+		return nil, fmt.Errorf("reading EHLO response: %w", err)
+	}
+	lines := strings.Split(msg, "\n")
+	// The first line is the server's name.
+	return lines[1:], nil
+}
+
+// tlsDetail describes a negotiated TLS connection.
+func tlsDetail(state tls.ConnectionState, insecure bool) string {
+	detail := tls.VersionName(state.Version)
+	if len(state.PeerCertificates) > 0 {
+		detail += ", certificate for " + state.PeerCertificates[0].Subject.CommonName
+	}
+	if insecure {
+		detail += " (not verified)"
+	}
+	return detail
+}
+
+// tlsHint suggests a fix for a failed TLS negotiation.
+func tlsHint(err error, encryption string) string {
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.As(err, &certErr):
+		return "The server's certificate couldn't be verified. --smtp.insecure skips verification, but only use it if you trust the network."
+	case encryption == encryptionSSL:
+		return "The server may not speak implicit TLS on this port. Try --smtp.encryption starttls (usually port 587)."
+	default:
+		return "TLS negotiation failed. Try --smtp.encryption ssl on port 465."
+	}
+}
+
+func init() {
+	DoctorCmd.Flags().DurationVar(&doctorTimeout, "timeout", 30*time.Second, "How long to wait for all checks to finish")
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// doctorRow is the expected outcome of a doctor step.
+type doctorRow struct {
+	name   string
+	status checkStatus
+	// hint is a substring of the step's hint.
+	hint string
+}
+
+// checkDoctorReport compares the report's steps with want, in order.
+func checkDoctorReport(t *testing.T, r *doctorReport, want []doctorRow) {
+	t.Helper()
+	if len(r.checks) != len(want) {
+		t.Fatalf("got %d steps, want %d:\n%s", len(r.checks), len(want), r)
+	}
+	for i, w := range want {
+		c := r.checks[i]
+		if c.name != w.name || c.status != w.status || !strings.Contains(c.hint, w.hint) {
+			t.Errorf("step %d = %s (%d) %q → %q, want %s (%d) with hint containing %q",
+				i, c.name, c.status, c.detail, c.hint, w.name, w.status, w.hint)
+		}
+	}
+}
+
+// useFrom sets the From address for the test.
+func useFrom(t *testing.T, address string) {
+	t.Helper()
+	old := from
+	t.Cleanup(func() { from = old })
+	from = address
+}
+
+func TestDoctorSMTP(t *testing.T) {
+	tests := []struct {
+		name string
+		stub *smtpStub
+		// configure adjusts the stub's sender.
+		configure func(s *SMTPSender)
+		want      []doctorRow
+	}{
+		{
+			name: "all good",
+			stub: &smtpStub{mechanisms: "PLAIN LOGIN"},
+			want: []doctorRow{
+				{"Resolve", checkSkip, ""},
+				{"Connect", checkPass, ""},
+				{"Greeting", checkPass, ""},
+				{"EHLO", checkPass, ""},
+				{"TLS", checkWarn, "in the clear"},
+				{"Auth", checkPass, ""},
+				{"Relay", checkPass, ""},
+			},
+		},
+		{
+			name: "auth failure",
+			stub: &smtpStub{mechanisms: "PLAIN LOGIN", rejectAuth: true},
+			want: []doctorRow{
+				{"Resolve", checkSkip, ""},
+				{"Connect", checkPass, ""},
+				{"Greeting", checkPass, ""},
+				{"EHLO", checkPass, ""},
+				{"TLS", checkWarn, ""},
+				{"Auth", checkFail, "app password"},
+			},
+		},
+		{
+			name: "OAuth token rejected",
+			stub: &smtpStub{mechanisms: "XOAUTH2", rejectAuth: true, challenge: googleAuthChallenge},
+			configure: func(s *SMTPSender) {
+				s.Auth = "xoauth2"
+				s.TokenCmd = "echo expired"
+			},
+			want: []doctorRow{
+				{"Resolve", checkSkip, ""},
+				{"Connect", checkPass, ""},
+				{"Greeting", checkPass, ""},
+				{"EHLO", checkPass, ""},
+				{"TLS", checkWarn, ""},
+				{"Auth", checkFail, "OAuth token was rejected"},
+			},
+		},
+		{
+			name: "untrusted certificate",
+			stub: &smtpStub{mechanisms: "PLAIN", tlsConfig: selfSignedTLSConfig(t)},
+			want: []doctorRow{
+				{"Resolve", checkSkip, ""},
+				{"Connect", checkPass, ""},
+				{"Greeting", checkPass, ""},
+				{"EHLO", checkPass, ""},
+				{"TLS", checkFail, "certificate couldn't be verified"},
+			},
+		},
+		{
+			name:      "untrusted certificate, verification skipped",
+			stub:      &smtpStub{mechanisms: "PLAIN", tlsConfig: selfSignedTLSConfig(t)},
+			configure: func(s *SMTPSender) { s.InsecureSkipVerify = true },
+			want: []doctorRow{
+				{"Resolve", checkSkip, ""},
+				{"Connect", checkPass, ""},
+				{"Greeting", checkPass, ""},
+				{"EHLO", checkPass, ""},
+				{"TLS", checkPass, ""},
+				{"Auth", checkPass, ""},
+				{"Relay", checkPass, ""},
+			},
+		},
+		{
+			name:      "implicit TLS on a plaintext port",
+			stub:      &smtpStub{mechanisms: "PLAIN"},
+			configure: func(s *SMTPSender) { s.Encryption = encryptionSSL },
+			want: []doctorRow{
+				{"Resolve", checkSkip, ""},
+				{"Connect", checkPass, ""},
+				{"TLS", checkFail, "--smtp.encryption starttls"},
+			},
+		},
+		{
+			name:      "STARTTLS not offered",
+			stub:      &smtpStub{mechanisms: "PLAIN"},
+			configure: func(s *SMTPSender) { s.Encryption = encryptionSTARTTLS },
+			want: []doctorRow{
+				{"Resolve", checkSkip, ""},
+				{"Connect", checkPass, ""},
+				{"Greeting", checkPass, ""},
+				{"EHLO", checkPass, ""},
+				{"TLS", checkFail, "--smtp.encryption ssl"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFrom(t, "me@example.com")
+			stub := startSMTPStub(t, tt.stub)
+			sender := stub.sender()
+			sender.Username = "me@example.com"
+			sender.Password = "hunter2"
+			if tt.configure != nil {
+				tt.configure(sender)
+			}
+
+			var r doctorReport
+			sender.diagnose(context.Background(), &r)
+			checkDoctorReport(t, &r, tt.want)
+		})
+	}
+}
+
+func TestDoctorResend(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   string
+		status int
+		body   string
+		want   []doctorRow
+	}{
+		{
+			name:   "verified domain",
+			from:   "me@example.com",
+			status: http.StatusOK,
+			body:   `{"data":[{"name":"example.com","status":"verified"}]}`,
+			want: []doctorRow{
+				{"API", checkPass, ""},
+				{"From", checkPass, ""},
+			},
+		},
+		{
+			name:   "unverified domain",
+			from:   "me@elsewhere.com",
+			status: http.StatusOK,
+			body:   `{"data":[{"name":"example.com","status":"verified"}]}`,
+			want: []doctorRow{
+				{"API", checkPass, ""},
+				{"From", checkFail, "verify elsewhere.com"},
+			},
+		},
+		{
+			name:   "rejected key",
+			from:   "me@example.com",
+			status: http.StatusUnauthorized,
+			body:   `{"statusCode":401,"name":"validation_error","message":"API key is invalid"}`,
+			want: []doctorRow{
+				{"API", checkFail, "resend.com/api-keys"},
+			},
+		},
+		{
+			name:   "sending-only key",
+			from:   "me@example.com",
+			status: http.StatusUnauthorized,
+			body:   `{"statusCode":401,"name":"restricted_api_key","message":"This API key is restricted to only send emails"}`,
+			want: []doctorRow{
+				{"API", checkPass, ""},
+				{"From", checkSkip, ""},
+			},
+		},
+		{
+			name:   "server error",
+			from:   "me@example.com",
+			status: http.StatusInternalServerError,
+			body:   `{"message":"internal server error"}`,
+			want: []doctorRow{
+				{"API", checkFail, "RESEND_BASE_URL"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDirs(t)
+			useFrom(t, tt.from)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/domains" || r.Header.Get("Authorization") != "Bearer re_test" {
+					http.Error(w, "unexpected request", http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			t.Cleanup(srv.Close)
+			t.Setenv("RESEND_BASE_URL", srv.URL)
+
+			var r doctorReport
+			sender := &ResendSender{APIKey: "re_test"}
+			sender.diagnose(context.Background(), &r)
+			checkDoctorReport(t, &r, tt.want)
+		})
+	}
+}
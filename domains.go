@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/resendlabs/resend-go"
+	"github.com/spf13/cobra"
+)
+
+// domainCacheTTL is how long the verified domains fetched from Resend are
+// trusted. A From domain missing from the cache is always checked again, so
+// newly verified domains work straight away.
+const domainCacheTTL = 24 * time.Hour
+
+// resendTestDomain is the domain Resend lets every account send from, to the
+// account's own address, before verifying a domain of its own.
+const resendTestDomain = "resend.dev"
+
+// errDomainsUnavailable is returned when the credentials can't list domains:
+// a sending-only API key, or an OAuth token from before pop asked for the
+// domains scope.
+var errDomainsUnavailable = errors.New("the Resend credentials can't list domains")
+
+// unverifiedDomainError is returned when the From address isn't on one of
+// the account's verified domains.
+type unverifiedDomainError struct {
+	domain   string
+	verified []string
+}
+
+func (e *unverifiedDomainError) Error() string {
+	if len(e.verified) == 0 {
+		return e.domain + " isn't a verified domain, and the Resend account has none yet: add one at https://resend.com/domains"
+	}
+	return e.domain + " isn't a verified domain in the Resend account (verified: " + strings.Join(e.verified, ", ") + ")"
+}
+
+// domainLister is implemented by senders that know which domains they may
+// send from.
+type domainLister interface {
+	// verifiedDomains returns the verified domains, from the cache unless
+	// refresh is set.
+	verifiedDomains(ctx context.Context, refresh bool) ([]string, error)
+}
+
+// listResendDomains lists the domains of the Resend account the API key or
+// access token belongs to.
+func listResendDomains(ctx context.Context, apiKey string) ([]resend.Domain, error) {
+	base := strings.TrimSuffix(resendBaseURL(), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/domains", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating domains request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("listing domains: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if strings.Contains(string(body), "restricted") || strings.Contains(string(body), "scope") {
+			return nil, errDomainsUnavailable
+		}
+		fallthrough
+	default:
+		return nil, fmt.Errorf("listing domains: %w", &HTTPError{Status: resp.Status, Body: strings.TrimSpace(string(body))})
+	}
+
+	var domains resend.ListDomainsResponse
+	if err := json.Unmarshal(body, &domains); err != nil {
+		return nil, fmt.Errorf("parsing domains: %w", err)
+	}
+	return domains.Data, nil
+}
+
+// domainCacheEntry is the cached list of one account's verified domains.
+type domainCacheEntry struct {
+	Domains   []string  `json:"domains"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// domainCachePath returns the path to the verified domains cache.
+func domainCachePath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("getting cache directory: %w", err)
+	}
+	dir := filepath.Join(cacheDir, "pop")
+	if err := os.MkdirAll(dir, 0o700); err != nil { //nolint:gosec // G703: cacheDir is from a trusted source
+		return "", fmt.Errorf("creating cache directory: %w", err)
+	}
+	return filepath.Join(dir, "domains.json"), nil
+}
+
+// readDomainCache reads the verified domains cache. A missing or unreadable
+// cache is just empty.
+func readDomainCache() map[string]domainCacheEntry {
+	cache := map[string]domainCacheEntry{}
+	path, err := domainCachePath()
+	if err != nil {
+		return cache
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	_ = json.Unmarshal(data, &cache)
+	return cache
+}
+
+// cacheKey identifies the account the sender's credentials belong to in the
+// domain cache, without storing the API key itself.
+func (s *ResendSender) cacheKey() string {
+	if s.OAuthLogin != "" {
+		return "oauth:" + s.OAuthLogin
+	}
+	sum := sha256.Sum256([]byte(s.APIKey))
+	return "key:" + hex.EncodeToString(sum[:8])
+}
+
+// verifiedDomains returns the verified domains of the sender's Resend
+// account.
+func (s *ResendSender) verifiedDomains(ctx context.Context, refresh bool) ([]string, error) {
+	cache := readDomainCache()
+	key := s.cacheKey()
+	if entry, ok := cache[key]; ok && !refresh && time.Since(entry.FetchedAt) < domainCacheTTL {
+		return entry.Domains, nil
+	}
+
+	apiKey, err := s.apiKey()
+	if err != nil {
+		return nil, err
+	}
+	domains, err := listResendDomains(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	verified := []string{}
+	for _, d := range domains {
+		if d.Status == "verified" {
+			verified = append(verified, strings.ToLower(d.Name))
+		}
+	}
+	slices.Sort(verified)
+
+	cache[key] = domainCacheEntry{Domains: verified, FetchedAt: time.Now()}
+	if path, err := domainCachePath(); err == nil {
+		if data, err := json.MarshalIndent(cache, "", "  "); err == nil {
+			_ = writeFileAtomic(path, data)
+		}
+	}
+	return verified, nil
+}
+
+// checkFrom checks that the From address is on one of the sender's verified
+// domains, before Resend gets the chance to reject it. It gives up quietly
+// when the domains can't be listed.
+func (s *ResendSender) checkFrom(ctx context.Context, from string) error {
+	domain := addressDomain(from)
+	if domain == "" || domain == resendTestDomain {
+		return nil
+	}
+	domains, err := s.verifiedDomains(ctx, false)
+	if err != nil {
+		return nil //nolint:nilerr // Resend has the final say anyway
+	}
+	if !slices.Contains(domains, domain) {
+		// The domain may have been verified since the cache was
+		// filled.
+		domains, err = s.verifiedDomains(ctx, true)
+		if err != nil {
+			return nil //nolint:nilerr
+		}
+	}
+	if slices.Contains(domains, domain) {
+		return nil
+	}
+	return &unverifiedDomainError{domain: domain, verified: domains}
+}
+
+// fromSuggestions returns the addresses on the given domains with the local
+// part typed so far, e.g. "me@example.com" for "me" or "me@ex".
+func fromSuggestions(value string, domains []string) []string {
+	local, _, _ := strings.Cut(value, "@")
+	if local == "" || strings.ContainsAny(local, " <") {
+		return nil
+	}
+	suggestions := make([]string, len(domains))
+	for i, d := range domains {
+		suggestions[i] = local + "@" + d
+	}
+	return suggestions
+}
+
+// completeFrom completes --from with the verified domains of the configured
+// Resend account.
+func completeFrom(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if _, _, err := useAccount(accountName); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	transport, err := pickTransport()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	sender, err := transport.New()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	dl, ok := sender.(domainLister)
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	domains, err := dl.verifiedDomains(ctx, false)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return fromSuggestions(toComplete, domains), cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// domainsStandIn is a stand-in for Resend's /domains endpoint, listing each
+// API key's domains by status.
+type domainsStandIn struct {
+	mu       sync.Mutex
+	domains  map[string]map[string]string
+	requests int
+}
+
+func useDomainsStandIn(t *testing.T, domains map[string]map[string]string) *domainsStandIn {
+	t.Helper()
+	s := &domainsStandIn{domains: domains}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	t.Setenv("RESEND_BASE_URL", srv.URL)
+	return s
+}
+
+// verify marks a domain of the key's account verified.
+func (s *domainsStandIn) verify(key, domain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.domains[key][domain] = "verified"
+}
+
+// asked returns how many times the domains were listed.
+func (s *domainsStandIn) asked() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *domainsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if r.Method != http.MethodGet || r.URL.Path != "/domains" {
+		http.NotFound(w, r)
+		return
+	}
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	domains, ok := s.domains[key]
+	if !ok {
+		http.Error(w, `{"name":"restricted_api_key","message":"This API key is restricted to only send emails"}`, http.StatusUnauthorized)
+		return
+	}
+	type domain struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	}
+	var data []domain
+	for name, status := range domains {
+		data = append(data, domain{name, status})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func TestCheckFrom(t *testing.T) {
+	useTestDirs(t)
+	standIn := useDomainsStandIn(t, map[string]map[string]string{
+		"re_full": {"example.com": "verified", "Mixed.example": "verified", "pending.example": "pending"},
+	})
+	sender := &ResendSender{APIKey: "re_full"}
+	check := func(from string, wantRequests int) error {
+		t.Helper()
+		before := standIn.asked()
+		err := sender.checkFrom(t.Context(), from)
+		if got := standIn.asked() - before; got != wantRequests {
+			t.Errorf("checkFrom(%q) listed the domains %d times, want %d", from, got, wantRequests)
+		}
+		return err
+	}
+
+	if err := check("Me <me@example.com>", 1); err != nil {
+		t.Fatalf("checkFrom on a verified domain: %v", err)
+	}
+	// The cache answers from now on.
+	if err := check("me@mixed.example", 0); err != nil {
+		t.Errorf("checkFrom on a verified domain, from the cache: %v", err)
+	}
+
+	// A domain missing from the cache may have been verified since.
+	standIn.verify("re_full", "pending.example")
+	if err := check("me@pending.example", 1); err != nil {
+		t.Errorf("checkFrom on a newly verified domain: %v", err)
+	}
+
+	var unverified *unverifiedDomainError
+	err := check("me@elsewhere.example", 1)
+	if !errors.As(err, &unverified) {
+		t.Fatalf("checkFrom on an unverified domain = %v, want an unverifiedDomainError", err)
+	}
+	if want := []string{"example.com", "mixed.example", "pending.example"}; unverified.domain != "elsewhere.example" || !slices.Equal(unverified.verified, want) {
+		t.Errorf("unverified domain %q with %v verified, want elsewhere.example with %v", unverified.domain, unverified.verified, want)
+	}
+
+	// Resend lets every account send from its test domain.
+	if err := check("onboarding@resend.dev", 0); err != nil {
+		t.Errorf("checkFrom on %s: %v", resendTestDomain, err)
+	}
+	if err := check("no domain", 0); err != nil {
+		t.Errorf("checkFrom without a domain: %v", err)
+	}
+
+	// A sending-only key can't list domains, so Resend has the last word.
+	restricted := &ResendSender{APIKey: "re_sending_only"}
+	if _, err := restricted.verifiedDomains(t.Context(), false); !errors.Is(err, errDomainsUnavailable) {
+		t.Errorf("verifiedDomains with a restricted key = %v, want errDomainsUnavailable", err)
+	}
+	if err := restricted.checkFrom(t.Context(), "me@elsewhere.example"); err != nil {
+		t.Errorf("checkFrom with a restricted key = %v, want nil", err)
+	}
+}
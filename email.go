@@ -2,12 +2,27 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/smtp"
+	"net/textproto"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	tea "charm.land/bubbletea/v2"
@@ -23,126 +38,755 @@ const ToSeparator = ","
 
 // sendEmailSuccessMsg is the tea.Msg handled by Bubble Tea when the email has
 // been sent successfully.
-type sendEmailSuccessMsg struct{}
+type sendEmailSuccessMsg struct {
+	receipt *Receipt
+	// queued is set instead of receipt when the email was put in the
+	// outbox to try again later.
+	queued *OutboxItem
+}
 
 // sendEmailFailureMsg is the tea.Msg handled by Bubble Tea when the email has
 // failed to send.
 type sendEmailFailureMsg error
 
-// sendEmailCmd returns a tea.Cmd that sends the email.
-func (m Model) sendEmailCmd() tea.Cmd {
+// sendEmailCmd returns a tea.Cmd that sends the email. Canceling ctx stops
+// the send.
+func (m Model) sendEmailCmd(ctx context.Context) tea.Cmd {
 	return func() tea.Msg {
 		attachments := make([]string, len(m.Attachments.Items()))
 		for i, a := range m.Attachments.Items() {
 			attachments[i] = a.FilterValue()
 		}
-		var err error
-		to := strings.Split(m.To.Value(), ToSeparator)
-		cc := strings.Split(m.Cc.Value(), ToSeparator)
-		bcc := strings.Split(m.Bcc.Value(), ToSeparator)
-		switch m.DeliveryMethod {
-		case SMTP:
-			err = sendSMTPEmail(to, cc, bcc, m.From.Value(), m.Subject.Value(), m.Body.Value(), plaintext, attachments)
-		case Resend:
-			err = sendResendEmail(to, cc, bcc, m.From.Value(), m.Subject.Value(), m.Body.Value(), plaintext, attachments)
-		default:
-			err = errors.New("[ERROR]: unknown delivery method")
+		msg := Message{
+			From:        m.From.Value(),
+			To:          strings.Split(m.To.Value(), ToSeparator),
+			Cc:          strings.Split(m.Cc.Value(), ToSeparator),
+			Bcc:         strings.Split(m.Bcc.Value(), ToSeparator),
+			Subject:     m.Subject.Value(),
+			Body:        m.Body.Value(),
+			Plaintext:   plaintext,
+			Attachments: attachments,
+			SendAt:      m.sendAt,
+		}
+		if m.Sender == nil {
+			return sendEmailFailureMsg(errors.New("[ERROR]: unknown delivery method"))
+		}
+		if !m.sendAt.IsZero() && !m.sendAt.After(time.Now()) {
+			return sendEmailFailureMsg(errors.New("the time the email was scheduled for has passed: press s on Send to pick another"))
+		}
+		d, err := deliver(ctx, m.account, m.Sender, msg)
+		var reauth *reauthError
+		if errors.As(err, &reauth) {
+			// Nothing went out: keep the draft in the TUI and have the
+			// user sign in again.
+			return reauthRequiredMsg{login: reauth.login}
+		}
+		if canceledUnsent(err) {
+			// The draft is still in the TUI.
+			return sendEmailFailureMsg(err)
 		}
 		if err != nil {
+			if d.entry != nil {
+				return sendEmailFailureMsg(fmt.Errorf("%w\nSaved to the history: pop log resend %s", err, d.entry.ID))
+			}
 			path, storeErr := saveTmp(m.Body.Value())
 			if storeErr == nil {
 				err = fmt.Errorf("%w\nEmail saved to: %s", err, path)
 			}
 			return sendEmailFailureMsg(err)
 		}
-		return sendEmailSuccessMsg{}
+		return sendEmailSuccessMsg{receipt: d.receipt, queued: d.queued}
 	}
 }
 
+// Names of the built-in transports.
+const (
+	transportSMTP        = "smtp"
+	transportResend      = "resend"
+	transportResendOAuth = "resend-oauth"
+)
+
+// errNoOAuthToken is returned when OAuth delivery is requested but no token
+// has been stored with `pop auth`.
+var errNoOAuthToken = errors.New("no OAuth token found")
+
+func init() {
+	RegisterTransport(Transport{
+		Name:       transportSMTP,
+		Configured: func() bool { return smtpHost != "" || smtpUsername != "" || smtpProvider != "" },
+		New: func() (Sender, error) {
+			if smtpProvider != "" {
+				if _, err := lookupSMTPPreset(smtpProvider); err != nil {
+					return nil, err
+				}
+			}
+			// The port flag always has a value, so only pass it on when the
+			// user actually chose it and let the preset fill it in otherwise.
+			port := smtpPort
+			if !settingExplicit("smtp.port") {
+				port = 0
+			}
+			sender := &SMTPSender{
+				Provider:           smtpProvider,
+				Host:               smtpHost,
+				Port:               port,
+				Username:           smtpUsername,
+				Password:           smtpPassword,
+				Encryption:         smtpEncryption,
+				InsecureSkipVerify: smtpInsecureSkipVerify,
+				Auth:               smtpAuth,
+				TokenCmd:           smtpTokenCmd,
+				ConnectTimeout:     connectTimeout,
+				SendTimeout:        sendTimeout,
+			}
+			if _, err := sender.mechanism(); err != nil {
+				return nil, err
+			}
+			if d, ok := discoverSMTPServer(); ok {
+				sender.Host = d.Host
+				if sender.Port == 0 {
+					sender.Port = d.Port
+				}
+				if sender.Encryption == "" {
+					sender.Encryption = d.Encryption
+				}
+			}
+			return sender, nil
+		},
+	})
+	RegisterTransport(Transport{
+		Name:       transportResend,
+		Configured: func() bool { return resendAPIKey != "" },
+		New: func() (Sender, error) {
+			return &ResendSender{APIKey: resendAPIKey, Unsafe: unsafe, ConnectTimeout: connectTimeout, SendTimeout: sendTimeout}, nil
+		},
+	})
+	RegisterTransport(Transport{
+		Name:       transportResendOAuth,
+		Configured: func() bool { return oauthResend || oauthName != "" },
+		New: func() (Sender, error) {
+			login, err := resendLogin()
+			if err != nil {
+				return nil, err
+			}
+			sender := &ResendSender{OAuthLogin: login, Unsafe: unsafe, ConnectTimeout: connectTimeout, SendTimeout: sendTimeout}
+			// Make sure there's a usable token now, even though it's
+			// fetched again when sending.
+			if _, err := sender.apiKey(); err != nil {
+				return nil, err
+			}
+			return sender, nil
+		},
+	})
+}
+
+// SMTP encryption modes.
 const (
-	gmailSuffix   = "@gmail.com"
-	gmailSMTPHost = "smtp.gmail.com"
-	gmailSMTPPort = 587
+	encryptionSTARTTLS = "starttls"
+	encryptionSSL      = "ssl"
+	encryptionNone     = "none"
 )
 
-func sendSMTPEmail(to, cc, bcc []string, from, subject, body string, plaintext bool, attachments []string) error {
-	server := mail.NewSMTPClient()
+// SMTPSender sends email through an SMTP server.
+type SMTPSender struct {
+	// Provider is the name of an SMTP preset. When empty, the preset is
+	// inferred from the username's domain.
+	Provider           string
+	Host               string
+	Port               int
+	Username           string
+	Password           string
+	Encryption         string // starttls, ssl, or none
+	InsecureSkipVerify bool
+	// Auth is the SASL mechanism to use: auto, plain, login, xoauth2 or
+	// oauthbearer. Auto uses the password if there is one.
+	Auth string
+	// TokenCmd is a command that prints an OAuth bearer token for the
+	// xoauth2 and oauthbearer mechanisms. When empty, the token comes from
+	// pop's token store.
+	TokenCmd string
+	// ConnectTimeout bounds connecting, negotiating TLS and
+	// authenticating. SendTimeout bounds sending the message after that.
+	// Zero means the default.
+	ConnectTimeout time.Duration
+	SendTimeout    time.Duration
 
-	var err error
-	server.Username = smtpUsername
-	server.Password = smtpPassword
-	server.Host = smtpHost
-	server.Port = smtpPort
+	// batch is the connection SendBatch keeps open for the next batch,
+	// until Close.
+	batch *smtpSession
+}
 
-	// Set defaults for gmail.
-	if strings.HasSuffix(server.Username, gmailSuffix) {
-		if server.Port == 0 {
-			server.Port = gmailSMTPPort
-		}
-		if server.Host == "" {
-			server.Host = gmailSMTPHost
+// preset returns the provider preset that applies to this sender, if any.
+// A preset is only inferred from the username when no host is set, so a
+// custom server never picks up another provider's port or encryption.
+func (s *SMTPSender) preset() (smtpPreset, bool) {
+	if s.Provider != "" {
+		p, err := lookupSMTPPreset(s.Provider)
+		return p, err == nil
+	}
+	if s.Host != "" {
+		return smtpPreset{}, false
+	}
+	return inferSMTPPreset(s.Username)
+}
+
+// addr returns the host and port to connect to, filling in whatever wasn't
+// set explicitly from the provider preset.
+func (s *SMTPSender) addr() (string, int) {
+	host, port := s.Host, s.Port
+	preset, ok := s.preset()
+	if host == "" && ok {
+		host = preset.Host
+	}
+	if port == 0 {
+		port = defaultSMTPPort
+		if ok {
+			port = preset.Port
 		}
 	}
+	return host, port
+}
 
-	switch strings.ToLower(smtpEncryption) {
-	case "ssl":
-		server.Encryption = mail.EncryptionSSLTLS
-	case "none":
-		server.Encryption = mail.EncryptionNone
-	default:
-		server.Encryption = mail.EncryptionSTARTTLS
+// insecureSkipVerify reports whether to skip TLS verification: when asked to,
+// or for a preset that needs it, as long as the host is the preset's own.
+func (s *SMTPSender) insecureSkipVerify() bool {
+	if s.InsecureSkipVerify {
+		return true
 	}
+	preset, ok := s.preset()
+	host, _ := s.addr()
+	return ok && preset.InsecureSkipVerify && host == preset.Host
+}
 
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-	server.TLSConfig = &tls.Config{
-		InsecureSkipVerify: smtpInsecureSkipVerify, //nolint:gosec
-		ServerName:         server.Host,
+// encryption returns the normalized encryption mode, falling back to the
+// provider preset and then to STARTTLS.
+func (s *SMTPSender) encryption() string {
+	encryption := s.Encryption
+	if preset, ok := s.preset(); encryption == "" && ok {
+		encryption = preset.Encryption
+	}
+	switch strings.ToLower(encryption) {
+	case encryptionSSL:
+		return encryptionSSL
+	case encryptionNone:
+		return encryptionNone
+	default:
+		return encryptionSTARTTLS
 	}
+}
 
-	smtpClient, err := server.Connect()
+// Send sends the message through the SMTP server.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) (*Receipt, error) {
+	email, err := buildSMTPMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.connect(ctx)
 	if err != nil {
-		return fmt.Errorf("connecting to SMTP server: %w", err)
+		return nil, err
 	}
+	defer session.close()
+	receipt, err := session.send(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	_ = session.client.Quit()
+	return receipt, nil
+}
 
+// SendBatch sends the messages one after the other over a single connection,
+// connecting again if the server drops it part way. The connection is kept
+// open for the next batch until Close is called. It isn't safe to call from
+// several goroutines at once.
+func (s *SMTPSender) SendBatch(ctx context.Context, msgs []Message) ([]*Receipt, []error) {
+	receipts := make([]*Receipt, len(msgs))
+	errs := make([]error, len(msgs))
+	session := s.batch
+	if session != nil && !session.resume(ctx) {
+		// The server hung up while pop waited for the batch.
+		session.close()
+		session = nil
+	}
+	defer func() { s.batch = session }()
+	var connectErr error
+	for i, msg := range msgs {
+		email, err := buildSMTPMessage(msg)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if connectErr != nil {
+			// Don't wait out the connect timeout again for every message.
+			errs[i] = connectErr
+			continue
+		}
+		if session == nil {
+			if session, connectErr = s.connect(ctx); connectErr != nil {
+				errs[i] = connectErr
+				continue
+			}
+		}
+		receipts[i], errs[i] = session.send(ctx, email)
+		if errs[i] == nil {
+			continue
+		}
+		var smtpErr *textproto.Error
+		if !errors.As(errs[i], &smtpErr) || session.client.Reset() != nil {
+			// The connection is gone: the next message gets a new one.
+			session.close()
+			session = nil
+		}
+	}
+	return receipts, errs
+}
+
+// Close ends the connection SendBatch kept open, if there is one.
+func (s *SMTPSender) Close() error {
+	if s.batch == nil {
+		return nil
+	}
+	_ = s.batch.conn.SetDeadline(time.Now().Add(s.batch.sendTimeout))
+	_ = s.batch.client.Quit()
+	s.batch.close()
+	s.batch = nil
+	return nil
+}
+
+// smtpMessage is a message ready to go out over SMTP.
+type smtpMessage struct {
+	from       string
+	recipients []string
+	data       string
+	messageID  string
+}
+
+// buildSMTPMessage renders msg as a MIME message.
+func buildSMTPMessage(msg Message) (*smtpMessage, error) {
+	messageID := newMessageID(msg.From)
 	email := mail.NewMSG()
-	email.SetFrom(from).
-		AddTo(to...).
-		AddCc(cc...).
-		AddBcc(bcc...).
-		SetSubject(subject)
+	email.SetFrom(msg.From).
+		AddTo(msg.To...).
+		AddCc(msg.Cc...).
+		AddBcc(msg.Bcc...).
+		SetSubject(msg.Subject).
+		AddHeader("Message-ID", messageID)
+	for _, name := range slices.Sorted(maps.Keys(msg.Headers)) {
+		email.AddHeader(name, msg.Headers[name])
+	}
 
 	html := bytes.NewBufferString("")
-	convertErr := goldmark.Convert([]byte(body), html)
+	convertErr := goldmark.Convert([]byte(msg.Body), html)
 
-	if (plaintext) || (convertErr != nil) {
-		email.SetBody(mail.TextPlain, body)
+	if (msg.Plaintext) || (convertErr != nil) {
+		email.SetBody(mail.TextPlain, msg.Body)
 	} else {
 		email.SetBody(mail.TextHTML, html.String())
 	}
 
-	for _, a := range attachments {
+	for _, a := range msg.Attachments {
 		email.Attach(&mail.File{
 			FilePath: a,
 			Name:     filepath.Base(a),
 		})
 	}
+	if email.Error != nil {
+		return nil, fmt.Errorf("building email: %w", email.Error)
+	}
+	recipients := email.GetRecipients()
+	if len(recipients) == 0 {
+		return nil, errors.New("sending email: no recipients")
+	}
+	return &smtpMessage{
+		from:       email.GetFrom(),
+		recipients: recipients,
+		data:       email.GetMessage(),
+		messageID:  messageID,
+	}, nil
+}
 
-	if err := email.Send(smtpClient); err != nil {
-		return fmt.Errorf("sending email: %w", err)
+// smtpSession is a connection to the SMTP server, ready to send messages.
+type smtpSession struct {
+	client      *smtp.Client
+	conn        net.Conn
+	server      string
+	sendTimeout time.Duration
+	// stop stops canceling ctx from cutting the connection short.
+	stop func() bool
+}
+
+// connect connects to the SMTP server. Canceling ctx cuts the connection
+// short, whatever it's waiting for, until the session is closed.
+func (s *SMTPSender) connect(ctx context.Context) (*smtpSession, error) {
+	connectCtx, cancel := context.WithTimeout(ctx, timeoutOr(s.ConnectTimeout, defaultConnectTimeout))
+	defer cancel()
+	client, conn, err := s.dial(connectCtx)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SMTP server: %w", sendInterrupted(ctx, err, false))
 	}
-	return nil
+	host, port := s.addr()
+	return &smtpSession{
+		client:      client,
+		conn:        conn,
+		server:      net.JoinHostPort(host, strconv.Itoa(port)),
+		sendTimeout: timeoutOr(s.SendTimeout, defaultSendTimeout),
+		stop:        context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) }),
+	}, nil
+}
+
+// resume gets a session left open by an earlier batch ready to send again,
+// canceled by ctx from now on. It reports whether the server is still there.
+func (c *smtpSession) resume(ctx context.Context) bool {
+	_ = c.conn.SetDeadline(time.Now().Add(c.sendTimeout))
+	c.stop()
+	c.stop = context.AfterFunc(ctx, func() { _ = c.conn.SetDeadline(time.Unix(1, 0)) })
+	return ctx.Err() == nil && c.client.Noop() == nil
 }
 
-func sendResendEmail(to, cc, bcc []string, from, subject, body string, plaintext bool, attachments []string) error {
-	client := resend.NewClient(resendAPIKey)
+func (c *smtpSession) close() {
+	c.stop()
+	_ = c.client.Close()
+}
 
+// send sends one message. After an error that isn't an SMTP reply, the
+// connection can't be used again.
+func (c *smtpSession) send(ctx context.Context, email *smtpMessage) (*Receipt, error) {
+	receipt := &Receipt{
+		Provider:  transportSMTP,
+		Server:    c.server,
+		MessageID: email.messageID,
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(c.sendTimeout))
+	if ctx.Err() != nil {
+		// The deadline above replaced the one that cut it short.
+		return nil, fmt.Errorf("sending email: %w", sendInterrupted(ctx, ctx.Err(), false))
+	}
+	if err := c.client.Mail(email.from); err != nil {
+		return nil, fmt.Errorf("sending email: %w", sendInterrupted(ctx, err, false))
+	}
+	// Send to the recipients the server takes, and report the others,
+	// rather than give up on everyone over one bad address.
+	var rcptErr error
+	for _, rcpt := range email.recipients {
+		if err := c.client.Rcpt(rcpt); err != nil {
+			var smtpErr *textproto.Error
+			if !errors.As(err, &smtpErr) {
+				// The connection failed, not the recipient.
+				return nil, fmt.Errorf("sending email: %w", sendInterrupted(ctx, err, false))
+			}
+			receipt.Rejected = append(receipt.Rejected, RejectedRecipient{Address: rcpt, Error: err.Error()})
+			if rcptErr == nil {
+				rcptErr = err
+			}
+			continue
+		}
+		receipt.Accepted = append(receipt.Accepted, rcpt)
+	}
+	if len(receipt.Accepted) == 0 {
+		return nil, fmt.Errorf("sending email to %s: %w", receipt.Rejected[0].Address, rcptErr)
+	}
+	reply, handedOver, err := smtpData(c.client, email.data)
+	if err != nil {
+		return nil, fmt.Errorf("sending email: %w", sendInterrupted(ctx, err, handedOver))
+	}
+	receipt.Response = reply
+	receipt.ID = smtpQueueID(reply)
+	receipt.SentAt = time.Now().UTC()
+	return receipt, nil
+}
+
+// smtpData sends the message with DATA and returns the server's final reply.
+// Unlike smtp.Client.Data it keeps the reply, which usually carries the
+// server's queue ID, e.g. "2.0.0 Ok: queued as 4F3A21C0B2". handedOver
+// reports whether the end of the message may have reached the server, after
+// which the server may deliver it even if its reply never arrives.
+func smtpData(client *smtp.Client, message string) (reply string, handedOver bool, err error) {
+	id, err := client.Text.Cmd("DATA")
+	if err != nil {
+		return "", false, err //nolint:wrapcheck
+	}
+	client.Text.StartResponse(id)
+	_, _, err = client.Text.ReadResponse(354) //nolint:mnd
+	client.Text.EndResponse(id)
+	if err != nil {
+		return "", false, err //nolint:wrapcheck
+	}
+	w := client.Text.DotWriter()
+	if _, err := io.WriteString(w, message); err != nil {
+		return "", false, err //nolint:wrapcheck
+	}
+	if err := w.Close(); err != nil {
+		return "", true, err //nolint:wrapcheck
+	}
+	_, reply, err = client.Text.ReadResponse(250) //nolint:mnd
+	if err != nil {
+		return "", true, err //nolint:wrapcheck
+	}
+	return reply, true, nil
+}
+
+// smtpQueueIDPatterns match the queue ID in the final replies of common SMTP
+// servers: Postfix and most others, Gmail, Sendmail, and Exchange.
+var smtpQueueIDPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)queued as\s+<?([^\s>]+)`),
+	regexp.MustCompile(`(?i)^(?:\d\.\d\.\d\s+)?OK\s+(?:\d+\s+)?(\S+)\s+-\s+gsmtp`),
+	regexp.MustCompile(`(?i)^(?:\d\.\d\.\d\s+)?(\S+)\s+Message accepted for delivery`),
+	regexp.MustCompile(`(?i)^(?:\d\.\d\.\d\s+)?<([^\s>]+)>\s+\[[^\]]*Hostname=`),
+}
+
+// smtpQueueID extracts the server's queue ID from its final reply, or returns
+// an empty string if it doesn't recognize the reply.
+func smtpQueueID(reply string) string {
+	for _, re := range smtpQueueIDPatterns {
+		if m := re.FindStringSubmatch(reply); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// newMessageID returns a new, unique Message-ID header value on the sender's
+// domain.
+func newMessageID(from string) string {
+	domain := addressDomain(from)
+	if domain == "" {
+		domain = "localhost"
+	}
+	b := make([]byte, 16) //nolint:mnd
+	_, _ = rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// dial connects to the SMTP server, negotiates TLS and authenticates. The
+// returned connection is the one underlying the client, so callers can set
+// deadlines on it.
+func (s *SMTPSender) dial(ctx context.Context) (*smtp.Client, net.Conn, error) {
+	host, port := s.addr()
+	if host == "" {
+		return nil, nil, errors.New("no SMTP host configured")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, nil, err //nolint:wrapcheck
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: s.insecureSkipVerify(), //nolint:gosec
+		ServerName:         host,
+	}
+	encryption := s.encryption()
+	if encryption == encryptionSSL {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, nil, fmt.Errorf("TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err //nolint:wrapcheck
+	}
+	if err := client.Hello("localhost"); err != nil {
+		_ = client.Close()
+		return nil, nil, err //nolint:wrapcheck
+	}
+	if encryption == encryptionSTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			_ = client.Close()
+			return nil, nil, errors.New("server doesn't support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, nil, fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+
+	if s.Username != "" {
+		_, mechanisms := client.Extension("AUTH")
+		auth, err := s.auth(mechanisms)
+		if err != nil {
+			_ = client.Close()
+			return nil, nil, err
+		}
+		if err := client.Auth(auth); err != nil {
+			_ = client.Close()
+			return nil, nil, fmt.Errorf("authenticating: %w", err)
+		}
+	}
+	return client, conn, nil
+}
+
+// resendLogin returns the login key of the Resend OAuth login selected with
+// --oauth.name, or of the default login.
+func resendLogin() (string, error) {
+	if err := validateLoginName(oauthName); err != nil {
+		return "", err
+	}
+	return loginKey(providerResend, oauthName), nil
+}
+
+// ResendSender sends email through the Resend API.
+type ResendSender struct {
+	// APIKey is either a Resend API key or an OAuth access token.
+	APIKey string
+	// OAuthLogin is the login key of the stored OAuth token used instead
+	// of APIKey. The token is fetched, and refreshed if need be, for every
+	// send, so a long-running TUI never sends with a stale one.
+	OAuthLogin string
+	// Unsafe allows raw HTML and extra Markdown features in the body.
+	Unsafe bool
+	// ConnectTimeout bounds connecting to Resend, and SendTimeout the
+	// whole send. Zero means the default.
+	ConnectTimeout time.Duration
+	SendTimeout    time.Duration
+}
+
+// apiKey returns the API key or OAuth access token to send with.
+func (s *ResendSender) apiKey() (string, error) {
+	if s.OAuthLogin == "" {
+		return s.APIKey, nil
+	}
+	token, err := getValidAccessToken(s.OAuthLogin)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", &reauthError{login: s.OAuthLogin, err: errNoOAuthToken}
+	}
+	return token, nil
+}
+
+// tokenLogin returns the login key of the stored OAuth token the sender
+// uses, if any.
+func (s *ResendSender) tokenLogin() string {
+	return s.OAuthLogin
+}
+
+// Send sends the message through Resend.
+func (s *ResendSender) Send(ctx context.Context, msg Message) (*Receipt, error) {
+	apiKey, err := s.apiKey()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeoutOr(s.SendTimeout, defaultSendTimeout))
+	defer cancel()
+	if err := s.checkFrom(ctx, msg.From); err != nil {
+		return nil, err
+	}
+
+	var scheduledAt time.Time
+	if msg.SendAt.After(time.Now()) {
+		scheduledAt = msg.SendAt.UTC()
+	}
+	body := struct {
+		*resend.SendEmailRequest
+		ScheduledAt string `json:"scheduled_at,omitempty"`
+	}{SendEmailRequest: s.request(msg)}
+	if !scheduledAt.IsZero() {
+		body.ScheduledAt = scheduledAt.Format(time.RFC3339)
+	}
+	var resp resend.SendEmailResponse
+	if err := s.post(ctx, apiKey, "/emails", body, &resp); err != nil {
+		return nil, fmt.Errorf("sending email via Resend: %w", err)
+	}
+	receipt := resendReceipt(msg, resp.Id)
+	receipt.ScheduledAt = scheduledAt
+	return receipt, nil
+}
+
+// resendBatchSize is the most emails Resend takes in one batch.
+const resendBatchSize = 100
+
+// SendBatch sends the messages with Resend's batch endpoint, up to 100 in a
+// request. The endpoint takes neither attachments nor a time to send at, so
+// messages with either are sent one by one.
+func (s *ResendSender) SendBatch(ctx context.Context, msgs []Message) ([]*Receipt, []error) {
+	receipts := make([]*Receipt, len(msgs))
+	errs := make([]error, len(msgs))
+	var batch []int
+	for i, msg := range msgs {
+		if len(msg.Attachments) > 0 || msg.SendAt.After(time.Now()) {
+			receipts[i], errs[i] = s.Send(ctx, msg)
+			continue
+		}
+		batch = append(batch, i)
+	}
+	for chunk := range slices.Chunk(batch, resendBatchSize) {
+		s.sendChunk(ctx, msgs, chunk, receipts, errs)
+	}
+	return receipts, errs
+}
+
+// sendChunk sends the messages at the given indexes in one batch request,
+// filling in their receipts or errors.
+func (s *ResendSender) sendChunk(ctx context.Context, msgs []Message, chunk []int, receipts []*Receipt, errs []error) {
+	apiKey, err := s.apiKey()
+	if err != nil {
+		for _, i := range chunk {
+			errs[i] = err
+		}
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeoutOr(s.SendTimeout, defaultSendTimeout))
+	defer cancel()
+
+	var (
+		requests []*resend.SendEmailRequest
+		sent     []int
+		checked  = map[string]error{}
+	)
+	for _, i := range chunk {
+		from := msgs[i].From
+		if _, ok := checked[from]; !ok {
+			checked[from] = s.checkFrom(ctx, from)
+		}
+		if errs[i] = checked[from]; errs[i] != nil {
+			continue
+		}
+		requests = append(requests, s.request(msgs[i]))
+		sent = append(sent, i)
+	}
+	if len(requests) == 0 {
+		return
+	}
+	var resp struct {
+		Data []resend.SendEmailResponse `json:"data"`
+	}
+	err = s.post(ctx, apiKey, "/emails/batch", requests, &resp)
+	// Resend took the emails either way, but if it didn't return an ID
+	// for each, there's no telling which is which.
+	ids := len(resp.Data) == len(requests)
+	if err == nil && !ids {
+		logVerbose("Got %d IDs from Resend for %d emails; recording them without IDs", len(resp.Data), len(requests))
+	}
+	for n, i := range sent {
+		switch {
+		case err != nil:
+			errs[i] = fmt.Errorf("sending email via Resend: %w", err)
+		case ids:
+			receipts[i] = resendReceipt(msgs[i], resp.Data[n].Id)
+		default:
+			receipts[i] = resendReceipt(msgs[i], "")
+		}
+	}
+}
+
+// request returns the Resend request for the message, with the body
+// rendered from Markdown unless it's plain text.
+func (s *ResendSender) request(msg Message) *resend.SendEmailRequest {
 	html := bytes.NewBufferString("")
 	// If the conversion fails or plaintext is requested,
 	// we'll simply send the plain-text body.
-	if !plaintext {
-		if unsafe {
+	if !msg.Plaintext {
+		if s.Unsafe {
 			markdown := goldmark.New(
 				goldmark.WithRendererOptions(
 					renderer.WithUnsafe(),
@@ -153,31 +797,98 @@ func sendResendEmail(to, cc, bcc []string, from, subject, body string, plaintext
 					extension.Linkify,
 				),
 			)
-			_ = markdown.Convert([]byte(body), html)
+			_ = markdown.Convert([]byte(msg.Body), html)
 		} else {
-			_ = goldmark.Convert([]byte(body), html)
+			_ = goldmark.Convert([]byte(msg.Body), html)
 		}
 	}
 
-	request := &resend.SendEmailRequest{
-		From:        from,
-		To:          to,
-		Subject:     subject,
-		Cc:          cc,
-		Bcc:         bcc,
+	return &resend.SendEmailRequest{
+		From:        msg.From,
+		To:          msg.To,
+		Subject:     msg.Subject,
+		Cc:          msg.Cc,
+		Bcc:         msg.Bcc,
 		Html:        html.String(),
-		Text:        body,
-		Attachments: makeAttachments(attachments),
+		Text:        msg.Body,
+		Attachments: makeAttachments(msg.Attachments),
+		Headers:     msg.Headers,
+	}
+}
+
+// resendReceipt returns the receipt for a message Resend accepted with the
+// given ID.
+func resendReceipt(msg Message, id string) *Receipt {
+	// Resend sets the Message-ID header itself, and doesn't say what it is.
+	return &Receipt{
+		Provider: transportResend,
+		ID:       id,
+		SentAt:   time.Now().UTC(),
+		Accepted: slices.DeleteFunc(slices.Concat(msg.To, msg.Cc, msg.Bcc), func(a string) bool {
+			return strings.TrimSpace(a) == ""
+		}),
 	}
+}
 
-	_, err := client.Emails.Send(request)
+// post posts body to the Resend API as JSON and decodes the response into
+// out. resend-go takes no context, and has neither scheduled_at nor the
+// batch endpoint, so requests are posted here. Error responses are returned
+// as an *HTTPError, so a 429 or 5xx can be retried.
+func (s *ResendSender) post(ctx context.Context, apiKey, path string, body, out any) error {
+	payload, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("sending email via Resend: %w", err)
+		return fmt.Errorf("encoding email: %w", err)
 	}
+	// Once the request is written, Resend may send the email even if its
+	// response never arrives.
+	var wrote atomic.Bool
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) { wrote.Store(info.Err == nil) },
+	})
+	base := strings.TrimSuffix(resendBaseURL(), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("creating email request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
 
+	httpResp, err := s.httpClient().Do(req)
+	if err != nil {
+		return sendInterrupted(ctx, err, wrote.Load())
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+	if httpResp.StatusCode >= http.StatusBadRequest {
+		respBody, _ := io.ReadAll(httpResp.Body)
+		httpErr := &HTTPError{
+			Status:     httpResp.Status,
+			Body:       strings.TrimSpace(string(respBody)),
+			RetryAfter: parseRetryAfter(httpResp.Header.Get("Retry-After")),
+		}
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Message != "" {
+			httpErr.Body = apiErr.Message
+		}
+		return httpErr
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(out); err != nil {
+		return sendInterrupted(ctx, fmt.Errorf("parsing response: %w", err), true)
+	}
 	return nil
 }
 
+// httpClient returns the client to call Resend with, which gives up on
+// connecting after the connect timeout.
+func (s *ResendSender) httpClient() *http.Client {
+	timeout := timeoutOr(s.ConnectTimeout, defaultConnectTimeout)
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+	transport.DialContext = (&net.Dialer{Timeout: timeout}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	return &http.Client{Transport: transport}
+}
+
 func makeAttachments(paths []string) []resend.Attachment {
 	if len(paths) == 0 {
 		return nil
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSMTPSendBatchReusesConnection(t *testing.T) {
+	stub := startSMTPStub(t, &smtpStub{})
+	sender := stub.sender()
+	batch := func(n int) []Message {
+		msgs := make([]Message, n)
+		for i := range msgs {
+			msgs[i] = Message{From: "me@example.com", To: []string{"you@example.com"}, Subject: fmt.Sprint("Hi ", i), Body: "Hello"}
+		}
+		return msgs
+	}
+	send := func(msgs []Message) {
+		t.Helper()
+		receipts, errs := sender.SendBatch(t.Context(), msgs)
+		for i, err := range errs {
+			if err != nil || receipts[i] == nil {
+				t.Fatalf("message %d: receipt %v, error %v", i, receipts[i], err)
+			}
+		}
+	}
+
+	send(batch(3))
+	send(batch(2))
+	if conns, messages := stub.counts(); conns != 1 || messages != 5 {
+		t.Errorf("sent %d messages over %d connections, want 5 over 1", messages, conns)
+	}
+	if err := sender.Close(); err != nil {
+		t.Fatal(err)
+	}
+	send(batch(1))
+	_ = sender.Close()
+	if conns, messages := stub.counts(); conns != 2 || messages != 6 {
+		t.Errorf("after closing, sent %d messages over %d connections, want 6 over 2", messages, conns)
+	}
+}
+
+// resendBatchStandIn is a stand-in for Resend's send endpoints that gives
+// each email the ID "em_" followed by its subject.
+type resendBatchStandIn struct {
+	// short makes batch responses leave out the last ID.
+	short bool
+
+	mu sync.Mutex
+	// batches are the sizes of the batch requests, and singles the
+	// subjects of the emails sent one by one.
+	batches []int
+	singles []string
+}
+
+func (s *resendBatchStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type email struct {
+		Subject     string            `json:"subject"`
+		Attachments []json.RawMessage `json:"attachments"`
+		ScheduledAt string            `json:"scheduled_at"`
+	}
+	type id struct {
+		ID string `json:"id"`
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/emails/batch":
+		var emails []email
+		if err := json.NewDecoder(r.Body).Decode(&emails); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.batches = append(s.batches, len(emails))
+		ids := make([]id, 0, len(emails))
+		for _, e := range emails {
+			if len(e.Attachments) > 0 || e.ScheduledAt != "" {
+				http.Error(w, `{"message":"attachments and scheduled_at aren't supported in batches"}`, http.StatusUnprocessableEntity)
+				return
+			}
+			ids = append(ids, id{"em_" + e.Subject})
+		}
+		if s.short {
+			ids = ids[:len(ids)-1]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": ids})
+	case "/emails":
+		var e email
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.singles = append(s.singles, e.Subject)
+		_ = json.NewEncoder(w).Encode(id{"em_" + e.Subject})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestResendSendBatch(t *testing.T) {
+	attachment := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(attachment, []byte("notes"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]Message, 2*resendBatchSize+5)
+	for i := range msgs {
+		// resend.dev needs no domain check.
+		msgs[i] = Message{From: "me@resend.dev", To: []string{"you@example.com"}, Subject: fmt.Sprint(i), Body: "Hello"}
+	}
+	msgs[3].Attachments = []string{attachment}
+	msgs[7].SendAt = time.Now().Add(time.Hour)
+
+	t.Run("chunks", func(t *testing.T) {
+		standIn := &resendBatchStandIn{}
+		srv := httptest.NewServer(standIn)
+		t.Cleanup(srv.Close)
+		t.Setenv("RESEND_BASE_URL", srv.URL)
+
+		receipts, errs := (&ResendSender{APIKey: "re_test"}).SendBatch(t.Context(), msgs)
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("message %d: %v", i, err)
+			}
+			if want := "em_" + msgs[i].Subject; receipts[i] == nil || receipts[i].ID != want {
+				t.Errorf("message %d: receipt %+v, want ID %s", i, receipts[i], want)
+			}
+		}
+		if want := []int{resendBatchSize, resendBatchSize, 3}; !slices.Equal(standIn.batches, want) {
+			t.Errorf("batch sizes = %v, want %v", standIn.batches, want)
+		}
+		if want := []string{"3", "7"}; !slices.Equal(standIn.singles, want) {
+			t.Errorf("sent %v one by one, want %v", standIn.singles, want)
+		}
+	})
+
+	t.Run("fewer IDs than emails", func(t *testing.T) {
+		srv := httptest.NewServer(&resendBatchStandIn{short: true})
+		t.Cleanup(srv.Close)
+		t.Setenv("RESEND_BASE_URL", srv.URL)
+
+		batch := msgs[10:13]
+		receipts, errs := (&ResendSender{APIKey: "re_test"}).SendBatch(t.Context(), batch)
+		for i, err := range errs {
+			// Resend took them, so they mustn't look unsent.
+			if err != nil || receipts[i] == nil {
+				t.Fatalf("message %d: receipt %v, error %v; want it recorded as sent", i, receipts[i], err)
+			}
+			if receipts[i].ID != "" {
+				t.Errorf("message %d: ID %q, want none, since the IDs can't be matched up", i, receipts[i].ID)
+			}
+		}
+	})
+}
+
+func TestSMTPQueueID(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+	}{
+		{"Postfix", "2.0.0 Ok: queued as ABC123", "ABC123"},
+		{"Postfix without a status code", "Ok: queued as 4F3A21C0B2", "4F3A21C0B2"},
+		{"queued as in brackets", "2.0.0 Queued as <9c2f1e@mx.example.com>", "9c2f1e@mx.example.com"},
+		{"Gmail", "2.0.0 OK 1234 - gsmtp", "1234"},
+		{"Gmail with a timestamp", "2.0.0 OK  1697556163 a11-20020a170906d20b00b009b2f1.5 - gsmtp", "a11-20020a170906d20b00b009b2f1.5"},
+		{"Sendmail", "2.0.0 39EAbcDE012345 Message accepted for delivery", "39EAbcDE012345"},
+		{
+			"Exchange",
+			"2.6.0 <CAF1234abcd@mail.example.com> [InternalId=1234567, Hostname=AM0PR01MB1234.eurprd01.prod.exchangelabs.com] 1337 bytes in 0.045, 28.9 KB/sec Queued mail for delivery",
+			"CAF1234abcd@mail.example.com",
+		},
+		{"no ID", "2.0.0 Ok", ""},
+		{"no ID, with text", "250 2.0.0 Message received", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		if got := smtpQueueID(tt.reply); got != tt.want {
+			t.Errorf("%s: smtpQueueID(%q) = %q, want %q", tt.name, tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestPrintReceiptJSON(t *testing.T) {
+	old := jsonOutput
+	t.Cleanup(func() { jsonOutput = old })
+	jsonOutput = true
+
+	tests := []struct {
+		name    string
+		receipt *Receipt
+		want    map[string]any
+	}{
+		{
+			name: "SMTP",
+			receipt: &Receipt{
+				Provider:  transportSMTP,
+				Server:    "smtp.example.com:587",
+				ID:        "ABC123",
+				MessageID: "<1f2e@example.com>",
+				Response:  "2.0.0 Ok: queued as ABC123",
+				SentAt:    time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC),
+				Accepted:  []string{"ada@example.com"},
+				Rejected:  []RejectedRecipient{{Address: "bob@example.com", Error: "550 5.1.1 No such user"}},
+			},
+			want: map[string]any{
+				"provider":   "smtp",
+				"server":     "smtp.example.com:587",
+				"id":         "ABC123",
+				"message_id": "<1f2e@example.com>",
+				"response":   "2.0.0 Ok: queued as ABC123",
+				"sent_at":    "2026-10-14T08:30:00Z",
+				"accepted":   []any{"ada@example.com"},
+				"rejected":   []any{map[string]any{"address": "bob@example.com", "error": "550 5.1.1 No such user"}},
+			},
+		},
+		{
+			name: "scheduled with Resend",
+			receipt: &Receipt{
+				Provider:    transportResend,
+				ID:          "em_1",
+				SentAt:      time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC),
+				ScheduledAt: time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC),
+				Accepted:    []string{"ada@example.com", "bob@example.com"},
+			},
+			want: map[string]any{
+				"provider":     "resend",
+				"id":           "em_1",
+				"sent_at":      "2026-10-14T08:30:00Z",
+				"scheduled_at": "2026-10-15T09:00:00Z",
+				"accepted":     []any{"ada@example.com", "bob@example.com"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			out := captureOutput(t, &os.Stdout, func() {
+				err = printReceipt("Hi", tt.receipt)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatalf("printReceipt printed %q, not JSON: %v", out, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("printReceipt printed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/spf13/cobra"
+)
+
+// PopWebhookSecret is the environment variable holding the signing secret of
+// the Resend webhook, e.g. "whsec_...".
+const PopWebhookSecret = "POP_WEBHOOK_SECRET" //nolint:gosec
+
+// webhookTolerance is how far a webhook's timestamp may be from now before
+// it's rejected as a replay.
+const webhookTolerance = 5 * time.Minute
+
+// webhookMaxBody is the largest webhook body accepted.
+const webhookMaxBody = 1 << 20
+
+var eventsOpts struct {
+	secret string
+	addr   string
+	path   string
+	out    string
+	url    string
+}
+
+// EventsCmd is the parent command for Resend webhook events.
+var EventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Receive Resend webhook events",
+	Long: `Receive the events Resend reports about sent emails (delivered, bounced,
+complained, opened and so on) through a webhook.
+
+Add a webhook in Resend pointing at wherever pop events listen is reachable,
+and set its signing secret in $` + PopWebhookSecret + ` or webhook_secret_cmd in the
+config file.`,
+}
+
+// EventsListenCmd is the cobra command that serves the webhook endpoint.
+var EventsListenCmd = &cobra.Command{
+	Use:   "listen",
+	Short: "Serve an endpoint for Resend webhooks",
+	Long: `Serves an HTTP endpoint for Resend webhooks. Each event's signature is
+checked against the signing secret, then the delivery status in the sent
+history is updated, for permanent bounces and complaints the recipient is
+added to the suppression list, and the event is printed as a line of JSON (or
+appended to --out). If the event can't be recorded, pop replies with an error
+so Resend delivers it again later.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		key, err := webhookKey()
+		if err != nil {
+			return err
+		}
+		out := io.Writer(os.Stdout)
+		if eventsOpts.out != "" {
+			f, err := os.OpenFile(eventsOpts.out, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600) //nolint:gosec // G304: the user picked the file
+			if err != nil {
+				return fmt.Errorf("opening %s: %w", eventsOpts.out, err)
+			}
+			defer func() { _ = f.Close() }()
+			out = f
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		mux := http.NewServeMux()
+		mux.Handle(eventsOpts.path, &webhookHandler{key: key, out: out, seen: map[string]time.Time{}})
+		srv := &http.Server{
+			Addr:              eventsOpts.addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(shutdownCtx)
+		}()
+
+		w := colorprofile.NewWriter(os.Stderr, os.Environ())
+		_, _ = fmt.Fprintln(w, commentStyle.Render("Listening for Resend webhooks on "+eventsOpts.addr+eventsOpts.path))
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("serving webhooks: %w", err)
+		}
+		return nil
+	},
+}
+
+// EventsPostCmd is the cobra command that signs an event and posts it to a
+// listener, for trying it out without Resend.
+var EventsPostCmd = &cobra.Command{
+	Use:   "post [file]",
+	Short: "Sign an event and post it to pop events listen",
+	Long: `Signs a webhook event, read from the file or stdin, with the signing secret
+and posts it to a listener, the way Resend would. Use it to check a listener,
+or to feed it events in tests.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		key, err := webhookKey()
+		if err != nil {
+			return err
+		}
+		var body []byte
+		if len(args) > 0 {
+			body, err = os.ReadFile(args[0])
+		} else {
+			body, err = io.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			return fmt.Errorf("reading event: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(cmd.Context(), http.MethodPost, eventsOpts.url, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		id := make([]byte, 12) //nolint:mnd
+		_, _ = rand.Read(id)
+		msgID := "msg_" + hex.EncodeToString(id)
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("svix-id", msgID)
+		req.Header.Set("svix-timestamp", timestamp)
+		req.Header.Set("svix-signature", "v1,"+signWebhook(key, msgID, timestamp, body))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("posting event: %w", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		respBody, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode/100 != 2 { //nolint:mnd
+			return fmt.Errorf("posting event: %w", &HTTPError{Status: resp.Status, Body: strings.TrimSpace(string(respBody))})
+		}
+		fmt.Println(resp.Status)
+		return nil
+	},
+}
+
+// webhookKey returns the HMAC key of the configured signing secret.
+func webhookKey() ([]byte, error) {
+	secret := eventsOpts.secret
+	if secret == "" {
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.WebhookSecretCmd != "" {
+			if secret, err = runSecretCmd(cfg.WebhookSecretCmd); err != nil {
+				return nil, fmt.Errorf("getting webhook signing secret: %w", err)
+			}
+		}
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("no webhook signing secret: set $%s, webhook_secret_cmd or --secret", PopWebhookSecret)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return nil, errors.New("the webhook signing secret should look like whsec_ followed by base64")
+	}
+	return key, nil
+}
+
+// signWebhook returns the base64 HMAC-SHA256 signature of a Svix webhook.
+func signWebhook(key []byte, id, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// errBadSignature is returned for webhooks that weren't signed with the
+// signing secret.
+var errBadSignature = errors.New("no valid signature")
+
+// verifyWebhook checks the Svix signature headers of a webhook: that one of
+// the signatures matches and that the timestamp is recent.
+func verifyWebhook(key []byte, h http.Header, body []byte, now time.Time) error {
+	id, timestamp, signatures := h.Get("svix-id"), h.Get("svix-timestamp"), h.Get("svix-signature")
+	if id == "" || timestamp == "" || signatures == "" {
+		return errors.New("missing svix-id, svix-timestamp or svix-signature header")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad svix-timestamp %q", timestamp)
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > webhookTolerance || d < -webhookTolerance {
+		return errors.New("timestamp too far from now")
+	}
+	expected := signWebhook(key, id, timestamp, body)
+	for sig := range strings.FieldsSeq(signatures) {
+		version, sig, ok := strings.Cut(sig, ",")
+		if ok && version == "v1" && hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return errBadSignature
+}
+
+// webhookEvent is the part of a Resend webhook event pop acts on.
+type webhookEvent struct {
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      struct {
+		EmailID string   `json:"email_id"`
+		To      []string `json:"to"`
+		Bounce  *struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"bounce"`
+	} `json:"data"`
+}
+
+// webhookHandler receives Resend webhooks.
+type webhookHandler struct {
+	key []byte
+
+	mu  sync.Mutex
+	out io.Writer
+	// seen holds the IDs of the events handled lately, with their
+	// timestamps, since Resend delivers an event again if it isn't sure it
+	// got through. IDs are forgotten once their timestamps are too old to
+	// be accepted.
+	seen map[string]time.Time
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
+	if err != nil {
+		http.Error(w, "reading body", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if err := verifyWebhook(h.key, r.Header, body, now); err != nil {
+		logVerbose("Rejected webhook from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var event webhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "parsing event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var line bytes.Buffer
+	if err := json.Compact(&line, body); err != nil {
+		http.Error(w, "parsing event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	line.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, at := range h.seen {
+		if now.Sub(at) > webhookTolerance {
+			delete(h.seen, id)
+		}
+	}
+	id := r.Header.Get("svix-id")
+	if _, ok := h.seen[id]; ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := applyWebhookEvent(&event); err != nil {
+		// Resend delivers the event again later, so a bounce isn't lost
+		// from the suppression list.
+		errWriter := colorprofile.NewWriter(os.Stderr, os.Environ())
+		_, _ = fmt.Fprintln(errWriter, errorStyle.Render("Couldn't record "+event.Type+" for "+event.Data.EmailID+": "+err.Error()))
+		http.Error(w, "recording event", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.out.Write(line.Bytes()); err != nil {
+		http.Error(w, "writing event", http.StatusInternalServerError)
+		return
+	}
+	ts, _ := strconv.ParseInt(r.Header.Get("svix-timestamp"), 10, 64)
+	h.seen[id] = time.Unix(ts, 0)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyWebhookEvent records the event in the sent history and, for permanent
+// bounces and complaints, adds the recipients to the suppression list.
+func applyWebhookEvent(event *webhookEvent) error {
+	status, ok := strings.CutPrefix(event.Type, "email.")
+	if !ok || event.Data.EmailID == "" {
+		return nil
+	}
+
+	var reason, detail string
+	switch {
+	case status == "complained":
+		reason = status
+	case status == "bounced" && (event.Data.Bounce == nil || !strings.EqualFold(event.Data.Bounce.Type, "transient")):
+		reason = status
+		if event.Data.Bounce != nil {
+			detail = event.Data.Bounce.Message
+		}
+	}
+	if reason != "" && len(event.Data.To) > 0 {
+		err := updateSuppressions(func(suppressions map[string]Suppression) {
+			for _, a := range event.Data.To {
+				suppressions[suppressionKey(a)] = Suppression{
+					Address: suppressionKey(a),
+					Reason:  reason,
+					Detail:  detail,
+					EmailID: event.Data.EmailID,
+					AddedAt: time.Now().UTC(),
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !historyEnabled() {
+		return nil
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+	e, err := findHistoryEntryByReceipt(event.Data.EmailID)
+	if errors.Is(err, errNotInHistory) {
+		// Not sent by pop, or not from this machine.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if event.CreatedAt.Before(e.DeliveryUpdatedAt) {
+		// A later event, or a status check, already got here.
+		return nil
+	}
+	e.Delivery = status
+	e.DeliveryUpdatedAt = event.CreatedAt
+	return saveHistoryEntry(e)
+}
+
+func init() {
+	EventsCmd.AddCommand(EventsListenCmd)
+	EventsCmd.AddCommand(EventsPostCmd)
+	envSecret := os.Getenv(PopWebhookSecret)
+	EventsCmd.PersistentFlags().StringVar(&eventsOpts.secret, "secret", envSecret, "Signing secret of the Resend webhook"+commentStyle.Render("($"+PopWebhookSecret+")"))
+	EventsListenCmd.Flags().StringVar(&eventsOpts.addr, "addr", ":8787", "Address to listen on")
+	EventsListenCmd.Flags().StringVar(&eventsOpts.path, "path", "/", "Path to serve the webhook endpoint on")
+	EventsListenCmd.Flags().StringVar(&eventsOpts.out, "out", "", "Append events to this JSONL file instead of printing them")
+	EventsPostCmd.Flags().StringVar(&eventsOpts.url, "url", "http://localhost:8787/", "URL of the listener")
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWebhookKey is the key of the signing secret whsec_dGVzdC1zZWNyZXQ=.
+var testWebhookKey = []byte("test-secret")
+
+// readWebhookFixture returns a Resend event from testdata/webhooks.
+func readWebhookFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", "webhooks", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// signedWebhookHeader returns the Svix headers of an event signed with key at
+// the given time.
+func signedWebhookHeader(key []byte, id string, at time.Time, body []byte) http.Header {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	h := http.Header{}
+	h.Set("svix-id", id)
+	h.Set("svix-timestamp", timestamp)
+	h.Set("svix-signature", "v1,"+signWebhook(key, id, timestamp, body))
+	return h
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := readWebhookFixture(t, "delivered.json")
+	now := time.Now()
+	valid := signWebhook(testWebhookKey, "msg_1", strconv.FormatInt(now.Unix(), 10), body)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   error
+	}{
+		{
+			name:   "valid",
+			header: signedWebhookHeader(testWebhookKey, "msg_1", now, body),
+		},
+		{
+			name:   "wrong secret",
+			header: signedWebhookHeader([]byte("other-secret"), "msg_1", now, body),
+			want:   errBadSignature,
+		},
+		{
+			name:   "too old",
+			header: signedWebhookHeader(testWebhookKey, "msg_1", now.Add(-webhookTolerance-time.Minute), body),
+			want:   errors.New("timestamp too far from now"),
+		},
+		{
+			name:   "too far ahead",
+			header: signedWebhookHeader(testWebhookKey, "msg_1", now.Add(webhookTolerance+time.Minute), body),
+			want:   errors.New("timestamp too far from now"),
+		},
+		{
+			name: "one of several signatures",
+			header: http.Header{
+				"Svix-Id":        {"msg_1"},
+				"Svix-Timestamp": {strconv.FormatInt(now.Unix(), 10)},
+				// Svix sends a signature per secret while one is
+				// being rotated.
+				"Svix-Signature": {"v1,c2lnbmVkIHdpdGggdGhlIG9sZCBzZWNyZXQ= v2," + valid + " v1," + valid},
+			},
+		},
+		{
+			name: "only a signature of another version",
+			header: http.Header{
+				"Svix-Id":        {"msg_1"},
+				"Svix-Timestamp": {strconv.FormatInt(now.Unix(), 10)},
+				"Svix-Signature": {"v2," + valid},
+			},
+			want: errBadSignature,
+		},
+		{
+			name:   "missing headers",
+			header: http.Header{"Svix-Id": {"msg_1"}},
+			want:   errors.New("missing svix-id, svix-timestamp or svix-signature header"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyWebhook(testWebhookKey, tt.header, body, now)
+			switch {
+			case tt.want == nil && err != nil:
+				t.Errorf("verifyWebhook: %v", err)
+			case tt.want != nil && (err == nil || err.Error() != tt.want.Error()):
+				t.Errorf("verifyWebhook error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("tampered body", func(t *testing.T) {
+		h := signedWebhookHeader(testWebhookKey, "msg_1", now, body)
+		tampered := bytes.Replace(body, []byte("delivered"), []byte("bounced"), 1)
+		if err := verifyWebhook(testWebhookKey, h, tampered, now); !errors.Is(err, errBadSignature) {
+			t.Errorf("verifyWebhook error = %v, want %v", err, errBadSignature)
+		}
+	})
+}
+
+func TestWebhookKey(t *testing.T) {
+	old := eventsOpts.secret
+	t.Cleanup(func() { eventsOpts.secret = old })
+	eventsOpts.secret = "whsec_dGVzdC1zZWNyZXQ="
+	key, err := webhookKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, testWebhookKey) {
+		t.Errorf("key = %q, want %q", key, testWebhookKey)
+	}
+}
+
+// webhookListener starts a webhook handler with the test key, and returns
+// its URL and where it writes events.
+func webhookListener(t *testing.T) (string, *bytes.Buffer) {
+	t.Helper()
+	useTestDirs(t)
+	var out bytes.Buffer
+	srv := httptest.NewServer(&webhookHandler{key: testWebhookKey, out: &out, seen: map[string]time.Time{}})
+	t.Cleanup(srv.Close)
+	return srv.URL, &out
+}
+
+// postWebhook posts a signed fixture and returns the response status.
+func postWebhook(t *testing.T, url string, key []byte, id, fixture string) int {
+	t.Helper()
+	body := readWebhookFixture(t, fixture)
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = signedWebhookHeader(key, id, time.Now(), body)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWebhookListener(t *testing.T) {
+	t.Run("rejects a wrong secret", func(t *testing.T) {
+		url, out := webhookListener(t)
+		if got := postWebhook(t, url, []byte("other-secret"), "msg_1", "delivered.json"); got != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", got, http.StatusUnauthorized)
+		}
+		if out.Len() != 0 {
+			t.Errorf("rejected event was written: %s", out)
+		}
+	})
+
+	t.Run("handles a redelivered event once", func(t *testing.T) {
+		url, out := webhookListener(t)
+		for range 2 {
+			if got := postWebhook(t, url, testWebhookKey, "msg_1", "delivered.json"); got != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d", got, http.StatusNoContent)
+			}
+		}
+		if got := postWebhook(t, url, testWebhookKey, "msg_2", "delivered.json"); got != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", got, http.StatusNoContent)
+		}
+		if lines := strings.Count(out.String(), "\n"); lines != 2 {
+			t.Errorf("wrote %d events, want 2:\n%s", lines, out)
+		}
+	})
+
+	t.Run("suppresses bounces and complaints", func(t *testing.T) {
+		url, _ := webhookListener(t)
+		for i, fixture := range []string{"bounced.json", "bounced_transient.json", "complained.json", "delivered.json"} {
+			if got := postWebhook(t, url, testWebhookKey, "msg_"+strconv.Itoa(i), fixture); got != http.StatusNoContent {
+				t.Fatalf("%s: status = %d, want %d", fixture, got, http.StatusNoContent)
+			}
+		}
+
+		suppressions, err := loadSuppressions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"gone@example.com":  "bounced",
+			"angry@example.com": "complained",
+			"full@example.com":  "",
+			"happy@example.com": "",
+		}
+		for address, reason := range want {
+			s, ok := suppressions[address]
+			switch {
+			case reason == "" && ok:
+				t.Errorf("%s suppressed for %s", address, s.Reason)
+			case reason != "" && !ok:
+				t.Errorf("%s not suppressed", address)
+			case reason != "" && s.Reason != reason:
+				t.Errorf("%s suppressed for %s, want %s", address, s.Reason, reason)
+			}
+		}
+		if got := suppressions["gone@example.com"].Detail; got != "The recipient's email address doesn't exist." {
+			t.Errorf("bounce detail = %q", got)
+		}
+
+		err = checkSuppressions(Message{To: []string{"you@example.com"}, Cc: []string{"Gone@Example.com"}})
+		var suppressed *suppressedError
+		if !errors.As(err, &suppressed) {
+			t.Errorf("checkSuppressions error = %v, want a suppressedError", err)
+		}
+	})
+}
+
+func TestWebhookRecordingFailure(t *testing.T) {
+	url, out := webhookListener(t)
+	path, err := suppressionsPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The suppression list can't be locked while its lock is a directory.
+	if err := os.Mkdir(path+".lock", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if got := postWebhook(t, url, testWebhookKey, "msg_1", "bounced.json"); got != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", got, http.StatusInternalServerError)
+	}
+	if out.Len() != 0 {
+		t.Errorf("event that wasn't recorded was written: %s", out)
+	}
+
+	// Resend delivers the event again, and this time it's recorded.
+	if err := os.Remove(path + ".lock"); err != nil {
+		t.Fatal(err)
+	}
+	if got := postWebhook(t, url, testWebhookKey, "msg_1", "bounced.json"); got != http.StatusNoContent {
+		t.Fatalf("redelivered: status = %d, want %d", got, http.StatusNoContent)
+	}
+	suppressions, err := loadSuppressions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := suppressions["gone@example.com"]; !ok {
+		t.Error("the redelivered bounce wasn't suppressed")
+	}
+}
+
+func TestWebhookHistory(t *testing.T) {
+	const emailID = "1c2e4f1b-8d3a-4b6e-9f0a-7e5d3c2b1a09"
+	url, _ := webhookListener(t)
+	e := &HistoryEntry{
+		ID:        "a1b2c3d4e5f6a7b8",
+		CreatedAt: time.Now().UTC(),
+		Status:    historySent,
+		Receipt:   &Receipt{Provider: transportResend, ID: emailID},
+	}
+	if err := saveHistoryEntry(e); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := historyDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another entry that can't be read doesn't stop this one's update.
+	if err := os.WriteFile(filepath.Join(dir, "0000000000000000.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := postWebhook(t, url, testWebhookKey, "msg_1", "delivered.json"); got != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", got, http.StatusNoContent)
+	}
+	got, err := findHistoryEntryByReceipt(emailID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Delivery != "delivered" {
+		t.Errorf("delivery = %q, want delivered", got.Delivery)
+	}
+
+	// An event for an email pop didn't send is fine.
+	if got := postWebhook(t, url, testWebhookKey, "msg_2", "complained.json"); got != http.StatusNoContent {
+		t.Errorf("someone else's email: status = %d, want %d", got, http.StatusNoContent)
+	}
+
+	// But one for an entry that can't be read is reported.
+	if err := os.WriteFile(filepath.Join(dir, e.ID+".json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := postWebhook(t, url, testWebhookKey, "msg_3", "delivered.json"); got != http.StatusInternalServerError {
+		t.Errorf("unreadable entry: status = %d, want %d", got, http.StatusInternalServerError)
+	}
+}
+
+func TestFindHistoryEntryByReceipt(t *testing.T) {
+	useTestDirs(t)
+	dir, err := historyDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An entry saved before there was a receipt index.
+	old := []byte(`{"id":"0123456789abcdef","status":"sent","receipt":{"provider":"smtp","id":"<1/2@mx.example.com>"}}`)
+	if err := os.WriteFile(filepath.Join(dir, "0123456789abcdef.json"), old, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e := &HistoryEntry{ID: "fedcba9876543210", Status: historySent, Receipt: &Receipt{Provider: transportResend, ID: "em_1"}}
+	if err := saveHistoryEntry(e); err != nil {
+		t.Fatal(err)
+	}
+
+	for receiptID, want := range map[string]string{"<1/2@mx.example.com>": "0123456789abcdef", "em_1": e.ID} {
+		got, err := findHistoryEntryByReceipt(receiptID)
+		if err != nil {
+			t.Fatalf("findHistoryEntryByReceipt(%q): %v", receiptID, err)
+		}
+		if got.ID != want {
+			t.Errorf("findHistoryEntryByReceipt(%q) = %s, want %s", receiptID, got.ID, want)
+		}
+	}
+	if _, err := findHistoryEntryByReceipt("em_2"); !errors.Is(err, errNotInHistory) {
+		t.Errorf("findHistoryEntryByReceipt error = %v, want %v", err, errNotInHistory)
+	}
+}
+
+func TestWebhookSeenExpires(t *testing.T) {
+	useTestDirs(t)
+	var out bytes.Buffer
+	h := &webhookHandler{key: testWebhookKey, out: &out, seen: map[string]time.Time{
+		"msg_old": time.Now().Add(-webhookTolerance - time.Minute),
+	}}
+	body := readWebhookFixture(t, "delivered.json")
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header = signedWebhookHeader(testWebhookKey, "msg_new", time.Now(), body)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if _, ok := h.seen["msg_old"]; ok {
+		t.Error("an event too old to be accepted again is still remembered")
+	}
+	if _, ok := h.seen["msg_new"]; !ok {
+		t.Error("the new event isn't remembered")
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+)
+
+// PopHistory is the environment variable that turns the sent history off
+// when set to false.
+const PopHistory = "POP_HISTORY"
+
+// Statuses of history entries.
+const (
+	historySent   = "sent"
+	historyFailed = "failed"
+	historyQueued = "queued"
+	// historyScheduled is for emails waiting to be sent at a later time,
+	// and historyCanceled for ones canceled before they were.
+	historyScheduled = "scheduled"
+	historyCanceled  = "canceled"
+)
+
+// noAccount is recorded as the account of emails sent with settings from
+// flags and the environment alone, rather than an account in the config
+// file, so they can't be mistaken for entries that don't say.
+const noAccount = "(none)"
+
+// errUnknownAccount is returned for history entries that don't record the
+// account and transport they were sent with.
+var errUnknownAccount = errors.New("unknown account: the history doesn't record which account sent the email; pick one with --account")
+
+// HistoryEntry is an email pop sent, or tried to send, as kept in the sent
+// history.
+type HistoryEntry struct {
+	ID          string              `json:"id"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Account     string              `json:"account,omitempty"`
+	Transport   string              `json:"transport"`
+	From        string              `json:"from"`
+	To          []string            `json:"to"`
+	Cc          []string            `json:"cc,omitempty"`
+	Bcc         []string            `json:"bcc,omitempty"`
+	Subject     string              `json:"subject"`
+	Body        string              `json:"body"`
+	Plaintext   bool                `json:"plaintext,omitempty"`
+	Attachments []HistoryAttachment `json:"attachments,omitempty"`
+	Status      string              `json:"status"`
+	Error       string              `json:"error,omitempty"`
+	Receipt     *Receipt            `json:"receipt,omitempty"`
+	// SendAt is when the email was scheduled to be sent, if it was.
+	SendAt time.Time `json:"send_at,omitzero"`
+	// Delivery is what the provider last reported about the email, e.g.
+	// "delivered" or "bounced", as of DeliveryUpdatedAt.
+	Delivery          string    `json:"delivery,omitempty"`
+	DeliveryUpdatedAt time.Time `json:"delivery_updated_at,omitzero"`
+}
+
+// HistoryAttachment is a file attached to an email in the history. Only its
+// name and hash are kept, not its contents.
+type HistoryAttachment struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// recipients returns all of the entry's recipients.
+func (e *HistoryEntry) recipients() []string {
+	return slices.Concat(e.To, e.Cc, e.Bcc)
+}
+
+// historyEnabled reports whether sent emails are kept in the history.
+func historyEnabled() bool {
+	return os.Getenv(PopHistory) != "false"
+}
+
+// historyDir returns the directory the sent history is kept in, one file per
+// email, creating it if needed.
+func historyDir() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "history")
+	if err := os.MkdirAll(dir, 0o700); err != nil { //nolint:gosec // G703: dir is from a trusted source
+		return "", fmt.Errorf("creating history directory: %w", err)
+	}
+	return dir, nil
+}
+
+// saveHistoryEntry writes the entry to the history, replacing any earlier
+// version of it.
+func saveHistoryEntry(e *HistoryEntry) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding history entry: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, e.ID+".json"), data); err != nil {
+		return fmt.Errorf("writing history entry: %w", err)
+	}
+	if e.Receipt != nil && e.Receipt.ID != "" {
+		index, err := receiptIndexDir(dir)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(receiptIndexPath(index, e.Receipt.ID), []byte(e.ID)); err != nil {
+			return fmt.Errorf("indexing history entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// errNotInHistory is returned for emails the history doesn't have, like ones
+// sent by something other than pop.
+var errNotInHistory = errors.New("not in the history")
+
+// findHistoryEntryByReceipt returns the entry of the email the provider knows
+// by the given ID, looking it up in the receipt index rather than reading the
+// whole history.
+func findHistoryEntryByReceipt(receiptID string) (*HistoryEntry, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	index, err := receiptIndexDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	id, err := os.ReadFile(receiptIndexPath(index, receiptID)) //nolint:gosec // G304: the path is in the receipt index
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotInHistory
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading receipt index: %w", err)
+	}
+	e, err := readHistoryEntry(filepath.Join(dir, filepath.Base(string(id))+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotInHistory
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.Receipt == nil || e.Receipt.ID != receiptID {
+		return nil, errNotInHistory
+	}
+	return e, nil
+}
+
+// receiptIndexDir returns the directory of the receipt index in the history
+// directory dir. It has a file per provider ID, holding the ID of the history
+// entry. A history kept before there was an index is indexed the first time.
+func receiptIndexDir(dir string) (string, error) {
+	index := filepath.Join(dir, "receipts")
+	if _, err := os.Stat(index); err == nil {
+		return index, nil
+	}
+	// Build the index aside, so no one looks things up in half of it.
+	tmp, err := os.MkdirTemp(dir, "receipts.*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("creating receipt index: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return "", fmt.Errorf("listing history: %w", err)
+	}
+	for _, f := range files {
+		e, err := readHistoryEntry(f)
+		if err != nil || e.Receipt == nil || e.Receipt.ID == "" {
+			continue
+		}
+		if err := os.WriteFile(receiptIndexPath(tmp, e.Receipt.ID), []byte(e.ID), 0o600); err != nil {
+			return "", fmt.Errorf("creating receipt index: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, index); err != nil {
+		// Unless another pop got there first.
+		if _, statErr := os.Stat(index); statErr != nil {
+			return "", fmt.Errorf("creating receipt index: %w", err)
+		}
+	}
+	return index, nil
+}
+
+// receiptIndexPath returns the file in the receipt index for the provider ID.
+// The ID is hashed, since SMTP queue IDs may hold any character.
+func receiptIndexPath(index, receiptID string) string {
+	sum := sha256.Sum256([]byte(receiptID))
+	return filepath.Join(index, hex.EncodeToString(sum[:16]))
+}
+
+// loadHistory returns every entry in the history, newest first. Entries that
+// can't be read are skipped with a warning, so one damaged file doesn't hide
+// the rest of the history.
+func loadHistory() ([]*HistoryEntry, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("listing history: %w", err)
+	}
+	entries := make([]*HistoryEntry, 0, len(files))
+	for _, f := range files {
+		e, err := readHistoryEntry(f)
+		if err != nil {
+			w := colorprofile.NewWriter(os.Stderr, os.Environ())
+			_, _ = fmt.Fprintln(w, errorStyle.Render("Skipped an email in the history: "+err.Error()))
+			continue
+		}
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b *HistoryEntry) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return entries, nil
+}
+
+// readHistoryEntry reads the history entry in the file at path.
+func readHistoryEntry(path string) (*HistoryEntry, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // G304: path is in the history directory
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	var e HistoryEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &e, nil
+}
+
+// findHistoryEntry returns the entry whose ID is, or starts with, id. The
+// provider's ID for the email works too.
+func findHistoryEntry(id string) (*HistoryEntry, error) {
+	entries, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	var found *HistoryEntry
+	for _, e := range entries {
+		if e.ID == id || e.Receipt != nil && e.Receipt.ID == id {
+			return e, nil
+		}
+		if id != "" && strings.HasPrefix(e.ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("%q matches more than one email in the history", id)
+			}
+			found = e
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no email %q in the history", id)
+	}
+	return found, nil
+}
+
+// newHistoryID returns a random ID for a history entry. At 64 bits, IDs
+// don't collide even in a history of millions of emails, and commands still
+// take any unambiguous prefix.
+func newHistoryID() string {
+	b := make([]byte, 8) //nolint:mnd
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// recordSend adds an attempt to send msg to the history and returns the
+// entry, or nil if the history is off. A history that can't be written
+// isn't worth failing the send over, so the error only shows with
+// --verbose.
+func recordSend(account string, sender Sender, msg Message, receipt *Receipt, sendErr error) *HistoryEntry {
+	if !historyEnabled() {
+		return nil
+	}
+	e := newHistoryEntry(account, sender, msg)
+	e.setOutcome(receipt, sendErr)
+	if err := saveHistoryEntry(e); err != nil {
+		logVerbose("Couldn't add the email to the history: %v", err)
+		return nil
+	}
+	return e
+}
+
+// newHistoryEntry returns a history entry for sending msg.
+func newHistoryEntry(account string, sender Sender, msg Message) *HistoryEntry {
+	e := &HistoryEntry{
+		ID:        newHistoryID(),
+		CreatedAt: time.Now().UTC(),
+		Account:   ordefault(account, noAccount),
+		Transport: senderTransport(sender),
+		From:      msg.From,
+		To:        compactAddresses(msg.To),
+		Cc:        compactAddresses(msg.Cc),
+		Bcc:       compactAddresses(msg.Bcc),
+		Subject:   msg.Subject,
+		Body:      msg.Body,
+		Plaintext: msg.Plaintext,
+		SendAt:    msg.SendAt,
+	}
+	for _, path := range msg.Attachments {
+		e.Attachments = append(e.Attachments, historyAttachment(path))
+	}
+	return e
+}
+
+// sentAccount returns the account the entry was sent with, for useAccount.
+// Rather than guess, it fails for entries that don't record their account
+// and transport.
+func (e *HistoryEntry) sentAccount() (string, error) {
+	if e.Account == "" || e.Transport == "" {
+		return "", errUnknownAccount
+	}
+	return e.Account, nil
+}
+
+// setOutcome records how sending the entry's email went.
+func (e *HistoryEntry) setOutcome(receipt *Receipt, sendErr error) {
+	e.Status = historySent
+	e.Error = ""
+	e.Receipt = receipt
+	if receipt != nil && !receipt.ScheduledAt.IsZero() {
+		e.Status = historyScheduled
+	}
+	if sendErr != nil {
+		e.Status = historyFailed
+		e.Error = sendErr.Error()
+	}
+}
+
+// updateHistoryEntry changes the history entry with the given ID, if the
+// history has it.
+func updateHistoryEntry(id string, update func(*HistoryEntry)) {
+	if !historyEnabled() {
+		return
+	}
+	// Only the entry's own file is read and rewritten, so working through
+	// the outbox doesn't read the whole history for every email.
+	dir, err := historyDir()
+	if err != nil {
+		return
+	}
+	e, err := readHistoryEntry(filepath.Join(dir, filepath.Base(id)+".json"))
+	if err != nil || e.ID != id {
+		return
+	}
+	update(e)
+	if err := saveHistoryEntry(e); err != nil {
+		logVerbose("Couldn't update the email in the history: %v", err)
+	}
+}
+
+// historyAttachment describes the attachment at path, hashing its contents
+// so a later change to the file can be spotted.
+func historyAttachment(path string) HistoryAttachment {
+	a := HistoryAttachment{Name: filepath.Base(path), Path: path}
+	if abs, err := filepath.Abs(path); err == nil {
+		a.Path = abs
+	}
+	a.Size, a.SHA256, _ = hashFile(path)
+	return a
+}
+
+// hashFile returns the size and SHA-256 hash of the file at path.
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path) //nolint:gosec // G304: the user picked the file
+	if err != nil {
+		return 0, "", err //nolint:wrapcheck
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err //nolint:wrapcheck
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// errAttachmentChanged is returned by checkAttachment when the file has
+// changed since the email was sent.
+var errAttachmentChanged = errors.New("changed since the email was sent")
+
+// checkAttachment checks that the attachment is still there, unchanged.
+func checkAttachment(a HistoryAttachment) error {
+	_, sum, err := hashFile(a.Path)
+	if err != nil {
+		return err
+	}
+	if a.SHA256 != "" && sum != a.SHA256 {
+		return errAttachmentChanged
+	}
+	return nil
+}
+
+// senderTransport returns the name of the transport behind the sender.
+func senderTransport(sender Sender) string {
+	switch s := sender.(type) {
+	case *SMTPSender:
+		return transportSMTP
+	case *ResendSender:
+		if s.OAuthLogin != "" {
+			return transportResendOAuth
+		}
+		return transportResend
+	default:
+		return ""
+	}
+}
+
+// compactAddresses returns the addresses trimmed, without empty ones.
+func compactAddresses(addresses []string) []string {
+	var compact []string
+	for _, a := range addresses {
+		if a = strings.TrimSpace(a); a != "" {
+			compact = append(compact, a)
+		}
+	}
+	return compact
+}
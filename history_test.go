@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindHistoryEntry(t *testing.T) {
+	useTestDirs(t)
+	for _, e := range []*HistoryEntry{
+		{ID: "a1b2c3d4e5f6a7b8", Status: historySent},
+		{ID: "a1b2ffffffffffff", Status: historySent},
+		{ID: "c0ffee0000000000", Status: historySent, Receipt: &Receipt{Provider: transportResend, ID: "em_1"}},
+	} {
+		if err := saveHistoryEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir, err := historyDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A damaged entry doesn't get in the way of the others.
+	if err := os.WriteFile(filepath.Join(dir, "a1b2000000000000.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr string
+	}{
+		{id: "a1b2c3d4e5f6a7b8", want: "a1b2c3d4e5f6a7b8"},
+		{id: "a1b2c", want: "a1b2c3d4e5f6a7b8"},
+		{id: "a1b2f", want: "a1b2ffffffffffff"},
+		{id: "c", want: "c0ffee0000000000"},
+		{id: "em_1", want: "c0ffee0000000000"},
+		{id: "a1b2", wantErr: "matches more than one email"},
+		{id: "a", wantErr: "matches more than one email"},
+		{id: "a1b2c3d4e5f6a7b80", wantErr: "no email"},
+		{id: "dead", wantErr: "no email"},
+		{id: "", wantErr: "no email"},
+	}
+	for _, tt := range tests {
+		got, err := findHistoryEntry(tt.id)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("findHistoryEntry(%q) = %v, %v; want an error containing %q", tt.id, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("findHistoryEntry(%q): %v", tt.id, err)
+			continue
+		}
+		if got.ID != tt.want {
+			t.Errorf("findHistoryEntry(%q) = %s, want %s", tt.id, got.ID, tt.want)
+		}
+	}
+}
+
+func TestLoadHistorySkipsDamagedEntries(t *testing.T) {
+	useTestDirs(t)
+	old := &HistoryEntry{ID: "0000000000000001", CreatedAt: time.Now().Add(-time.Hour), Status: historySent}
+	recent := &HistoryEntry{ID: "0000000000000002", CreatedAt: time.Now(), Status: historySent}
+	for _, e := range []*HistoryEntry{old, recent} {
+		if err := saveHistoryEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir, err := historyDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "0000000000000003.json"), []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []*HistoryEntry
+	warnings := captureOutput(t, &os.Stderr, func() {
+		entries, err = loadHistory()
+	})
+	if err != nil {
+		t.Fatalf("loadHistory: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != recent.ID || entries[1].ID != old.ID {
+		t.Errorf("loaded %d entries, want the two readable ones, newest first", len(entries))
+	}
+	if !strings.Contains(warnings, "0000000000000003.json") {
+		t.Errorf("warnings = %q, want one naming the damaged file", warnings)
+	}
+}
+
+// captureOutput returns what f writes to file, which is os.Stdout or
+// os.Stderr.
+func captureOutput(t *testing.T, file **os.File, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := *file
+	*file = w
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+	f()
+	*file = old
+	_ = w.Close()
+	return string(<-done)
+}
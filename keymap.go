@@ -10,7 +10,13 @@ type KeyMap struct {
 	Attach    key.Binding
 	Unattach  key.Binding
 	Back      key.Binding
-	Quit      key.Binding
+	Undo      key.Binding
+	Schedule  key.Binding
+	// SetSchedule confirms the time picked with Schedule.
+	SetSchedule key.Binding
+	Account     key.Binding
+	Reauth      key.Binding
+	Quit        key.Binding
 }
 
 // DefaultKeybinds returns the default key bindings for the application.
@@ -43,6 +49,31 @@ func DefaultKeybinds() KeyMap {
 			key.WithHelp("esc", "back"),
 			key.WithDisabled(),
 		),
+		Undo: key.NewBinding(
+			key.WithKeys("esc", "u"),
+			key.WithHelp("esc", "undo"),
+			key.WithDisabled(),
+		),
+		Schedule: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "schedule"),
+			key.WithDisabled(),
+		),
+		SetSchedule: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "set time"),
+			key.WithDisabled(),
+		),
+		Account: key.NewBinding(
+			key.WithKeys("ctrl+o"),
+			key.WithHelp("ctrl+o", "switch account"),
+			key.WithDisabled(),
+		),
+		Reauth: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "sign in again"),
+			key.WithDisabled(),
+		),
 		Quit: key.NewBinding(
 			key.WithKeys("ctrl+c"),
 			key.WithHelp("ctrl+c", "quit"),
@@ -57,6 +88,11 @@ func (k KeyMap) ShortHelp() []key.Binding {
 		k.Quit,
 		k.Attach,
 		k.Unattach,
+		k.Account,
+		k.Reauth,
+		k.Schedule,
+		k.SetSchedule,
+		k.Undo,
 		k.Send,
 	}
 }
@@ -64,7 +100,7 @@ func (k KeyMap) ShortHelp() []key.Binding {
 // FullHelp returns the key bindings for the full help screen.
 func (k KeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.NextInput, k.Send, k.Attach, k.Unattach, k.Quit},
+		{k.NextInput, k.Send, k.Schedule, k.SetSchedule, k.Undo, k.Attach, k.Unattach, k.Account, k.Reauth, k.Quit},
 	}
 }
 
@@ -72,7 +108,12 @@ func (m *Model) updateKeymap() {
 	m.keymap.Attach.SetEnabled(m.state == editingAttachments)
 	m.keymap.Send.SetEnabled(m.canSend() && m.state == hoveringSendButton)
 	m.keymap.Unattach.SetEnabled(m.state == editingAttachments && len(m.Attachments.Items()) > 0)
-	m.keymap.Back.SetEnabled(m.state == pickingFile)
+	m.keymap.Back.SetEnabled(m.state == pickingFile || m.state == pickingSchedule)
+	m.keymap.Schedule.SetEnabled(m.canSend() && m.state == hoveringSendButton)
+	m.keymap.SetSchedule.SetEnabled(m.state == pickingSchedule)
+	m.keymap.Undo.SetEnabled(m.state == waitingToSend)
+	m.keymap.Account.SetEnabled(len(m.accounts) > 1 && m.state != pickingFile && m.state != pickingSchedule && m.state != waitingToSend && m.state != sendingEmail)
+	m.keymap.Reauth.SetEnabled(m.reauth != "" && m.state != pickingFile && m.state != pickingSchedule && m.state != waitingToSend && m.state != sendingEmail)
 
 	m.filepicker.KeyMap.Up.SetEnabled(m.state == pickingFile)
 	m.filepicker.KeyMap.Down.SetEnabled(m.state == pickingFile)
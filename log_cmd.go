@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/charmbracelet/x/term"
+	"github.com/resendlabs/resend-go"
+	"github.com/spf13/cobra"
+)
+
+var logOpts struct {
+	to      string
+	subject string
+	since   string
+	until   string
+	refresh bool
+	json    bool
+}
+
+// LogCmd is the cobra command that lists the sent history.
+var LogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "List sent emails",
+	Long: `Lists the emails pop has sent, or failed to send, newest first.
+
+Filter by recipient or subject (case-insensitive substrings) and by date.
+With --refresh, the delivery status of emails sent through Resend is checked
+first, as with pop status.
+--since and --until take a date (2006-01-02), a date and time
+(2006-01-02 15:04:05) or an age such as 36h or 7d. Set $` + PopHistory + ` to false to
+keep no history.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		since, err := parseHistoryTime(logOpts.since, false)
+		if err != nil {
+			return fmt.Errorf("--since: %w", err)
+		}
+		until, err := parseHistoryTime(logOpts.until, true)
+		if err != nil {
+			return fmt.Errorf("--until: %w", err)
+		}
+		entries, err := loadHistory()
+		if err != nil {
+			return err
+		}
+
+		matches := []*HistoryEntry{}
+		for _, e := range entries {
+			if !since.IsZero() && e.CreatedAt.Before(since) ||
+				!until.IsZero() && !e.CreatedAt.Before(until) ||
+				!containsFold(e.Subject, logOpts.subject) ||
+				logOpts.to != "" && !slicesContainsFold(e.recipients(), logOpts.to) {
+				continue
+			}
+			matches = append(matches, e)
+		}
+
+		if logOpts.refresh {
+			ctx, cancel := context.WithTimeout(cmd.Context(), 5*time.Minute)
+			defer cancel()
+			errWriter := colorprofile.NewWriter(os.Stderr, os.Environ())
+			for _, e := range matches {
+				if e.Receipt == nil || e.Receipt.Provider != transportResend {
+					continue
+				}
+				if err := refreshDelivery(ctx, e); err != nil {
+					_, _ = fmt.Fprintln(errWriter, errorStyle.Render("Couldn't check "+e.ID+": "+err.Error()))
+				}
+			}
+		}
+
+		if logOpts.json {
+			return printJSON(matches)
+		}
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		if len(matches) == 0 {
+			_, _ = fmt.Fprintf(w, "\n  %s\n\n", placeholderStyle.Render("No emails found."))
+			return nil
+		}
+		for _, e := range matches {
+			_, _ = fmt.Fprintln(w, historyLine(e))
+		}
+		return nil
+	},
+}
+
+// LogShowCmd is the cobra command that shows an email from the sent history.
+var LogShowCmd = &cobra.Command{
+	Use:               "show <id>",
+	Short:             "Show a sent email",
+	Long:              `Shows an email from the sent history: its headers, attachments, receipt and body. The ID may be shortened as long as it's unambiguous.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeHistoryIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		e, err := findHistoryEntry(args[0])
+		if err != nil {
+			return err
+		}
+		if logOpts.json {
+			return printJSON(e)
+		}
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		_, _ = fmt.Fprint(w, historyEntryView(e))
+		return nil
+	},
+}
+
+// LogResendCmd is the cobra command that opens an email from the sent
+// history in the TUI again.
+var LogResendCmd = &cobra.Command{
+	Use:   "resend <id>",
+	Short: "Open a sent email in the TUI to send it again",
+	Long: `Opens an email from the sent history in the TUI, with its recipients,
+subject, body and attachments filled in, to edit and send again. It's sent
+with the account it was sent with, unless --account says otherwise.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeHistoryIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		e, err := findHistoryEntry(args[0])
+		if err != nil {
+			return err
+		}
+		if !term.IsTerminal(os.Stdin.Fd()) {
+			return errors.New("resending needs a terminal")
+		}
+
+		account := accountName
+		if account == "" {
+			// An entry that doesn't say falls back to the default
+			// account, which the composer shows and lets the user
+			// change before sending.
+			account, _ = e.sentAccount()
+		}
+		cfg, account, err := useAccount(account)
+		if err != nil {
+			return err
+		}
+		transport, err := pickTransport()
+		if err != nil {
+			return err
+		}
+		sender, err := transport.New()
+		if err != nil {
+			return err
+		}
+
+		if !accountFlags.Lookup("from").Changed {
+			from = e.From
+		}
+		if !accountFlags.Lookup("plaintext").Changed {
+			plaintext = e.Plaintext
+		}
+		errWriter := colorprofile.NewWriter(os.Stderr, os.Environ())
+		var files []resend.Attachment
+		for _, a := range e.Attachments {
+			if err := checkAttachment(a); err != nil {
+				if !errors.Is(err, errAttachmentChanged) {
+					_, _ = fmt.Fprintln(errWriter, errorStyle.Render("Leaving out "+a.Name+": "+err.Error()))
+					continue
+				}
+				_, _ = fmt.Fprintln(errWriter, commentStyle.Render(a.Path+" has "+err.Error()+"."))
+			}
+			files = append(files, resend.Attachment{Filename: a.Path})
+		}
+
+		model := NewModel(resend.SendEmailRequest{
+			From:        from,
+			To:          e.To,
+			Cc:          e.Cc,
+			Bcc:         e.Bcc,
+			Subject:     e.Subject,
+			Text:        e.Body,
+			Attachments: files,
+		}, sender)
+		model.accounts = cfg.accountNames()
+		model.account = account
+		if signature != "" && strings.HasSuffix(e.Body, "\n\n"+signature) {
+			model.signature = signature
+		}
+		model.updateKeymap()
+		return silenceQueued(cmd, runTUI(model))
+	},
+}
+
+// parseHistoryTime parses a --since or --until value: a date, a date and
+// time, or an age such as 36h or 7d. A date on its own means the start of the
+// day, or with end set the end of it.
+func parseHistoryTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a date or an age like 7d", s)
+}
+
+// containsFold reports whether substr is within s, ignoring case.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// slicesContainsFold reports whether any of ss contains substr, ignoring
+// case.
+func slicesContainsFold(ss []string, substr string) bool {
+	for _, s := range ss {
+		if containsFold(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(v any) error {
+	return writeJSON(os.Stdout, v)
+}
+
+// writeJSON writes v to w as indented JSON.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("encoding JSON: %w", err)
+	}
+	return nil
+}
+
+// historyStatus renders the entry's status: the delivery status the
+// provider last reported, or whether pop sent it at all.
+func historyStatus(e *HistoryEntry) string {
+	status := ordefault(e.Delivery, e.Status)
+	switch status {
+	case historyFailed, "bounced", "complained":
+		return errorStyle.Render(status)
+	default:
+		return activeLabelStyle.Render(status)
+	}
+}
+
+// historyLine renders the entry as one line of `pop log`.
+func historyLine(e *HistoryEntry) string {
+	to := strings.Join(e.To, ", ")
+	if others := len(e.Cc) + len(e.Bcc); others > 0 {
+		to += fmt.Sprintf(" +%d", others)
+	}
+	return fmt.Sprintf("%s%s %s %s %s",
+		textStyle.Render(e.ID),
+		commentStyle.Render(e.CreatedAt.Local().Format("2006-01-02 15:04")),
+		historyStatus(e)+strings.Repeat(" ", max(0, len("delivered")-len(ordefault(e.Delivery, e.Status)))),
+		linkStyle.Render(to),
+		activeTextStyle.Render(`"`+e.Subject+`"`),
+	)
+}
+
+// historyEntryView renders the entry for `pop log show`.
+func historyEntryView(e *HistoryEntry) string {
+	const gap = "  "
+
+	var s strings.Builder
+	s.WriteString("\n")
+	label := labelStyle.Width(12) //nolint:mnd
+	row := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&s, "%s%s %s\n", gap, label.Render(name), value)
+		}
+	}
+
+	row("id", e.ID)
+	row("date", e.CreatedAt.Local().Format(time.DateTime))
+	row("status", e.Status)
+	if !e.SendAt.IsZero() {
+		row("send at", e.SendAt.Local().Format(time.DateTime))
+	}
+	if e.Delivery != "" {
+		row("delivery", historyStatus(e)+commentStyle.Render("(as of "+e.DeliveryUpdatedAt.Local().Format(time.DateTime)+")"))
+	}
+	if e.Error != "" {
+		row("error", errorStyle.Render(e.Error))
+	}
+	row("account", e.Account)
+	row("transport", e.Transport)
+	if r := e.Receipt; r != nil {
+		row("server", r.Server)
+		row("provider id", r.ID)
+		row("message-id", r.MessageID)
+		for _, rr := range r.Rejected {
+			row("rejected", errorStyle.Render(rr.Address+": "+rr.Error))
+		}
+	}
+	s.WriteString("\n")
+	row("from", e.From)
+	row("to", strings.Join(e.To, ", "))
+	row("cc", strings.Join(e.Cc, ", "))
+	row("bcc", strings.Join(e.Bcc, ", "))
+	row("subject", e.Subject)
+	for _, a := range e.Attachments {
+		sum := a.SHA256
+		if len(sum) > 12 { //nolint:mnd
+			sum = sum[:12]
+		}
+		row("attachment", a.Name+commentStyle.Render(fmt.Sprintf("(%d bytes, sha256 %s)", a.Size, sum)))
+	}
+	s.WriteString("\n")
+	s.WriteString(e.Body)
+	s.WriteString("\n\n")
+	return s.String()
+}
+
+// completeHistoryIDs completes the IDs in the sent history, described by
+// their subjects.
+func completeHistoryIDs(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	entries, err := loadHistory()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID + "\t" + e.Subject
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+}
+
+func init() {
+	LogCmd.AddCommand(LogShowCmd)
+	LogCmd.AddCommand(LogResendCmd)
+	LogCmd.Flags().StringVar(&logOpts.to, "to", "", "Only emails to recipients containing this")
+	LogCmd.Flags().StringVar(&logOpts.subject, "subject", "", "Only emails with subjects containing this")
+	LogCmd.Flags().StringVar(&logOpts.since, "since", "", "Only emails sent since this date or age")
+	LogCmd.Flags().StringVar(&logOpts.until, "until", "", "Only emails sent before this date or age")
+	LogCmd.Flags().BoolVar(&logOpts.refresh, "refresh", false, "Ask the provider for the delivery status of the emails first")
+	LogCmd.Flags().BoolVar(&logOpts.json, "json", false, "Print the emails as JSON")
+	LogShowCmd.Flags().BoolVar(&logOpts.json, "json", false, "Print the email as JSON")
+}
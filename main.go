@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	tea "charm.land/bubbletea/v2"
@@ -30,6 +32,11 @@ const envTrue = "true"
 // Resend delivery (as opposed to API key delivery).
 const PopOAuthResend = "POP_OAUTH_RESEND"
 
+// PopOAuthName is the environment variable that selects a named Resend OAuth
+// login, as stored by `pop auth --name`, and enables OAuth-based Resend
+// delivery with it.
+const PopOAuthName = "POP_OAUTH_NAME"
+
 // PopPlaintext control whether the message should be sent in plaintext.
 // Boolean, default `false`.
 const PopPlaintext = "POP_PLAINTEXT"
@@ -44,6 +51,10 @@ const PopFrom = "POP_FROM"
 // PopSignature is the environment variable that sets the default signature.
 const PopSignature = "POP_SIGNATURE"
 
+// PopSMTPProvider is the name of a well-known SMTP provider whose host, port
+// and encryption are used unless set explicitly.
+const PopSMTPProvider = "POP_SMTP_PROVIDER"
+
 // PopSMTPHost is the host for the SMTP server if the user is using the
 // SMTP delivery method.
 const PopSMTPHost = "POP_SMTP_HOST"
@@ -64,6 +75,14 @@ const PopSMTPPassword = "POP_SMTP_PASSWORD" //nolint:gosec
 // user is using the SMTP delivery method.
 const PopSMTPEncryption = "POP_SMTP_ENCRYPTION"
 
+// PopSMTPAuth is the SASL mechanism used to log in to the SMTP server: auto,
+// plain, login, xoauth2 or oauthbearer.
+const PopSMTPAuth = "POP_SMTP_AUTH"
+
+// PopSMTPTokenCmd is a command that prints an OAuth bearer token for the
+// xoauth2 and oauthbearer SMTP mechanisms.
+const PopSMTPTokenCmd = "POP_SMTP_TOKEN_CMD"
+
 // PopSMTPInsecureSkipVerify is whether or not to skip TLS verification for the
 // SMTP server if the user is using the SMTP delivery method.
 const PopSMTPInsecureSkipVerify = "POP_SMTP_INSECURE_SKIP_VERIFY"
@@ -78,17 +97,28 @@ var (
 	plaintext              bool
 	attachments            []string
 	preview                bool
+	sendAt                 string
+	delay                  time.Duration
 	unsafe                 bool
 	signature              string
+	smtpProvider           string
 	smtpHost               string
 	smtpPort               int
 	smtpUsername           string
 	smtpPassword           string
 	smtpEncryption         string
 	smtpInsecureSkipVerify bool
+	smtpAuth               string
+	smtpTokenCmd           string
 	resendAPIKey           string
 	oauthResend            bool
-	oauthNoBrowser         bool
+	oauthName              string
+	jsonOutput             bool
+	authOpts               authOptions
+	accountName            string
+	verbose                bool
+	connectTimeout         time.Duration
+	sendTimeout            time.Duration
 )
 
 var rootCmd = &cobra.Command{
@@ -111,6 +141,24 @@ See "pop skill" for a full skill definition for AI agents.`,
 		// if needed.
 		errWriter := colorprofile.NewWriter(os.Stderr, os.Environ())
 
+		var sendAtTime time.Time
+		if sendAt != "" {
+			t, err := parseSendAt(sendAt, time.Now())
+			if err != nil {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("--send-at: %w", err)
+			}
+			sendAtTime = t
+		}
+
+		cfg, account, err := useAccount(accountName)
+		if err != nil {
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			_, _ = fmt.Fprintf(errWriter, "\n  %s %s\n\n", errorHeaderStyle.String(), err)
+			return err
+		}
+
 		if smtpPassword != "" && smtpUsername == "" {
 			err := errors.New("SMTP password provided without an SMTP username")
 			cmd.SilenceUsage = true
@@ -118,57 +166,32 @@ See "pop skill" for a full skill definition for AI agents.`,
 			_, _ = fmt.Fprintf(errWriter, "\n  %s %s\n\n", errorHeaderStyle.String(), err)
 			return err
 		}
-		smtpEnabled := smtpHost != "" || smtpUsername != ""
-
-		var deliveryMethod DeliveryMethod
-		switch {
-		case resendAPIKey != "" && smtpEnabled && oauthResend:
-			deliveryMethod = Unknown
-		case resendAPIKey != "" && smtpEnabled:
-			deliveryMethod = Unknown
-		case resendAPIKey != "" && oauthResend:
-			deliveryMethod = Unknown
-		case smtpEnabled && oauthResend:
-			deliveryMethod = Unknown
-		case resendAPIKey != "":
-			deliveryMethod = Resend
-		case oauthResend:
-			token, err := getValidAccessToken()
-			if err != nil {
-				cmd.SilenceUsage = true
-				cmd.SilenceErrors = true
-				fmt.Println(errorStyle.Render(err.Error()))
-				return err
-			}
-			if token == "" {
-				fmt.Printf("\n  %s No OAuth token found. Run %s to authenticate.\n\n", errorHeaderStyle.String(), inlineCodeStyle.Render("pop auth"))
-				cmd.SilenceUsage = true
-				cmd.SilenceErrors = true
-				return errors.New("no OAuth token found")
-			}
-			resendAPIKey = token
-			deliveryMethod = Resend
-		case smtpEnabled:
-			deliveryMethod = SMTP
-			if from == "" && smtpUsername != "" {
-				from = smtpUsername
-			}
-		default:
-			// No delivery method was explicitly configured. If we have a
-			// valid OAuth token from a previous `pop auth`, use it instead
-			// of showing setup instructions.
-			token, err := getValidAccessToken()
-			if err != nil {
+
+		transport, err := pickTransport()
+		if errors.Is(err, errNoTransport) && term.IsTerminal(os.Stdin.Fd()) {
+			// Nothing is configured yet, so walk the user through setting
+			// things up and carry on with the account they just created.
+			name, setupErr := runSetup()
+			if setupErr != nil {
 				cmd.SilenceUsage = true
 				cmd.SilenceErrors = true
-				fmt.Println(errorStyle.Render(err.Error()))
-				return err
+				_, _ = fmt.Fprintf(errWriter, "\n  %s %s\n\n", errorHeaderStyle.String(), setupErr)
+				return setupErr
 			}
-			if token != "" {
-				resendAPIKey = token
-				deliveryMethod = Resend
+			if name != "" {
+				fmt.Println(setupSuccessView(name))
+				if cfg, account, err = useAccount(name); err != nil {
+					cmd.SilenceUsage = true
+					cmd.SilenceErrors = true
+					_, _ = fmt.Fprintf(errWriter, "\n  %s %s\n\n", errorHeaderStyle.String(), err)
+					return err
+				}
+				transport, err = pickTransport()
 			}
 		}
+		if transport.Name == transportSMTP && from == "" && smtpUsername != "" {
+			from = smtpUsername
+		}
 
 		{
 			const gap = "  "
@@ -192,32 +215,57 @@ See "pop skill" for a full skill definition for AI agents.`,
 				_, _ = fmt.Fprintln(errWriter, s.String())
 			}
 
-			switch deliveryMethod {
-			case None:
+			var ambiguous *ambiguousTransportError
+			switch {
+			case errors.Is(err, errNoTransport):
 				_, _ = fmt.Fprintf(errWriter, "\n%s%s Hello!\n\n", gap, noticeHeaderStyle.SetString("Charm Pop"))
-				p("Pop’s a simple tool for sending email in your termnial. To get going you’ll need to either configure either SMTP or Resend.")
+				p("Pop’s a simple tool for sending email in your terminal. To get going you’ll need to configure either SMTP or Resend. The easiest way is to run " + inlineCodeStyle.Render("pop setup") + " in a terminal.")
 				p("To use Resend, authenticate with " + inlineCodeStyle.Render("pop auth") + ".")
 				p("To use SMTP, set the following in your environment:")
-				bullet("POP_SMTP_HOST", "")
-				bullet("POP_SMTP_PORT", "(defaults to 587)")
-				bullet("POP_SMTP_HOST", "")
-				bullet("POP_SMTP_ENCRYPTION", "(starttls, ssl, or none)")
-				bullet("POP_SMTP_INSECURE_SKIP_VERIFY", "(starttls, ssl, or none)")
+				bullet(PopSMTPHost, "(or "+PopSMTPProvider+", e.g. gmail or fastmail)")
+				bullet(PopSMTPPort, "(defaults to 587)")
+				bullet(PopSMTPUsername, "")
+				bullet(PopSMTPPassword, "")
+				bullet(PopSMTPEncryption, "(starttls, ssl, or none)")
+				bullet(PopSMTPInsecureSkipVerify, "(true to skip TLS verification)")
 				_, _ = fmt.Fprintln(errWriter)
 				cmd.SilenceUsage = true
 				cmd.SilenceErrors = true
-				return errors.New("missing delivery method")
-			case Unknown:
+				return err
+			case errors.As(err, &ambiguous):
 				_, _ = fmt.Fprintf(errWriter, "\n%s%s Unknown delivery method.\n\n", gap, errorHeaderStyle)
-				p("You have set both %s and %s delivery methods: " + inlineCodeStyle.Render(ResendAPIKey) + " and " + inlineCodeStyle.Render("POP_SMPT_*"))
-				p("Set only one of these environment variables.")
+				names := make([]string, len(ambiguous.names))
+				for i, name := range ambiguous.names {
+					names[i] = inlineCodeStyle.Render(name)
+				}
+				p("You have configured more than one delivery method: " + strings.Join(names, ", "))
+				p("Configure only one of these. Run " + inlineCodeStyle.Render("pop config show") + " to see where each setting comes from.")
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return err
+			case err != nil:
 				cmd.SilenceUsage = true
 				cmd.SilenceErrors = true
-				return errors.New("unknown delivery method")
-			case Resend, SMTP:
+				fmt.Println(errorStyle.Render(err.Error()))
+				return err
 			}
 		}
 
+		sender, err := transport.New()
+		var reauth *reauthError
+		if errors.Is(err, errNoOAuthToken) && errors.As(err, &reauth) {
+			fmt.Printf("\n  %s No OAuth token found. Run %s to authenticate.\n\n", errorHeaderStyle.String(), inlineCodeStyle.Render(authCommand(reauth.login)))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			return err
+		}
+		if err != nil {
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			fmt.Println(errorStyle.Render(err.Error()))
+			return err
+		}
+
 		if body == "" && hasStdin() {
 			b, err := io.ReadAll(os.Stdin)
 			if err != nil {
@@ -231,30 +279,47 @@ See "pop skill" for a full skill definition for AI agents.`,
 		}
 
 		if len(to) > 0 && from != "" && subject != "" && body != "" && !preview {
-			var err error
-			switch deliveryMethod {
-			case SMTP:
-				err = sendSMTPEmail(to, cc, bcc, from, subject, body, plaintext, attachments)
-			case Resend:
-				err = sendResendEmail(to, cc, bcc, from, subject, body, plaintext, attachments)
-			default:
-				err = fmt.Errorf("unknown delivery method")
+			msg := Message{
+				From:        from,
+				To:          to,
+				Cc:          cc,
+				Bcc:         bcc,
+				Subject:     subject,
+				Body:        body,
+				Plaintext:   plaintext,
+				Attachments: attachments,
+				SendAt:      sendAtTime,
+			}
+			// Interrupting stops the countdown, or the send itself.
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			if err := waitToSend(ctx, errWriter, delay); err != nil {
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				_, _ = fmt.Fprintln(errWriter, errorStyle.Render(err.Error()))
+				return err
 			}
+			d, err := deliver(ctx, account, sender, msg)
 			if err != nil {
 				cmd.SilenceUsage = true
 				cmd.SilenceErrors = true
 				_, _ = fmt.Fprintln(errWriter, errorStyle.Render(err.Error()))
+				if d.entry != nil {
+					_, _ = fmt.Fprintln(errWriter, commentStyle.Render("Saved to the history. Run `pop log resend "+d.entry.ID+"` to try again."))
+				}
 				return err
 			}
-			_, _ = fmt.Print(emailSummary(to, subject))
-			return nil
+			if d.queued != nil {
+				return silenceQueued(cmd, printQueued(d.queued))
+			}
+			return printReceipt(subject, d.receipt)
 		}
 
 		if !term.IsTerminal(os.Stdin.Fd()) {
 			return cmd.Usage()
 		}
 
-		p := tea.NewProgram(NewModel(resend.SendEmailRequest{
+		model := NewModel(resend.SendEmailRequest{
 			From:        from,
 			To:          to,
 			Bcc:         bcc,
@@ -262,20 +327,81 @@ See "pop skill" for a full skill definition for AI agents.`,
 			Subject:     subject,
 			Text:        body,
 			Attachments: makeAttachments(attachments),
-		}, deliveryMethod))
-
-		m, err := p.Run()
-		if err != nil {
-			return fmt.Errorf("running program: %w", err)
-		}
-		mm := m.(Model)
-		if !mm.abort {
-			fmt.Print(emailSummary(strings.Split(mm.To.Value(), ToSeparator), mm.Subject.Value()))
-		}
-		return nil
+		}, sender)
+		model.accounts = cfg.accountNames()
+		model.account = account
+		model.signature = signature
+		model.sendAt = sendAtTime
+		model.updateKeymap()
+		return silenceQueued(cmd, runTUI(model))
 	},
 }
 
+// silenceQueued keeps cobra from printing errQueued, which printQueued has
+// already told the user about, or the usage, and returns err.
+func silenceQueued(cmd *cobra.Command, err error) error {
+	if errors.Is(err, errQueued) {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+	}
+	return err
+}
+
+// runTUI runs the TUI, and prints the receipt once it has sent the email.
+func runTUI(model Model) error {
+	if err := unlockTokenStore(false); err != nil {
+		return err
+	}
+	undoSend, err := undoSendWindow()
+	if err != nil {
+		return err
+	}
+	model.undoSend = undoSend
+	p := tea.NewProgram(model)
+
+	m, err := p.Run()
+	if err != nil {
+		return fmt.Errorf("running program: %w", err)
+	}
+	mm := m.(Model)
+	if !mm.abort && mm.queued != nil {
+		return printQueued(mm.queued)
+	}
+	if !mm.abort && mm.receipt != nil {
+		return printReceipt(mm.Subject.Value(), mm.receipt)
+	}
+	return nil
+}
+
+// printReceipt prints the summary of a sent email, or with --json its
+// receipt.
+func printReceipt(subject string, receipt *Receipt) error {
+	if jsonOutput {
+		return printJSON(receipt)
+	}
+	fmt.Print(emailSummary(subject, receipt))
+	return nil
+}
+
+// pickTransport selects the configured transport. When nothing is configured
+// but a valid OAuth token is stored from a previous `pop auth`, it picks
+// Resend over OAuth instead of returning errNoTransport.
+func pickTransport() (Transport, error) {
+	transport, err := selectTransport()
+	if !errors.Is(err, errNoTransport) {
+		return transport, err
+	}
+	token, tokenErr := getValidAccessToken(providerResend)
+	if tokenErr != nil {
+		return Transport{}, tokenErr
+	}
+	if token == "" {
+		return Transport{}, err
+	}
+	transport, _ = lookupTransport(transportResendOAuth)
+	return transport, nil
+}
+
 // hasStdin returns whether there is data in stdin.
 func hasStdin() bool {
 	stat, err := os.Stdin.Stat()
@@ -319,57 +445,189 @@ var AuthCmd = &cobra.Command{
 	Long:  `Authenticate with Resend using OAuth 2.0 with PKCE. This opens a browser for authorization and stores tokens locally.`,
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, _ []string) error {
-		if term.IsTerminal(os.Stdin.Fd()) {
-			if err := startOAuthFlowTUI(oauthNoBrowser); err != nil {
-				cmd.SilenceUsage = true
-				cmd.SilenceErrors = true
+		return runAuth(cmd, providerResend)
+	},
+}
 
-				errWriter := colorprofile.NewWriter(os.Stderr, os.Environ())
-				paragraph := paragraphStyle
-				if width, _, szErr := term.GetSize(os.Stderr.Fd()); szErr == nil {
-					paragraph = paragraph.Width(width - paragraph.GetHorizontalFrameSize())
-				}
+// AuthGoogleCmd is the cobra command for OAuth authentication with Google,
+// for sending through Gmail's SMTP server with XOAUTH2.
+var AuthGoogleCmd = &cobra.Command{
+	Use:   "google",
+	Short: "Authenticate with Google for Gmail SMTP",
+	Long: `Authenticate with Google using OAuth 2.0 with PKCE, for sending through Gmail's SMTP server with --smtp.auth xoauth2.
 
-				var httpErr *HTTPError
-				if errors.As(err, &httpErr) {
-					_, _ = fmt.Fprintf(errWriter, "\n  %s %s\n\n", errorHeaderStyle.String(), httpErr.Status)
-					_, _ = fmt.Fprintf(errWriter, "%s\n\n", paragraph.Render(httpErr.Body))
-				} else {
-					_, _ = fmt.Fprintf(errWriter, "\n  %s\n\n", errorHeaderStyle.String())
-					_, _ = fmt.Fprintf(errWriter, "%s\n\n", paragraph.Render(err.Error()))
-				}
-				return err
+Google requires a client ID of your own: create a "Desktop app" OAuth client in the Google Cloud console and set $POP_GOOGLE_CLIENT_ID and $POP_GOOGLE_CLIENT_SECRET.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runAuth(cmd, providerGoogle)
+	},
+}
+
+// AuthMicrosoftCmd is the cobra command for OAuth authentication with
+// Microsoft, for sending through Microsoft 365's SMTP server with XOAUTH2.
+var AuthMicrosoftCmd = &cobra.Command{
+	Use:   "microsoft",
+	Short: "Authenticate with Microsoft for Outlook / Office 365 SMTP",
+	Long: `Authenticate with Microsoft using OAuth 2.0 with PKCE, for sending through Microsoft 365's SMTP server with --smtp.auth xoauth2.
+
+Register a public client application in Microsoft Entra with the SMTP.Send permission and set $POP_MICROSOFT_CLIENT_ID. Set $POP_MICROSOFT_TENANT to restrict sign-in to one tenant.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runAuth(cmd, providerMicrosoft)
+	},
+}
+
+// runAuth runs the OAuth flow for the given provider, with a TUI when
+// running in a terminal.
+func runAuth(cmd *cobra.Command, provider string) error {
+	cmd.SilenceUsage = true
+	if err := checkNamedLogin(provider, authOpts.name); err != nil {
+		return err
+	}
+	p, err := lookupOAuthProvider(provider)
+	if err != nil {
+		return err
+	}
+	if term.IsTerminal(os.Stdin.Fd()) {
+		if err := startOAuthFlowTUI(p, authOpts); err != nil {
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+
+			errWriter := colorprofile.NewWriter(os.Stderr, os.Environ())
+			paragraph := paragraphStyle
+			if width, _, szErr := term.GetSize(os.Stderr.Fd()); szErr == nil {
+				paragraph = paragraph.Width(width - paragraph.GetHorizontalFrameSize())
 			}
-			return nil
+
+			var httpErr *HTTPError
+			if errors.As(err, &httpErr) {
+				_, _ = fmt.Fprintf(errWriter, "\n  %s %s\n\n", errorHeaderStyle.String(), httpErr.Status)
+				_, _ = fmt.Fprintf(errWriter, "%s\n\n", paragraph.Render(httpErr.Body))
+			} else {
+				_, _ = fmt.Fprintf(errWriter, "\n  %s\n\n", errorHeaderStyle.String())
+				_, _ = fmt.Fprintf(errWriter, "%s\n\n", paragraph.Render(err.Error()))
+			}
+			return err
 		}
-		return startOAuthFlow()
-	},
+		return nil
+	}
+	return startOAuthFlow(p, authOpts)
 }
 
+// revokeName is the named login `pop auth revoke --name` revokes.
+var revokeName string
+
 // RevokeCmd is the cobra command for revoking OAuth tokens.
 var RevokeCmd = &cobra.Command{
-	Use:   "revoke",
-	Short: "Revoke OAuth authentication with Resend",
-	Long:  `Revokes and removes stored OAuth tokens for Resend.`,
-	Args:  cobra.NoArgs,
-	RunE: func(_ *cobra.Command, _ []string) error {
-		token, err := loadAuth()
+	Use:   "revoke [resend|google|microsoft]",
+	Short: "Revoke OAuth authentication",
+	Long: `Revokes and removes the stored OAuth token for Resend, or for the given
+provider. With --name, only that named Resend login is revoked.`,
+	Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: oauthProviderNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		provider := providerResend
+		if len(args) > 0 {
+			provider = args[0]
+		}
+		if err := checkNamedLogin(provider, revokeName); err != nil {
+			return err
+		}
+		p, err := lookupOAuthProvider(provider)
+		if err != nil {
+			return err
+		}
+		login := loginKey(p.Name, revokeName)
+		token, err := loadAuth(login)
 		if err != nil {
 			if os.IsNotExist(err) {
-				fmt.Println("No OAuth token found.")
+				fmt.Printf("No OAuth token found for %s.\n", loginLabel(login))
 				return nil
 			}
 			return fmt.Errorf("loading auth: %w", err)
 		}
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
-		if err := revokeToken(ctx, oauthClientID, token.RefreshToken); err != nil {
-			fmt.Println(errorStyle.Render("Failed to revoke token: " + err.Error()))
-		}
-		if err := deleteAuth(); err != nil {
+		status, revokeErr := revokeToken(ctx, p, token.RefreshToken)
+		// Forget the token either way: it's no use to keep one the user
+		// asked to be rid of.
+		if err := deleteAuth(login); err != nil {
 			return fmt.Errorf("deleting auth: %w", err)
 		}
-		fmt.Println("Successfully revoked OAuth authentication.")
+		if revokeErr != nil {
+			fmt.Printf("Removed the stored %s token, but the provider didn't confirm the revocation.\n", loginLabel(login))
+			return fmt.Errorf("revoking token: %w", revokeErr)
+		}
+		if status == "" {
+			fmt.Printf("Removed the stored %s token. %s has no revocation endpoint, so revoke pop's access in your account settings.\n", loginLabel(login), p.Label)
+			return nil
+		}
+		fmt.Printf("Successfully revoked OAuth authentication with %s.%s\n", loginLabel(login), commentStyle.Render(status))
+		return nil
+	},
+}
+
+// migrateStoreFrom is the token store migrate-store moves tokens out of.
+var migrateStoreFrom string
+
+// MigrateStoreCmd is the cobra command for moving OAuth tokens between token
+// stores.
+var MigrateStoreCmd = &cobra.Command{
+	Use:   "migrate-store <" + strings.Join(tokenStoreNames, "|") + ">",
+	Short: "Move stored OAuth tokens to another token store",
+	Long: `Moves every stored OAuth token from the configured token store (or the one
+given with --from) to the given store. Set token_store in the config file, or
+$` + PopTokenStore + `, to use the new store afterwards.`,
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: tokenStoreNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		configured := configuredTokenStore(cfg)
+		from, to := configured, args[0]
+		if migrateStoreFrom != "" {
+			from = migrateStoreFrom
+		}
+		if strings.EqualFold(from, to) {
+			return fmt.Errorf("tokens are already in the %s store", to)
+		}
+		src, err := openTokenStore(from, cfg)
+		if err != nil {
+			return err
+		}
+		dst, err := openTokenStore(to, cfg)
+		if err != nil {
+			return err
+		}
+
+		logins, err := src.List()
+		if err != nil {
+			return err
+		}
+		for _, login := range logins {
+			token, err := src.Load(login)
+			if err != nil {
+				return fmt.Errorf("loading %s token: %w", login, err)
+			}
+			if err := dst.Save(login, token); err != nil {
+				return fmt.Errorf("saving %s token: %w", login, err)
+			}
+			if err := src.Delete(login); err != nil {
+				return fmt.Errorf("removing %s token from the %s store: %w", login, from, err)
+			}
+			fmt.Printf("Moved %s token to %s.\n", loginLabel(login), dst.Location(login))
+		}
+		if len(logins) == 0 {
+			fmt.Printf("No OAuth tokens found in the %s store.\n", from)
+			return nil
+		}
+		if !strings.EqualFold(configured, to) {
+			fmt.Printf("Set token_store = %q in your config file (or $%s) to use it.\n", to, PopTokenStore)
+		}
 		return nil
 	},
 }
@@ -379,8 +637,36 @@ func init() {
 	rootCmd.AddCommand(AuthCmd)
 	rootCmd.AddCommand(SkillCmd)
 	rootCmd.AddCommand(InstallSkillCmd)
+	rootCmd.AddCommand(ConfigCmd)
+	rootCmd.AddCommand(DoctorCmd)
+	rootCmd.AddCommand(SetupCmd)
+	rootCmd.AddCommand(LogCmd)
+	rootCmd.AddCommand(StatusCmd)
+	rootCmd.AddCommand(EventsCmd)
+	rootCmd.AddCommand(SuppressionsCmd)
+	rootCmd.AddCommand(QueueCmd)
+	rootCmd.AddCommand(ScheduledCmd)
+	rootCmd.AddCommand(MergeCmd)
+	rootCmd.AddCommand(BatchCmd)
+	AuthCmd.AddCommand(AuthGoogleCmd)
+	AuthCmd.AddCommand(AuthMicrosoftCmd)
 	AuthCmd.AddCommand(RevokeCmd)
-	AuthCmd.Flags().BoolVar(&oauthNoBrowser, "no-browser", false, "Simulate browser open failure (for testing)")
+	AuthCmd.AddCommand(AuthStatusCmd)
+	AuthCmd.AddCommand(MigrateStoreCmd)
+	RevokeCmd.Flags().StringVar(&revokeName, "name", "", "Named Resend login to revoke")
+	_ = RevokeCmd.RegisterFlagCompletionFunc("name", completeLoginNames)
+	MigrateStoreCmd.Flags().StringVar(&migrateStoreFrom, "from", "", "Token store to move tokens from, instead of the configured one")
+	_ = MigrateStoreCmd.RegisterFlagCompletionFunc("from", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return tokenStoreNames, cobra.ShellCompDirectiveNoFileComp
+	})
+	AuthCmd.PersistentFlags().BoolVar(&authOpts.noBrowser, "no-browser", false, "Simulate browser open failure (for testing)")
+	AuthCmd.PersistentFlags().BoolVar(&authOpts.device, "device", false, "Authenticate with a code entered on another device")
+	AuthCmd.PersistentFlags().BoolVar(&authOpts.manual, "manual", false, "Open the URL elsewhere and paste the redirected URL or code back in")
+	AuthCmd.PersistentFlags().IntVar(&authOpts.callbackPort, "callback-port", 0, "Fixed local port for the OAuth redirect, e.g. for SSH port forwarding")
+	AuthCmd.Flags().StringVar(&authOpts.name, "name", "", "Store the login under a name, to keep several Resend logins (send with --oauth.name)")
+	_ = AuthCmd.RegisterFlagCompletionFunc("name", completeLoginNames)
+	AuthCmd.MarkFlagsMutuallyExclusive("device", "manual")
+	AuthCmd.MarkFlagsMutuallyExclusive("device", "callback-port")
 
 	rootCmd.Flags().StringSliceVar(&bcc, "bcc", []string{}, "BCC recipients")
 	rootCmd.Flags().StringSliceVar(&cc, "cc", []string{}, "CC recipients")
@@ -391,12 +677,24 @@ func init() {
 	rootCmd.Flags().BoolVar(&plaintext, "plaintext", envPlaintext, "Whether to send email in plaintext")
 	envFrom := os.Getenv(PopFrom)
 	rootCmd.Flags().StringVarP(&from, "from", "f", envFrom, "Email's sender"+commentStyle.Render("($"+PopFrom+")"))
+	_ = rootCmd.RegisterFlagCompletionFunc("from", completeFrom)
 	rootCmd.Flags().StringVarP(&subject, "subject", "s", "", "Email's subject")
 	rootCmd.Flags().BoolVar(&preview, "preview", false, "Whether to preview the email before sending")
+	rootCmd.Flags().DurationVar(&delay, "delay", 0, "Wait this long before sending, to allow canceling with ctrl+c")
+	rootCmd.Flags().StringVar(&sendAt, "send-at", "", `When to send the email: RFC 3339, "2006-01-02 15:04", "tomorrow 9am", "in 2h"...`)
+	_ = rootCmd.RegisterFlagCompletionFunc("send-at", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return sendAtExamples, cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the delivery receipt as JSON instead of a summary")
 	envUnsafe := os.Getenv(PopUnsafeHTML) == envTrue
 	rootCmd.Flags().BoolVarP(&unsafe, "unsafe", "u", envUnsafe, "Whether to allow unsafe HTML in the email body, also enable some extra markdown features (Experimental)")
 	envSignature := os.Getenv(PopSignature)
 	rootCmd.Flags().StringVarP(&signature, "signature", "x", envSignature, "Signature to display at the end of the email."+commentStyle.Render("($"+PopSignature+")"))
+	envSMTPProvider := os.Getenv(PopSMTPProvider)
+	rootCmd.Flags().StringVar(&smtpProvider, "smtp.provider", envSMTPProvider, "SMTP provider preset ("+strings.Join(smtpPresetNames(), ", ")+"), inferred from the username when unset"+commentStyle.Render("($"+PopSMTPProvider+")"))
+	_ = rootCmd.RegisterFlagCompletionFunc("smtp.provider", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return smtpPresetNames(), cobra.ShellCompDirectiveNoFileComp
+	})
 	envSMTPHost := os.Getenv(PopSMTPHost)
 	rootCmd.Flags().StringVarP(&smtpHost, "smtp.host", "H", envSMTPHost, "Host of the SMTP server"+commentStyle.Render("($"+PopSMTPHost+")"))
 	envSMTPPort, _ := strconv.Atoi(os.Getenv(PopSMTPPort))
@@ -412,10 +710,47 @@ func init() {
 	rootCmd.Flags().StringVarP(&smtpEncryption, "smtp.encryption", "e", envSMTPEncryption, "Encryption type of the SMTP server (starttls, ssl, or none)"+commentStyle.Render("($"+PopSMTPEncryption+")"))
 	envInsecureSkipVerify := os.Getenv(PopSMTPInsecureSkipVerify) == envTrue
 	rootCmd.Flags().BoolVarP(&smtpInsecureSkipVerify, "smtp.insecure", "i", envInsecureSkipVerify, "Skip TLS verification with SMTP server"+commentStyle.Render("($"+PopSMTPInsecureSkipVerify+")"))
+	envSMTPAuth := os.Getenv(PopSMTPAuth)
+	rootCmd.Flags().StringVar(&smtpAuth, "smtp.auth", envSMTPAuth, "SMTP auth mechanism ("+strings.Join(smtpAuthMechanisms, ", ")+")"+commentStyle.Render("($"+PopSMTPAuth+")"))
+	_ = rootCmd.RegisterFlagCompletionFunc("smtp.auth", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return smtpAuthMechanisms, cobra.ShellCompDirectiveNoFileComp
+	})
+	envSMTPTokenCmd := os.Getenv(PopSMTPTokenCmd)
+	rootCmd.Flags().StringVar(&smtpTokenCmd, "smtp.token-cmd", envSMTPTokenCmd, "Command that prints an OAuth token for xoauth2/oauthbearer"+commentStyle.Render("($"+PopSMTPTokenCmd+")"))
 	envResendAPIKey := os.Getenv(ResendAPIKey)
 	rootCmd.Flags().StringVarP(&resendAPIKey, "resend.key", "r", envResendAPIKey, "API key for the Resend.com"+commentStyle.Render("($"+ResendAPIKey+")"))
 	envOAuthResend := os.Getenv(PopOAuthResend) == envTrue
 	rootCmd.Flags().BoolVar(&oauthResend, "oauth", envOAuthResend, "Use OAuth for Resend authentication"+commentStyle.Render("($"+PopOAuthResend+")"))
+	envOAuthName := os.Getenv(PopOAuthName)
+	rootCmd.Flags().StringVar(&oauthName, "oauth.name", envOAuthName, "Named Resend OAuth login to send with (see pop auth --name)"+commentStyle.Render("($"+PopOAuthName+")"))
+	_ = rootCmd.RegisterFlagCompletionFunc("oauth.name", completeLoginNames)
+
+	accountFlags = rootCmd.Flags()
+	envAccount := os.Getenv(PopAccount)
+	rootCmd.PersistentFlags().StringVarP(&accountName, "account", "A", envAccount, "Account to use from the config file"+commentStyle.Render("($"+PopAccount+")"))
+
+	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "Print diagnostic details, such as discovered SMTP servers, to stderr")
+	envConnectTimeout, err := time.ParseDuration(os.Getenv(PopConnectTimeout))
+	if err != nil {
+		envConnectTimeout = defaultConnectTimeout
+	}
+	rootCmd.PersistentFlags().DurationVar(&connectTimeout, "connect-timeout", envConnectTimeout, "How long to wait to connect to the SMTP server or Resend"+commentStyle.Render("($"+PopConnectTimeout+")"))
+	envSendTimeout, err := time.ParseDuration(os.Getenv(PopSendTimeout))
+	if err != nil {
+		envSendTimeout = defaultSendTimeout
+	}
+	rootCmd.PersistentFlags().DurationVar(&sendTimeout, "send-timeout", envSendTimeout, "How long to wait for the provider to take the message"+commentStyle.Render("($"+PopSendTimeout+")"))
+
+	shareSettingsFlags(ConfigShowCmd)
+	shareSettingsFlags(DoctorCmd)
+	shareSettingsFlags(LogCmd)
+	shareSettingsFlags(LogResendCmd)
+	shareSettingsFlags(StatusCmd)
+	shareSettingsFlags(QueueFlushCmd)
+	shareSettingsFlags(QueueRunCmd)
+	shareSettingsFlags(ScheduledCancelCmd)
+	shareSettingsFlags(MergeCmd)
+	shareSettingsFlags(BatchCmd)
 
 	rootCmd.CompletionOptions.HiddenDefaultCmd = true
 
@@ -433,8 +768,15 @@ func init() {
 	rootCmd.Version = Version
 }
 
+// exitQueued is the exit status when the email couldn't be sent yet and was
+// queued in the outbox (EX_TEMPFAIL).
+const exitQueued = 75
+
 func main() {
 	err := rootCmd.Execute()
+	if errors.Is(err, errQueued) {
+		os.Exit(exitQueued)
+	}
 	if err != nil {
 		os.Exit(1)
 	}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/spf13/cobra"
+)
+
+var mergeOpts struct {
+	data        string
+	template    string
+	subject     string
+	attachments []string
+	preview     int
+	concurrency int
+	rate        float64
+	report      string
+	json        bool
+}
+
+// Fields of a merge data row that say where each email goes, rather than
+// fill in its template.
+const (
+	mergeFieldTo          = "to"
+	mergeFieldCc          = "cc"
+	mergeFieldBcc         = "bcc"
+	mergeFieldSubject     = "subject"
+	mergeFieldAttachments = "attachments"
+)
+
+// MergeCmd is the cobra command that sends a templated email to every row of
+// a CSV or JSON file.
+var MergeCmd = &cobra.Command{
+	Use:   "merge --data <file> --template <file>",
+	Short: "Send a personalised email to every row of a CSV or JSON file",
+	Long: `Sends one email per row of a CSV file with a header row, a JSON array of
+objects, or JSON objects one per line. The subject and the Markdown body are Go
+templates rendered with the row's fields, e.g. "Hi {{.name}}," or
+{{index . "first name"}} for names with spaces in them.
+
+Each row needs a "to" field, and may have "cc", "bcc" and "attachments", with
+several values separated by commas, or as a JSON array. A "subject" field is
+used as the subject template when --subject isn't given.
+
+Every row is rendered, and its attachments checked, before anything is sent,
+so a typo in a field name stops the merge with nothing sent. --preview shows
+the first renders instead of sending. Emails are sent --concurrency at a time,
+at most --rate a second, and go to the outbox and the sent history like any
+other. Interrupting stops the merge; the report says which rows were sent.`,
+	Example: `  pop merge --data people.csv --template invite.md --subject "Hi {{.name}}" --preview 3
+  pop merge --data people.csv --template invite.md --subject "Hi {{.name}}" --rate 2 --report results.csv`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		if mergeOpts.data == "" || mergeOpts.template == "" {
+			return errors.New("--data and --template are required")
+		}
+		if mergeOpts.concurrency < 1 {
+			return errors.New("--concurrency must be at least 1")
+		}
+		if mergeOpts.rate < 0 {
+			return errors.New("--rate can't be negative")
+		}
+		rows, err := loadMergeData(mergeOpts.data)
+		if err != nil {
+			return err
+		}
+		body, err := os.ReadFile(mergeOpts.template)
+		if err != nil {
+			return fmt.Errorf("reading template: %w", err)
+		}
+
+		_, account, err := useAccount(accountName)
+		if err != nil {
+			return err
+		}
+		transport, err := pickTransport()
+		if err != nil {
+			return err
+		}
+		if transport.Name == transportSMTP && from == "" && smtpUsername != "" {
+			from = smtpUsername
+		}
+		if from == "" {
+			return errors.New("no sender: set --from, $" + PopFrom + " or from in the account")
+		}
+		merge := mailMerge{from: from, signature: signature, attachments: mergeOpts.attachments}
+		if merge.body, err = parseMergeTemplate("body", string(body)); err != nil {
+			return err
+		}
+		if mergeOpts.subject != "" {
+			if merge.subject, err = parseMergeTemplate("subject", mergeOpts.subject); err != nil {
+				return err
+			}
+		}
+		msgs, err := merge.render(rows)
+		if err != nil {
+			return err
+		}
+
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		if mergeOpts.preview > 0 {
+			printMergePreview(w, msgs, mergeOpts.preview)
+			return nil
+		}
+
+		sender, err := transport.New()
+		if err != nil {
+			return err
+		}
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		results := sendMerge(ctx, account, sender, msgs, func(r mergeResult) {
+			if !mergeOpts.json {
+				_, _ = fmt.Fprintln(w, mergeResultLine(r))
+			}
+		})
+
+		if mergeOpts.report != "" {
+			if err := writeMergeReport(mergeOpts.report, results); err != nil {
+				return err
+			}
+		}
+		if mergeOpts.json {
+			if err := printJSON(results); err != nil {
+				return err
+			}
+		}
+		var failed, canceled int
+		for _, r := range results {
+			switch r.Status {
+			case historyFailed:
+				failed++
+			case historyCanceled:
+				canceled++
+			}
+		}
+		switch {
+		case canceled > 0:
+			return fmt.Errorf("interrupted with %d of %d email(s) sent or queued", len(results)-canceled-failed, len(results))
+		case failed > 0:
+			return fmt.Errorf("%d of %d email(s) couldn't be sent", failed, len(results))
+		}
+		return nil
+	},
+}
+
+// mergeRow is one row of merge data: a CSV record by column name, or a JSON
+// object.
+type mergeRow map[string]any
+
+// loadMergeData reads the rows of a CSV or JSON file, or of stdin for "-".
+// The format is told by the file's extension, or its first character.
+func loadMergeData(path string) ([]mergeRow, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading merge data: %w", err)
+	}
+	var rows []mergeRow
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".csv":
+		rows, err = parseMergeCSV(data)
+	case ext == ".json", ext == ".jsonl", ext == ".ndjson":
+		rows, err = parseMergeJSON(data)
+	default:
+		trimmed := bytes.TrimSpace(data)
+		if bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")) {
+			rows, err = parseMergeJSON(data)
+		} else {
+			rows, err = parseMergeCSV(data)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading merge data: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("the merge data has no rows")
+	}
+	return rows, nil
+}
+
+// parseMergeCSV parses CSV with a header row.
+func parseMergeCSV(data []byte) ([]mergeRow, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	for i, name := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+	}
+	rows := make([]mergeRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(mergeRow, len(header))
+		for i, name := range header {
+			row[name] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseMergeJSON parses a JSON array of objects, or objects one after the
+// other, as in JSON Lines.
+func parseMergeJSON(data []byte) ([]mergeRow, error) {
+	var rows []mergeRow
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		return rows, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var row mergeRow
+		err := dec.Decode(&row)
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", len(rows)+1, err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// field returns the row's values for one of the mergeField names, matched
+// regardless of case if there's no exact match. A string holds several values
+// separated by commas.
+func (row mergeRow) field(name string) []string {
+	v, ok := row[name]
+	if !ok {
+		for k := range row {
+			if strings.EqualFold(k, name) {
+				v, ok = row[k], true
+				break
+			}
+		}
+	}
+	if ok {
+		switch v := v.(type) {
+		case string:
+			return compactAddresses(strings.Split(v, ToSeparator))
+		case []any:
+			var values []string
+			for _, value := range v {
+				values = append(values, fmt.Sprint(value))
+			}
+			return compactAddresses(values)
+		case nil:
+			return nil
+		default:
+			return []string{fmt.Sprint(v)}
+		}
+	}
+	return nil
+}
+
+// checkKeys reports keys that differ only in case, since it's unclear which
+// of them a field means.
+func (row mergeRow) checkKeys() error {
+	keys := slices.Sorted(maps.Keys(row))
+	for i, k := range keys {
+		for _, other := range keys[i+1:] {
+			if strings.EqualFold(k, other) {
+				return fmt.Errorf("fields %q and %q differ only in case", k, other)
+			}
+		}
+	}
+	return nil
+}
+
+// parseMergeTemplate parses a subject or body template. Fields missing from a
+// row are an error, rather than rendered as "<no value>".
+func parseMergeTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s template: %w", name, err)
+	}
+	return t, nil
+}
+
+// mailMerge renders the emails of a merge.
+type mailMerge struct {
+	from      string
+	signature string
+	// attachments go with every email, as well as the row's own.
+	attachments []string
+	// subject is nil when the subject comes from each row.
+	subject *template.Template
+	body    *template.Template
+}
+
+// mergeMessage is the email for one row of merge data.
+type mergeMessage struct {
+	// Row is the row's number, counting from 1.
+	Row int
+	Message
+}
+
+// render renders the email for every row, stopping at the first row that
+// can't be sent.
+func (m mailMerge) render(rows []mergeRow) ([]mergeMessage, error) {
+	msgs := make([]mergeMessage, 0, len(rows))
+	for i, row := range rows {
+		msg, err := m.renderRow(row)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		msgs = append(msgs, mergeMessage{Row: i + 1, Message: msg})
+	}
+	return msgs, nil
+}
+
+func (m mailMerge) renderRow(row mergeRow) (Message, error) {
+	if err := row.checkKeys(); err != nil {
+		return Message{}, err
+	}
+	msg := Message{
+		From:        m.from,
+		To:          row.field(mergeFieldTo),
+		Cc:          row.field(mergeFieldCc),
+		Bcc:         row.field(mergeFieldBcc),
+		Plaintext:   plaintext,
+		Attachments: append(slices.Clone(m.attachments), row.field(mergeFieldAttachments)...),
+	}
+	if len(msg.To) == 0 {
+		return msg, errors.New(`no "to" address`)
+	}
+	subject := m.subject
+	if subject == nil {
+		text := strings.Join(row.field(mergeFieldSubject), ToSeparator)
+		if text == "" {
+			return msg, errors.New(`no subject: give --subject or a "subject" field`)
+		}
+		var err error
+		if subject, err = parseMergeTemplate("subject", text); err != nil {
+			return msg, err
+		}
+	}
+	var s strings.Builder
+	if err := subject.Execute(&s, row); err != nil {
+		return msg, err //nolint:wrapcheck
+	}
+	msg.Subject = strings.TrimSpace(s.String())
+	s.Reset()
+	if err := m.body.Execute(&s, row); err != nil {
+		return msg, err //nolint:wrapcheck
+	}
+	msg.Body = s.String()
+	if m.signature != "" {
+		msg.Body += "\n\n" + m.signature
+	}
+	for _, a := range msg.Attachments {
+		if _, err := os.Stat(a); err != nil {
+			return msg, fmt.Errorf("attachment: %w", err)
+		}
+	}
+	return msg, nil
+}
+
+// printMergePreview prints the first n rendered emails.
+func printMergePreview(w io.Writer, msgs []mergeMessage, n int) {
+	for _, msg := range msgs[:min(n, len(msgs))] {
+		_, _ = fmt.Fprintf(w, "\n  %s\n", activeLabelStyle.Render(fmt.Sprintf("Row %d of %d", msg.Row, len(msgs))))
+		header := func(name string, values []string) {
+			if len(values) > 0 {
+				_, _ = fmt.Fprintf(w, "  %s %s\n", textStyle.Render(fmt.Sprintf("%-8s", name)), linkStyle.Render(strings.Join(values, ", ")))
+			}
+		}
+		header("To", msg.To)
+		header("Cc", msg.Cc)
+		header("Bcc", msg.Bcc)
+		_, _ = fmt.Fprintf(w, "  %s %s\n", textStyle.Render(fmt.Sprintf("%-8s", "Subject")), activeTextStyle.Render(`"`+msg.Subject+`"`))
+		attachments := make([]string, len(msg.Attachments))
+		for i, a := range msg.Attachments {
+			attachments[i] = filepath.Base(a)
+		}
+		header("Attach", attachments)
+		_, _ = fmt.Fprintln(w)
+		for line := range strings.SplitSeq(strings.TrimRight(msg.Body, "\n"), "\n") {
+			if line != "" {
+				line = "  " + line
+			}
+			_, _ = fmt.Fprintln(w, line)
+		}
+	}
+	note := fmt.Sprintf("%d email(s) ready. Run again without --preview to send them.", len(msgs))
+	if more := len(msgs) - n; more > 0 {
+		note = fmt.Sprintf("%d more not shown. ", more) + note
+	}
+	_, _ = fmt.Fprintf(w, "\n%s\n\n", commentStyle.Render(note))
+}
+
+// mergeResult is the outcome of one email of a merge, as written to the
+// report.
+type mergeResult struct {
+	Row     int      `json:"row"`
+	To      []string `json:"to"`
+	Cc      []string `json:"cc,omitempty"`
+	Subject string   `json:"subject"`
+	// Status is sent, scheduled, queued for the outbox, failed, or canceled
+	// when the merge was interrupted before the email was handed over.
+	Status string `json:"status"`
+	// ID is the provider's ID for a sent email, the outbox ID for a queued
+	// one, and the history ID for a failed one.
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// sendMerge sends the emails, concurrency at a time and no more than rate a
+// second if rate is set, calling done as each finishes. Once ctx is
+// canceled, the emails not sent yet are canceled. The results are in the
+// order of msgs.
+func sendMerge(ctx context.Context, account string, sender Sender, msgs []mergeMessage, done func(mergeResult)) []mergeResult {
+	results := make([]mergeResult, len(msgs))
+	pacer := newMergePacer(mergeOpts.rate)
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		jobs = make(chan int)
+	)
+	for range min(mergeOpts.concurrency, len(msgs)) {
+		wg.Go(func() {
+			for i := range jobs {
+				r := sendMergeMessage(ctx, account, sender, msgs[i], pacer)
+				mu.Lock()
+				results[i] = r
+				done(r)
+				mu.Unlock()
+			}
+		})
+	}
+	for i := range msgs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// mergePacer spaces sends out to keep to a rate.
+type mergePacer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newMergePacer returns a pacer for rate emails a second, or nil for no
+// limit.
+func newMergePacer(rate float64) *mergePacer {
+	if rate <= 0 {
+		return nil
+	}
+	return &mergePacer{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait waits for the next free slot, and reports false if ctx is canceled
+// first. A nil pacer doesn't wait.
+func (p *mergePacer) wait(ctx context.Context) bool {
+	if p == nil {
+		return ctx.Err() == nil
+	}
+	p.mu.Lock()
+	slot := time.Now()
+	if p.next.After(slot) {
+		slot = p.next
+	}
+	p.next = slot.Add(p.interval)
+	p.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// sendMergeMessage sends one email of a merge, once the pacer lets it.
+func sendMergeMessage(ctx context.Context, account string, sender Sender, msg mergeMessage, pacer *mergePacer) mergeResult {
+	r := mergeResult{Row: msg.Row, To: msg.To, Cc: msg.Cc, Subject: msg.Subject, Status: historyCanceled}
+	if !pacer.wait(ctx) {
+		return r
+	}
+	d, err := deliver(ctx, account, sender, msg.Message)
+	switch {
+	case canceledUnsent(err):
+		r.Error = err.Error()
+	case err != nil:
+		r.Status = historyFailed
+		r.Error = err.Error()
+		if d.entry != nil {
+			r.ID = d.entry.ID
+		}
+	case d.queued != nil:
+		r.Status = historyQueued
+		r.ID = d.queued.ID
+		r.Error = d.queued.LastError
+	default:
+		r.Status = historySent
+		if !d.receipt.ScheduledAt.IsZero() {
+			r.Status = historyScheduled
+		}
+		r.ID = d.receipt.ID
+		if len(d.receipt.Rejected) > 0 {
+			rejected := make([]string, len(d.receipt.Rejected))
+			for i, rr := range d.receipt.Rejected {
+				rejected[i] = rr.Address + ": " + rr.Error
+			}
+			r.Error = "not sent to " + strings.Join(rejected, "; ")
+		}
+	}
+	return r
+}
+
+// mergeResultLine renders a result as a line of progress.
+func mergeResultLine(r mergeResult) string {
+	status := r.Status
+	switch r.Status {
+	case historyFailed, historyCanceled:
+		status = errorStyle.Render(r.Status)
+	case historyQueued, historyScheduled:
+		status = activeLabelStyle.Render(r.Status)
+	}
+	line := fmt.Sprintf("%s %s %s %s",
+		textStyle.Render(fmt.Sprintf("%4d", r.Row)),
+		status,
+		linkStyle.Render(strings.Join(r.To, ", ")),
+		activeTextStyle.Render(`"`+r.Subject+`"`),
+	)
+	if r.Error != "" {
+		line += "\n     " + commentStyle.Render(r.Error)
+	}
+	return line
+}
+
+// writeMergeReport writes the results to path: as JSON for a .json file and
+// CSV otherwise.
+func writeMergeReport(path string, results []mergeResult) error {
+	var buf bytes.Buffer
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(results); err != nil {
+			return fmt.Errorf("encoding report: %w", err)
+		}
+	} else {
+		w := csv.NewWriter(&buf)
+		_ = w.Write([]string{"row", "to", "cc", "subject", "status", "id", "error"})
+		for _, r := range results {
+			_ = w.Write([]string{
+				strconv.Itoa(r.Row),
+				strings.Join(r.To, ToSeparator),
+				strings.Join(r.Cc, ToSeparator),
+				r.Subject,
+				r.Status,
+				r.ID,
+				r.Error,
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fmt.Errorf("encoding report: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	MergeCmd.Flags().StringVar(&mergeOpts.data, "data", "", `CSV or JSON file with a row per email, or "-" for stdin`)
+	MergeCmd.Flags().StringVar(&mergeOpts.template, "template", "", "Markdown body template")
+	MergeCmd.Flags().StringVarP(&mergeOpts.subject, "subject", "s", "", `Subject template, e.g. "Hi {{.name}}"`)
+	MergeCmd.Flags().StringSliceVarP(&mergeOpts.attachments, "attach", "a", nil, "Attach a file to every email")
+	MergeCmd.Flags().IntVar(&mergeOpts.preview, "preview", 0, "Show the first N emails instead of sending")
+	MergeCmd.Flags().IntVar(&mergeOpts.concurrency, "concurrency", 4, "How many emails to send at once") //nolint:mnd
+	MergeCmd.Flags().Float64Var(&mergeOpts.rate, "rate", 0, "Send at most this many emails a second (0 for no limit)")
+	MergeCmd.Flags().StringVar(&mergeOpts.report, "report", "", "Write the outcome for each row to this file (.csv or .json)")
+	MergeCmd.Flags().BoolVar(&mergeOpts.json, "json", false, "Print the outcomes as JSON instead of progress")
+	_ = MergeCmd.MarkFlagFilename("data", "csv", "json", "jsonl", "ndjson")
+	_ = MergeCmd.MarkFlagFilename("template", "md", "txt")
+	_ = MergeCmd.MarkFlagFilename("report", "csv", "json")
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMergeCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []mergeRow
+		wantErr string
+	}{
+		{
+			name: "header names are trimmed",
+			data: "\ufeffto, first name ,plan\nada@example.com,Ada,pro\n",
+			want: []mergeRow{{"to": "ada@example.com", "first name": "Ada", "plan": "pro"}},
+		},
+		{
+			name: "quoted fields",
+			data: "to,name,note\n" +
+				`"ada@example.com, bob@example.com","Lovelace, Ada","She said ""hi""` + "\n" + `twice"` + "\n",
+			want: []mergeRow{{
+				"to":   "ada@example.com, bob@example.com",
+				"name": "Lovelace, Ada",
+				"note": "She said \"hi\"\ntwice",
+			}},
+		},
+		{
+			name: "leading spaces before quotes",
+			data: "to,name\nada@example.com, \"Lovelace, Ada\"\n",
+			want: []mergeRow{{"to": "ada@example.com", "name": "Lovelace, Ada"}},
+		},
+		{
+			name: "CRLF line endings",
+			data: "to,name\r\nada@example.com,Ada\r\nbob@example.com,Bob\r\n",
+			want: []mergeRow{
+				{"to": "ada@example.com", "name": "Ada"},
+				{"to": "bob@example.com", "name": "Bob"},
+			},
+		},
+		{
+			name: "header only",
+			data: "to,name\n",
+			want: []mergeRow{},
+		},
+		{
+			name:    "row with a missing field",
+			data:    "to,name\nada@example.com\n",
+			wantErr: "wrong number of fields",
+		},
+		{
+			name:    "unterminated quote",
+			data:    "to,name\nada@example.com,\"Ada\n",
+			wantErr: "quote",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMergeCSV([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseMergeCSV error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMergeCSV: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMergeCSV = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMergeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int
+		wantErr string
+	}{
+		{name: "array", data: `[{"to":"a@example.com"},{"to":"b@example.com"}]`, want: 2},
+		{name: "lines", data: "{\"to\":\"a@example.com\"}\n\n{\"to\":\"b@example.com\"}\n", want: 2},
+		{name: "bad second line", data: "{\"to\":\"a@example.com\"}\n{\"to\":}\n", wantErr: "row 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseMergeJSON([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseMergeJSON error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMergeJSON: %v", err)
+			}
+			if len(rows) != tt.want {
+				t.Errorf("got %d rows, want %d", len(rows), tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeRowField(t *testing.T) {
+	row := mergeRow{
+		"To":          "ada@example.com, bob@example.com,,",
+		"cc":          []any{"carol@example.com", " ", "dan@example.com"},
+		"bcc":         nil,
+		"attachments": 42.0,
+	}
+	tests := []struct {
+		field string
+		want  []string
+	}{
+		{mergeFieldTo, []string{"ada@example.com", "bob@example.com"}},
+		{mergeFieldCc, []string{"carol@example.com", "dan@example.com"}},
+		{mergeFieldBcc, nil},
+		{mergeFieldAttachments, []string{"42"}},
+		{mergeFieldSubject, nil},
+	}
+	for _, tt := range tests {
+		if got := row.field(tt.field); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("field(%q) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+
+	// An exact match wins over keys that differ only in case, whichever
+	// order the map gives them in.
+	row = mergeRow{"TO": "ada@example.com", "to": "bob@example.com", "To": "carol@example.com"}
+	for range 20 {
+		if got := row.field(mergeFieldTo); !reflect.DeepEqual(got, []string{"bob@example.com"}) {
+			t.Fatalf("field(%q) = %q, want the exact match", mergeFieldTo, got)
+		}
+	}
+}
+
+func TestMergeRender(t *testing.T) {
+	tests := []struct {
+		name        string
+		subject     string
+		body        string
+		rows        []mergeRow
+		wantSubject string
+		wantBody    string
+		wantErr     string
+	}{
+		{
+			name:        "fields",
+			subject:     "Hi {{.name}}",
+			body:        `{{index . "first name"}} is on {{.plan}}.`,
+			rows:        []mergeRow{{"to": "ada@example.com", "name": "Ada", "first name": "Ada", "plan": "pro"}},
+			wantSubject: "Hi Ada",
+			wantBody:    "Ada is on pro.",
+		},
+		{
+			name:        "subject from the row",
+			body:        "Hello",
+			rows:        []mergeRow{{"to": "ada@example.com", "subject": "For {{.name}}", "name": "Ada"}},
+			wantSubject: "For Ada",
+			wantBody:    "Hello",
+		},
+		{
+			name:    "missing key",
+			subject: "Hi",
+			body:    "Dear {{.name}},",
+			rows: []mergeRow{
+				{"to": "ada@example.com", "name": "Ada"},
+				{"to": "bob@example.com", "nmae": "Bob"},
+			},
+			wantErr: `row 2: template: body:1:7: executing "body" at <.name>: map has no entry for key "name"`,
+		},
+		{
+			name:    "missing key in the row's subject",
+			body:    "Hello",
+			rows:    []mergeRow{{"to": "ada@example.com", "subject": "For {{.name}}"}},
+			wantErr: `map has no entry for key "name"`,
+		},
+		{
+			name:    "no to",
+			subject: "Hi",
+			body:    "Hello",
+			rows:    []mergeRow{{"to": "ada@example.com"}, {"to": " , "}},
+			wantErr: `row 2: no "to" address`,
+		},
+		{
+			name:    "keys differing in case",
+			subject: "Hi",
+			body:    "Hello",
+			rows: []mergeRow{
+				{"to": "ada@example.com"},
+				{"to": "bob@example.com", "To": "carol@example.com"},
+			},
+			wantErr: `row 2: fields "To" and "to" differ only in case`,
+		},
+		{
+			name:    "no subject",
+			body:    "Hello",
+			rows:    []mergeRow{{"to": "ada@example.com"}},
+			wantErr: "row 1: no subject",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				m   mailMerge
+				err error
+			)
+			if m.body, err = parseMergeTemplate("body", tt.body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.subject != "" {
+				if m.subject, err = parseMergeTemplate("subject", tt.subject); err != nil {
+					t.Fatal(err)
+				}
+			}
+			msgs, err := m.render(tt.rows)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("render error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			if got := msgs[0]; got.Row != 1 || got.Subject != tt.wantSubject || got.Body != tt.wantBody {
+				t.Errorf("rendered row %d %q %q, want row 1 %q %q", got.Row, got.Subject, got.Body, tt.wantSubject, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMergePacer(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want time.Duration
+	}{
+		{0, 0},
+		{-1, 0},
+		{1, time.Second},
+		{4, 250 * time.Millisecond},
+		{3, 333333333 * time.Nanosecond},
+		{0.5, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		p := newMergePacer(tt.rate)
+		switch {
+		case tt.want == 0 && p != nil:
+			t.Errorf("newMergePacer(%v) = %v, want no pacer", tt.rate, p.interval)
+		case tt.want != 0 && p == nil:
+			t.Errorf("newMergePacer(%v) = no pacer, want an interval of %v", tt.rate, tt.want)
+		case tt.want != 0 && p.interval != tt.want:
+			t.Errorf("newMergePacer(%v) interval = %v, want %v", tt.rate, p.interval, tt.want)
+		}
+	}
+
+	t.Run("slots", func(t *testing.T) {
+		const interval = 20 * time.Millisecond
+		p := &mergePacer{interval: interval}
+		start := time.Now()
+		for i := range 5 {
+			if !p.wait(context.Background()) {
+				t.Fatal("wait reported a canceled context")
+			}
+			if elapsed := time.Since(start); elapsed < time.Duration(i)*interval {
+				t.Errorf("send %d after %s, want at least %s", i+1, elapsed, time.Duration(i)*interval)
+			}
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		p := &mergePacer{interval: time.Hour}
+		p.wait(context.Background())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if p.wait(ctx) {
+			t.Error("wait didn't give up when the context was canceled")
+		}
+	})
+}
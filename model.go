@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
+	"slices"
 	"strings"
 	"time"
 
@@ -31,31 +36,18 @@ const (
 	editingAttachments
 	hoveringSendButton
 	pickingFile
+	pickingSchedule
+	waitingToSend
 	sendingEmail
 )
 
-// DeliveryMethod is the method of delivery for the email.
-type DeliveryMethod int
-
-const (
-	// None is the default delivery method.
-	None DeliveryMethod = iota
-	// Resend uses https://resend.com to send an email.
-	Resend
-	// SMTP uses an SMTP server to send an email.
-	SMTP
-	// Unknown is set when the user has not chosen a single delivery method.
-	// i.e. multiple delivery methods are set.
-	Unknown
-)
-
 // Model is Pop's application model.
 type Model struct {
 	// state represents the current state of the application.
 	state State
 
-	// DeliveryMethod is whether we are using DeliveryMethod or Resend.
-	DeliveryMethod DeliveryMethod
+	// Sender delivers the email once the user hits send.
+	Sender Sender
 
 	// From represents the sender's email address.
 	From textinput.Model
@@ -75,6 +67,45 @@ type Model struct {
 	Cc     textinput.Model
 	Bcc    textinput.Model
 
+	// accounts are the names of the accounts in the config file, and
+	// account is the one currently in use.
+	accounts []string
+	account  string
+	// signature is the signature currently appended to the body, so it can
+	// be swapped out when switching accounts.
+	signature string
+
+	// tokenGen identifies the current sender's background token refresh,
+	// so refreshes scheduled for a previous sender are ignored.
+	tokenGen int
+	// reauth is the OAuth login the user has to sign in to again before
+	// sending, and sendAfterAuth is set when a send is waiting on it.
+	reauth        string
+	sendAfterAuth bool
+
+	// domains are the sender's verified domains, offered as completions in
+	// the From field.
+	domains []string
+
+	// sendAt is when the email is scheduled to be sent, if not right away,
+	// and SendAt is where it's picked.
+	sendAt time.Time
+	SendAt textinput.Model
+	// sendAtErr is why the time in SendAt can't be used, if it can't.
+	sendAtErr error
+
+	// undoSend is how long to wait after the user hits send before
+	// sending, so they can undo it. While waiting, sendDeadline is when
+	// the email goes out, and sendGen tells the current countdown's ticks
+	// from those of one that was undone.
+	undoSend     time.Duration
+	sendDeadline time.Time
+	sendGen      int
+	// cancelSend cancels the send in progress, and cancelingSend is set
+	// once the user asked to.
+	cancelSend    context.CancelFunc
+	cancelingSend bool
+
 	// filepicker is used to pick file attachments.
 	filepicker     filepicker.Model
 	loadingSpinner spinner.Model
@@ -82,11 +113,13 @@ type Model struct {
 	keymap         KeyMap
 	quitting       bool
 	abort          bool
+	receipt        *Receipt
+	queued         *OutboxItem
 	err            error
 }
 
 // NewModel returns a new model for the application.
-func NewModel(defaults resend.SendEmailRequest, deliveryMethod DeliveryMethod) Model {
+func NewModel(defaults resend.SendEmailRequest, sender Sender) Model {
 	from := textinput.New()
 	from.Prompt = "From "
 	from.Placeholder = "me@example.com"
@@ -101,6 +134,7 @@ func NewModel(defaults resend.SendEmailRequest, deliveryMethod DeliveryMethod) M
 	from.SetStyles(fromStyles)
 	from.SetVirtualCursor(false)
 	from.SetValue(defaults.From)
+	from.ShowSuggestions = true
 
 	to := textinput.New()
 	to.Prompt = "To "
@@ -215,6 +249,19 @@ func NewModel(defaults resend.SendEmailRequest, deliveryMethod DeliveryMethod) M
 		attachments.InsertItem(0, attachment(a.Filename))
 	}
 
+	sendAt := textinput.New()
+	sendAt.Prompt = "Send at "
+	sendAt.Placeholder = "tomorrow 9am"
+	sendAtStyles := textinput.DefaultDarkStyles()
+	sendAtStyles.Focused.Prompt = activeLabelStyle
+	sendAtStyles.Focused.Text = activeTextStyle
+	sendAtStyles.Focused.Placeholder = placeholderStyle
+	sendAtStyles.Cursor.Color = whiteColor
+	sendAt.SetStyles(sendAtStyles)
+	sendAt.SetVirtualCursor(false)
+	sendAt.ShowSuggestions = true
+	sendAt.SetSuggestions(sendAtExamples)
+
 	picker := filepicker.New()
 	picker.CurrentDirectory, _ = os.UserHomeDir()
 
@@ -232,11 +279,12 @@ func NewModel(defaults resend.SendEmailRequest, deliveryMethod DeliveryMethod) M
 		Subject:        subject,
 		Body:           body,
 		Attachments:    attachments,
+		SendAt:         sendAt,
 		filepicker:     picker,
 		help:           help.New(),
 		keymap:         DefaultKeybinds(),
 		loadingSpinner: loadingSpinner,
-		DeliveryMethod: deliveryMethod,
+		Sender:         sender,
 	}
 
 	m.focusActiveInput()
@@ -244,9 +292,214 @@ func NewModel(defaults resend.SendEmailRequest, deliveryMethod DeliveryMethod) M
 	return m
 }
 
+// accountSwitchedMsg reports the outcome of switching to another account.
+type accountSwitchedMsg struct {
+	name      string
+	from      string
+	signature string
+	sender    Sender
+	err       error
+}
+
+// switchAccount applies the named account and builds a sender for it. It
+// sets the flags the transports read, so it runs in Update rather than as a
+// command, and if the account can't be used the previous one is applied
+// again, so the flags keep matching the TUI's sender.
+func switchAccount(name, previous string) accountSwitchedMsg {
+	msg, err := useAccountSender(name)
+	if err != nil {
+		if _, _, restoreErr := useAccount(ordefault(previous, noAccount)); restoreErr != nil {
+			err = errors.Join(err, restoreErr)
+		}
+		return accountSwitchedMsg{err: err}
+	}
+	return msg
+}
+
+// useAccountSender applies the named account and builds a sender for it.
+func useAccountSender(name string) (accountSwitchedMsg, error) {
+	if _, _, err := useAccount(name); err != nil {
+		return accountSwitchedMsg{}, err
+	}
+	transport, err := pickTransport()
+	if err != nil {
+		return accountSwitchedMsg{}, fmt.Errorf("account %q: %w", name, err)
+	}
+	sender, err := transport.New()
+	if err != nil {
+		return accountSwitchedMsg{}, fmt.Errorf("account %q: %w", name, err)
+	}
+	sentFrom := from
+	if transport.Name == transportSMTP && sentFrom == "" {
+		sentFrom = smtpUsername
+	}
+	return accountSwitchedMsg{
+		name:      name,
+		from:      sentFrom,
+		signature: signature,
+		sender:    sender,
+	}, nil
+}
+
+// tokenRefreshMsg is sent when the sender's OAuth token is due a refresh.
+type tokenRefreshMsg struct {
+	gen int
+}
+
+// tokenRefreshedMsg reports the outcome of checking, and if need be
+// refreshing, the sender's OAuth token.
+type tokenRefreshedMsg struct {
+	gen       int
+	expiresAt time.Time
+	err       error
+}
+
+// reauthRequiredMsg is sent when a send failed because the user has to sign
+// in again. Nothing was sent.
+type reauthRequiredMsg struct {
+	login string
+}
+
+// reauthDoneMsg is sent once `pop auth` has run from the TUI.
+type reauthDoneMsg struct {
+	err error
+}
+
+// minTokenRefreshInterval keeps a token that's always about to expire, or a
+// provider that can't be reached, from being retried in a tight loop.
+const minTokenRefreshInterval = time.Minute
+
+// refreshTokenCmd makes sure the sender's stored OAuth token is valid,
+// refreshing it if it's about to expire, and reports when it expires. It
+// does nothing for senders that don't use a stored token.
+func refreshTokenCmd(sender Sender, gen int) tea.Cmd {
+	ts, ok := sender.(tokenSender)
+	if !ok {
+		return nil
+	}
+	return func() tea.Msg {
+		login := ts.tokenLogin()
+		if login == "" {
+			return nil
+		}
+		msg := tokenRefreshedMsg{gen: gen}
+		token, err := getValidAccessToken(login)
+		switch {
+		case err != nil:
+			msg.err = err
+		case token == "":
+			msg.err = &reauthError{login: login, err: errNoOAuthToken}
+		default:
+			stored, err := loadAuth(login)
+			if err != nil {
+				msg.err = err
+				break
+			}
+			msg.expiresAt = stored.ExpiresAt
+		}
+		return msg
+	}
+}
+
+// scheduleTokenRefresh refreshes the sender's OAuth token shortly before it
+// expires.
+func (m Model) scheduleTokenRefresh(expiresAt time.Time) tea.Cmd {
+	wait := max(time.Until(expiresAt.Add(-tokenRefreshRefresh)), minTokenRefreshInterval)
+	gen := m.tokenGen
+	return tea.Tick(wait, func(time.Time) tea.Msg {
+		return tokenRefreshMsg{gen: gen}
+	})
+}
+
+// reauthCmd runs `pop auth` for the given login, handing it the terminal
+// until it's done.
+func reauthCmd(login string) tea.Cmd {
+	exe, err := os.Executable()
+	if err != nil {
+		return func() tea.Msg {
+			return reauthDoneMsg{err: fmt.Errorf("finding pop: %w", err)}
+		}
+	}
+	return tea.ExecProcess(exec.Command(exe, authArgs(login)...), func(err error) tea.Msg { //nolint:gosec,noctx
+		return reauthDoneMsg{err: err}
+	})
+}
+
 // Init initializes the model.
 func (m Model) Init() tea.Cmd {
-	return nil
+	return tea.Batch(refreshTokenCmd(m.Sender, m.tokenGen), fetchDomainsCmd(m.Sender))
+}
+
+// domainsMsg carries the verified domains of a sender.
+type domainsMsg struct {
+	sender  Sender
+	domains []string
+}
+
+// fetchDomainsCmd fetches the sender's verified domains, if it has any. It
+// fails quietly: the completions are a convenience.
+func fetchDomainsCmd(sender Sender) tea.Cmd {
+	dl, ok := sender.(domainLister)
+	if !ok {
+		return nil
+	}
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		domains, err := dl.verifiedDomains(ctx, false)
+		if err != nil {
+			return nil
+		}
+		return domainsMsg{sender: sender, domains: domains}
+	}
+}
+
+// undoTickMsg counts down to sending the email.
+type undoTickMsg struct {
+	gen int
+}
+
+// undoTick ticks once a second, or sooner if the countdown ends sooner.
+func (m Model) undoTick() tea.Cmd {
+	gen := m.sendGen
+	return tea.Tick(min(time.Second, time.Until(m.sendDeadline)), func(time.Time) tea.Msg {
+		return undoTickMsg{gen: gen}
+	})
+}
+
+// send sends the email, after giving the user undoSend to undo it.
+func (m Model) send() (Model, tea.Cmd) {
+	m.blurInputs()
+	if m.undoSend > 0 {
+		m.state = waitingToSend
+		m.sendDeadline = time.Now().Add(m.undoSend)
+		m.sendGen++
+		m.updateKeymap()
+		return m, m.undoTick()
+	}
+	return m.startSending()
+}
+
+// startSending sends the email right away, with a context ctrl+c cancels.
+func (m Model) startSending() (Model, tea.Cmd) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelSend = cancel
+	m.cancelingSend = false
+	m.state = sendingEmail
+	m.updateKeymap()
+	return m, tea.Batch(
+		m.loadingSpinner.Tick,
+		m.sendEmailCmd(ctx),
+	)
+}
+
+// sendDone releases the context of the send that just finished.
+func (m *Model) sendDone() {
+	if m.cancelSend != nil {
+		m.cancelSend()
+		m.cancelSend = nil
+	}
+	m.cancelingSend = false
 }
 
 type clearErrMsg struct{}
@@ -261,20 +514,127 @@ func clearErrAfter(d time.Duration) tea.Cmd {
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case sendEmailSuccessMsg:
+		m.sendDone()
+		m.receipt = msg.receipt
+		m.queued = msg.queued
 		m.quitting = true
 		return m, tea.Quit
 	case sendEmailFailureMsg:
+		m.sendDone()
 		m.blurInputs()
 		m.state = editingFrom
 		m.focusActiveInput()
 		m.err = msg
 		return m, clearErrAfter(10 * time.Second)
+	case reauthRequiredMsg:
+		m.sendDone()
+		m.blurInputs()
+		m.state = hoveringSendButton
+		m.focusActiveInput()
+		m.reauth = msg.login
+		m.sendAfterAuth = true
+		m.updateKeymap()
+		return m, nil
+	case tokenRefreshMsg:
+		if msg.gen != m.tokenGen {
+			return m, nil
+		}
+		return m, refreshTokenCmd(m.Sender, m.tokenGen)
+	case tokenRefreshedMsg:
+		if msg.gen != m.tokenGen {
+			return m, nil
+		}
+		var reauth *reauthError
+		if errors.As(msg.err, &reauth) {
+			m.reauth = reauth.login
+			m.updateKeymap()
+			return m, nil
+		}
+		if msg.err != nil {
+			// Most likely a network hiccup: try again later, and let
+			// the send report the error if it's still failing then.
+			return m, m.scheduleTokenRefresh(time.Time{})
+		}
+		m.reauth = ""
+		m.updateKeymap()
+		var fetchDomains tea.Cmd
+		if m.domains == nil {
+			// Fetching them failed for want of a token.
+			fetchDomains = fetchDomainsCmd(m.Sender)
+		}
+		if m.sendAfterAuth {
+			m.sendAfterAuth = false
+			var send tea.Cmd
+			m, send = m.startSending()
+			return m, tea.Batch(
+				send,
+				m.scheduleTokenRefresh(msg.expiresAt),
+				fetchDomains,
+			)
+		}
+		return m, tea.Batch(m.scheduleTokenRefresh(msg.expiresAt), fetchDomains)
+	case domainsMsg:
+		if msg.sender != m.Sender {
+			return m, nil
+		}
+		m.domains = msg.domains
+		m.From.SetSuggestions(fromSuggestions(m.From.Value(), m.domains))
+		return m, nil
+	case reauthDoneMsg:
+		if msg.err != nil {
+			m.err = fmt.Errorf("signing in: %w", msg.err)
+			return m, clearErrAfter(10 * time.Second)
+		}
+		// Check whether the user signed in or gave up, and carry on
+		// with the send if they did.
+		m.tokenGen++
+		return m, refreshTokenCmd(m.Sender, m.tokenGen)
+	case accountSwitchedMsg:
+		if msg.err != nil {
+			m.err = msg.err
+			return m, clearErrAfter(10 * time.Second)
+		}
+		m.account = msg.name
+		m.Sender = msg.sender
+		m.domains = nil
+		m.From.SetSuggestions(nil)
+		m.tokenGen++
+		m.reauth = ""
+		m.sendAfterAuth = false
+		m.updateKeymap()
+		m.From.SetValue(msg.from)
+		if msg.signature != m.signature {
+			body := m.Body.Value()
+			if m.signature != "" {
+				body = strings.TrimSuffix(body, "\n\n"+m.signature)
+			}
+			if msg.signature != "" {
+				body += "\n\n" + msg.signature
+			}
+			m.Body.SetValue(body)
+			m.signature = msg.signature
+		}
+		return m, tea.Batch(refreshTokenCmd(m.Sender, m.tokenGen), fetchDomainsCmd(m.Sender))
+	case undoTickMsg:
+		if msg.gen != m.sendGen || m.state != waitingToSend {
+			return m, nil
+		}
+		if time.Now().Before(m.sendDeadline) {
+			return m, m.undoTick()
+		}
+		return m.startSending()
 	case clearErrMsg:
 		m.err = nil
 	case tea.WindowSizeMsg:
 		m.setCommonWidths(msg.Width)
 	case tea.KeyPressMsg:
 		switch {
+		case m.state == editingFrom && key.Matches(msg, m.From.KeyMap.AcceptSuggestion) && len(m.From.CurrentSuggestion()) > len(m.From.Value()):
+			// Let the From field complete the domain rather than
+			// moving on.
+		case m.state == pickingSchedule && key.Matches(msg, m.SendAt.KeyMap.AcceptSuggestion):
+			// Complete the suggested time; there's nowhere to move on
+			// to.
 		case key.Matches(msg, m.keymap.NextInput):
 			m.blurInputs()
 			switch m.state {
@@ -299,7 +659,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.state = hoveringSendButton
 			case hoveringSendButton:
 				m.state = editingFrom
-			case pickingFile, sendingEmail:
+			case pickingFile, pickingSchedule, waitingToSend, sendingEmail:
 			}
 			m.focusActiveInput()
 
@@ -326,26 +686,65 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.state = editingBody
 			case hoveringSendButton:
 				m.state = editingAttachments
-			case pickingFile, sendingEmail:
+			case pickingFile, pickingSchedule, waitingToSend, sendingEmail:
 			}
 			m.focusActiveInput()
 
 		case key.Matches(msg, m.keymap.Back):
-			m.state = editingAttachments
+			if m.state == pickingSchedule {
+				m.SendAt.Blur()
+				m.state = hoveringSendButton
+			} else {
+				m.state = editingAttachments
+			}
+			m.updateKeymap()
+			return m, nil
+		case key.Matches(msg, m.keymap.Schedule):
+			m.state = pickingSchedule
+			m.sendAtErr = nil
+			m.updateKeymap()
+			return m, m.SendAt.Focus()
+		case key.Matches(msg, m.keymap.SetSchedule):
+			if strings.TrimSpace(m.SendAt.Value()) == "" {
+				// Send right away after all.
+				m.sendAt = time.Time{}
+			} else {
+				t, err := parseSendAt(m.SendAt.Value(), time.Now())
+				if err != nil {
+					m.sendAtErr = err
+					return m, nil
+				}
+				m.sendAt = t
+			}
+			m.SendAt.Blur()
+			m.state = hoveringSendButton
 			m.updateKeymap()
 			return m, nil
 		case key.Matches(msg, m.keymap.Send):
-			m.state = sendingEmail
-			return m, tea.Batch(
-				m.loadingSpinner.Tick,
-				m.sendEmailCmd(),
-			)
+			return m.send()
+		case key.Matches(msg, m.keymap.Undo):
+			// Back to the editor, with nothing sent.
+			m.sendGen++
+			m.state = hoveringSendButton
+			m.updateKeymap()
+			return m, nil
 		case key.Matches(msg, m.keymap.Attach):
 			m.state = pickingFile
 			return m, m.filepicker.Init()
 		case key.Matches(msg, m.keymap.Unattach):
 			m.Attachments.RemoveItem(m.Attachments.Index())
 			m.Attachments.SetHeight(ordered.Max(len(m.Attachments.Items()), 1) + 2)
+		case key.Matches(msg, m.keymap.Reauth):
+			return m, reauthCmd(m.reauth)
+		case key.Matches(msg, m.keymap.Account):
+			i := slices.Index(m.accounts, m.account)
+			return m.Update(switchAccount(m.accounts[(i+1)%len(m.accounts)], m.account))
+		case key.Matches(msg, m.keymap.Quit) && m.state == sendingEmail && m.cancelSend != nil && !m.cancelingSend:
+			// Stop the send and wait to hear whether it went out; a
+			// second ctrl+c quits right away.
+			m.cancelSend()
+			m.cancelingSend = true
+			return m, nil
 		case key.Matches(msg, m.keymap.Quit):
 			m.quitting = true
 			m.abort = true
@@ -357,6 +756,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	m.From, cmd = m.From.Update(msg)
 	cmds = append(cmds, cmd)
+	if m.state == editingFrom && m.domains != nil {
+		m.From.SetSuggestions(fromSuggestions(m.From.Value(), m.domains))
+	}
 	m.To, cmd = m.To.Update(msg)
 	cmds = append(cmds, cmd)
 	if m.showCc {
@@ -371,6 +773,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	cmds = append(cmds, cmd)
 	m.filepicker, cmd = m.filepicker.Update(msg)
 	cmds = append(cmds, cmd)
+	if m.state == pickingSchedule {
+		m.SendAt, cmd = m.SendAt.Update(msg)
+		cmds = append(cmds, cmd)
+		m.sendAtErr = nil
+		if m.SendAt.Value() != "" {
+			_, m.sendAtErr = parseSendAt(m.SendAt.Value(), time.Now())
+		}
+	}
 
 	switch m.state {
 	case pickingFile:
@@ -386,7 +796,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case sendingEmail:
 		m.loadingSpinner, cmd = m.loadingSpinner.Update(msg)
 		cmds = append(cmds, cmd)
-	case editingFrom, editingTo, editingCc, editingBcc, editingSubject, editingBody, hoveringSendButton:
+	case editingFrom, editingTo, editingCc, editingBcc, editingSubject, editingBody, hoveringSendButton, pickingSchedule, waitingToSend:
 	}
 
 	m.updateKeymap()
@@ -445,7 +855,7 @@ func (m *Model) focusActiveInput() {
 	case editingAttachments:
 		m.Attachments.Styles.Title = attachmentsTitleActiveStyle
 		m.Attachments.SetDelegate(attachmentDelegate{true})
-	case hoveringSendButton, pickingFile, sendingEmail:
+	case hoveringSendButton, pickingFile, pickingSchedule, waitingToSend, sendingEmail:
 	}
 }
 
@@ -459,8 +869,25 @@ func (m Model) View() tea.View {
 	case pickingFile:
 		return tea.NewView("\n" + activeLabelStyle.Render("Attachments") + " " + commentStyle.Render(m.filepicker.CurrentDirectory) +
 			"\n\n" + m.filepicker.View())
+	case pickingSchedule:
+		return m.scheduleView()
+	case waitingToSend:
+		verb := "Sending"
+		if !m.sendAt.IsZero() {
+			verb = "Scheduling"
+		}
+		left := max(time.Until(m.sendDeadline).Round(time.Second), 0)
+		return tea.NewView("\n " + activeLabelStyle.Render(fmt.Sprintf("%s in %s…", verb, left)) +
+			commentStyle.Render(`"`+m.Subject.Value()+`" to `+m.To.Value()) + "\n\n " + m.help.View(m.keymap))
 	case sendingEmail:
-		return tea.NewView("\n " + m.loadingSpinner.View() + "Sending email")
+		if m.cancelingSend {
+			return tea.NewView("\n " + m.loadingSpinner.View() + "Canceling…" + commentStyle.Render("ctrl+c to quit anyway"))
+		}
+		verb := "Sending"
+		if !m.sendAt.IsZero() {
+			verb = "Scheduling"
+		}
+		return tea.NewView("\n " + m.loadingSpinner.View() + verb + " email" + commentStyle.Render("ctrl+c to cancel"))
 	case editingFrom, editingTo, editingCc, editingBcc, editingSubject, editingBody, editingAttachments, hoveringSendButton:
 	}
 
@@ -491,9 +918,20 @@ func (m Model) View() tea.View {
 	} else {
 		s.WriteString(sendButtonStyle.Render("Send"))
 	}
+	if !m.sendAt.IsZero() {
+		s.WriteString(commentStyle.Render("at " + m.sendAt.Format("Mon 2 Jan 15:04")))
+	}
+	if m.account != "" {
+		s.WriteString(commentStyle.Render(m.account))
+	}
 	s.WriteString("\n\n")
 	s.WriteString(m.help.View(m.keymap))
 
+	if m.reauth != "" {
+		s.WriteString("\n\n")
+		s.WriteString(errorStyle.Render("You need to sign in to " + loginLabel(m.reauth) + " again. Press " + m.keymap.Reauth.Help().Key + " to sign in."))
+	}
+
 	if m.err != nil {
 		s.WriteString("\n\n")
 		s.WriteString(errorStyle.Render(m.err.Error()))
@@ -541,9 +979,32 @@ func (m Model) View() tea.View {
 			c.X += padX
 			v.Cursor = c
 		}
-	case editingAttachments, hoveringSendButton, pickingFile, sendingEmail:
+	case editingAttachments, hoveringSendButton, pickingFile, pickingSchedule, waitingToSend, sendingEmail:
 		// No cursor positioning needed for these states.
 	}
 
 	return v
 }
+
+// scheduleView displays the schedule picker, with when the time typed in
+// would send the email.
+func (m Model) scheduleView() tea.View {
+	var s strings.Builder
+	s.WriteString("\n" + activeLabelStyle.Render("Schedule") + commentStyle.Render("when to send the email, empty to send right away") + "\n\n")
+	s.WriteString(m.SendAt.View() + "\n\n")
+	switch {
+	case m.sendAtErr != nil:
+		s.WriteString(errorStyle.Render(m.sendAtErr.Error()))
+	case m.SendAt.Value() != "":
+		t, _ := parseSendAt(m.SendAt.Value(), time.Now())
+		s.WriteString(textStyle.Render(t.Format("Monday 2 January 2006 15:04")))
+	}
+	s.WriteString("\n\n" + m.help.View(m.keymap))
+
+	v := tea.NewView(s.String())
+	if c := m.SendAt.Cursor(); c != nil {
+		c.Y += 3
+		v.Cursor = c
+	}
+	return v
+}
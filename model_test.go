@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSwitchAccountFailure(t *testing.T) {
+	useTestDirs(t)
+	t.Setenv(ResendAPIKey, "")
+	t.Setenv(PopSMTPProvider, "")
+	useAccounts(t, `default = "good"
+
+[accounts.good]
+method = "resend"
+[accounts.good.resend]
+api_key = "re_good"
+
+[accounts.broken]
+method = "smtp"
+[accounts.broken.smtp]
+provider = "no-such-provider"
+`)
+	if _, _, err := useAccount("good"); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := switchAccount("broken", "good")
+	if msg.err == nil || msg.sender != nil {
+		t.Fatalf("switchAccount = %+v, want an error", msg)
+	}
+	// The TUI keeps the good account's sender, so the settings must match
+	// it again.
+	if resendAPIKey != "re_good" || smtpProvider != "" {
+		t.Errorf("after the failed switch, resend.key = %q and smtp.provider = %q, want the good account's", resendAPIKey, smtpProvider)
+	}
+
+	msg = switchAccount("good", "broken")
+	if msg.err != nil || msg.name != "good" || senderTransport(msg.sender) != transportResend {
+		t.Errorf("switchAccount(good) = %+v, want the Resend account", msg)
+	}
+}
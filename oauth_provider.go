@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Names of the OAuth providers pop can authenticate with.
+const (
+	providerResend    = "resend"
+	providerGoogle    = "google"
+	providerMicrosoft = "microsoft"
+)
+
+// oauthProviderNames are the known OAuth providers, in the order they're
+// listed to the user.
+var oauthProviderNames = []string{providerResend, providerGoogle, providerMicrosoft}
+
+// oauthProvider is an OAuth 2.0 authorization server pop obtains tokens from
+// with the authorization code flow and PKCE.
+type oauthProvider struct {
+	// Name identifies the provider and its token file, e.g. "google".
+	Name string
+	// Label is the provider's display name.
+	Label string
+
+	ClientID string
+	// ClientSecret is only sent when set. Google issues secrets to
+	// desktop apps even though they can't be kept confidential.
+	ClientSecret string
+
+	AuthURL  string
+	TokenURL string
+	// RevokeURL is empty when the provider has no revocation endpoint.
+	RevokeURL string
+	// DeviceAuthURL is the RFC 8628 device authorization endpoint, empty
+	// when the provider doesn't support the device flow.
+	DeviceAuthURL string
+
+	Scopes []string
+	// AuthParams are extra parameters for the authorization request.
+	AuthParams map[string]string
+}
+
+// lookupOAuthProvider returns the named provider. Every endpoint and the
+// client credentials can be overridden with POP_<NAME>_AUTH_URL,
+// POP_<NAME>_TOKEN_URL, POP_<NAME>_REVOKE_URL, POP_<NAME>_DEVICE_URL,
+// POP_<NAME>_CLIENT_ID and POP_<NAME>_CLIENT_SECRET, e.g. to test against a
+// local authorization server.
+func lookupOAuthProvider(name string) (*oauthProvider, error) {
+	var p *oauthProvider
+	switch strings.ToLower(name) {
+	case providerResend, "":
+		base := strings.TrimSuffix(resendBaseURL(), "/")
+		p = &oauthProvider{
+			Name:      providerResend,
+			Label:     "Resend",
+			ClientID:  oauthClientID,
+			AuthURL:   base + "/oauth/authorize",
+			TokenURL:  base + "/oauth/token",
+			RevokeURL: base + "/oauth/revoke",
+			// Reading domains lets pop check the From address
+			// before sending.
+			Scopes: []string{oauthScope, oauthScopeDomains},
+		}
+	case providerGoogle:
+		p = &oauthProvider{
+			Name:      providerGoogle,
+			Label:     "Google",
+			AuthURL:   "https://accounts.google.com/o/oauth2/v2/auth",
+			TokenURL:  "https://oauth2.googleapis.com/token",
+			RevokeURL: "https://oauth2.googleapis.com/revoke",
+			// Google only allows a limited set of scopes with the
+			// device flow, for "TVs and Limited Input devices" clients.
+			DeviceAuthURL: "https://oauth2.googleapis.com/device/code",
+			Scopes:        []string{"https://mail.google.com/"},
+			// Without these Google only issues a refresh token the
+			// first time the user consents.
+			AuthParams: map[string]string{"access_type": "offline", "prompt": "consent"},
+		}
+	case providerMicrosoft:
+		tenant := os.Getenv("POP_MICROSOFT_TENANT")
+		if tenant == "" {
+			tenant = "common"
+		}
+		base := "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0"
+		p = &oauthProvider{
+			Name:          providerMicrosoft,
+			Label:         "Microsoft",
+			AuthURL:       base + "/authorize",
+			TokenURL:      base + "/token",
+			DeviceAuthURL: base + "/devicecode",
+			Scopes:        []string{"https://outlook.office.com/SMTP.Send", "offline_access"},
+		}
+	default:
+		return nil, fmt.Errorf("unknown OAuth provider %q (expected one of %s)", name, strings.Join(oauthProviderNames, ", "))
+	}
+
+	prefix := "POP_" + strings.ToUpper(p.Name) + "_"
+	for env, field := range map[string]*string{
+		"CLIENT_ID":     &p.ClientID,
+		"CLIENT_SECRET": &p.ClientSecret,
+		"AUTH_URL":      &p.AuthURL,
+		"TOKEN_URL":     &p.TokenURL,
+		"REVOKE_URL":    &p.RevokeURL,
+		"DEVICE_URL":    &p.DeviceAuthURL,
+	} {
+		if v := os.Getenv(prefix + env); v != "" {
+			*field = v
+		}
+	}
+	return p, nil
+}
+
+// validate reports whether the provider has everything needed to start an
+// authorization flow.
+func (p *oauthProvider) validate() error {
+	if p.ClientID == "" {
+		return fmt.Errorf("no %s OAuth client ID: register a desktop app with %s and set $POP_%s_CLIENT_ID", p.Label, p.Label, strings.ToUpper(p.Name))
+	}
+	return nil
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outbox retry schedule: the delay doubles with every failed attempt, from
+// outboxBaseDelay up to outboxMaxDelay, unless the server says how long to
+// wait. After outboxMaxAttempts the message is held until it's flushed by
+// hand.
+const (
+	outboxBaseDelay   = time.Minute
+	outboxMaxDelay    = time.Hour
+	outboxMaxAttempts = 10
+)
+
+// errQueued is returned by a send that failed for a reason that may pass, and
+// was queued in the outbox: the email hasn't gone out yet.
+var errQueued = errors.New("the email couldn't be sent yet and is in the outbox")
+
+// OutboxItem is a message waiting in the outbox to be sent again, or to be
+// sent for the first time at a scheduled time.
+type OutboxItem struct {
+	// ID is also the ID of the email in the sent history.
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Account   string    `json:"account,omitempty"`
+	Transport string    `json:"transport"`
+	// Message is the message to send. Its attachments are copies kept in
+	// the outbox, so later changes to the originals don't matter.
+	Message Message `json:"message"`
+	// SendAt is when the message was scheduled to be sent, if it was.
+	SendAt      time.Time `json:"send_at,omitzero"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error"`
+	// Held is set once a message isn't retried automatically any more:
+	// after a permanent failure, or too many attempts.
+	Held bool `json:"held,omitempty"`
+}
+
+// delivery is the outcome of deliver.
+type delivery struct {
+	receipt *Receipt
+	// entry is the email in the sent history, if the history is on.
+	entry *HistoryEntry
+	// queued is set when the send failed for a reason that may pass, and
+	// the message was put in the outbox to try again later, or when it's
+	// scheduled for later and the sender can't schedule it itself.
+	queued *OutboxItem
+}
+
+// deliver sends msg with the sender and records it in the history. If the
+// send fails for a reason that may pass, like a network error, a 429 or a
+// 4xx SMTP reply, the message is queued in the outbox instead, and no error
+// is returned. A message with a SendAt is scheduled with the provider when
+// the sender can do that, and put in the outbox until then otherwise.
+// Canceling ctx stops the send; if nothing was sent by then, nothing is
+// recorded either.
+func deliver(ctx context.Context, account string, sender Sender, msg Message) (delivery, error) {
+	var receipt *Receipt
+	err := checkSuppressions(msg)
+	if _, ok := sender.(scheduler); err == nil && !msg.SendAt.IsZero() && !ok {
+		item, err := schedule(account, sender, msg)
+		return delivery{queued: item}, err
+	}
+	if err == nil {
+		receipt, err = sender.Send(ctx, msg)
+	}
+	return settle(account, sender, msg, receipt, err)
+}
+
+// deliverBatch delivers the messages like deliver, but sends them together
+// when the sender is a batchSender. It returns the delivery and error of
+// each message, in the same order.
+func deliverBatch(ctx context.Context, account string, sender Sender, msgs []Message) ([]delivery, []error) {
+	deliveries := make([]delivery, len(msgs))
+	errs := make([]error, len(msgs))
+	var pending []int
+	for i, msg := range msgs {
+		if err := checkSuppressions(msg); err != nil {
+			deliveries[i], errs[i] = settle(account, sender, msg, nil, err)
+			continue
+		}
+		if _, ok := sender.(scheduler); !msg.SendAt.IsZero() && !ok {
+			item, err := schedule(account, sender, msg)
+			deliveries[i], errs[i] = delivery{queued: item}, err
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if len(pending) == 0 {
+		return deliveries, errs
+	}
+
+	batch := make([]Message, len(pending))
+	for n, i := range pending {
+		batch[n] = msgs[i]
+	}
+	var (
+		receipts []*Receipt
+		sendErrs []error
+	)
+	if b, ok := sender.(batchSender); ok {
+		receipts, sendErrs = b.SendBatch(ctx, batch)
+	} else {
+		receipts = make([]*Receipt, len(batch))
+		sendErrs = make([]error, len(batch))
+		for n, msg := range batch {
+			receipts[n], sendErrs[n] = sender.Send(ctx, msg)
+		}
+	}
+	for n, i := range pending {
+		deliveries[i], errs[i] = settle(account, sender, msgs[i], receipts[n], sendErrs[n])
+	}
+	return deliveries, errs
+}
+
+// settle records the outcome of sending msg in the history, or queues the
+// message in the outbox if the send failed for a reason that may pass.
+func settle(account string, sender Sender, msg Message, receipt *Receipt, err error) (delivery, error) {
+	var reauth *reauthError
+	if errors.As(err, &reauth) {
+		// Nothing went out, and it won't until the user signs in again.
+		return delivery{}, err
+	}
+	if canceledUnsent(err) {
+		return delivery{}, err
+	}
+	if err != nil && retryable(err) {
+		item, queueErr := enqueue(account, sender, msg, err)
+		if queueErr == nil {
+			return delivery{queued: item}, nil
+		}
+		logVerbose("Couldn't queue the email: %v", queueErr)
+	}
+	return delivery{receipt: receipt, entry: recordSend(account, sender, msg, receipt, err)}, err
+}
+
+// retryable reports whether a failed send may go through if tried again
+// later: the network or server was unavailable, the provider is rate
+// limiting, or the SMTP server replied with a temporary (4xx) error.
+func retryable(err error) bool {
+	var interrupted *interruptedSendError
+	if errors.As(err, &interrupted) {
+		// Trying again could send the message twice, and the user
+		// doesn't want it sent if they canceled.
+		return !interrupted.mayHaveSent && !errors.Is(err, context.Canceled)
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		code := httpErr.StatusCode()
+		return code == 429 || code >= 500 //nolint:mnd
+	}
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code >= 400 && smtpErr.Code < 500
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		// A host that doesn't exist won't come back, unlike a DNS server
+		// that's down.
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// canceledUnsent reports whether err is a send the user canceled before the
+// message was handed over.
+func canceledUnsent(err error) bool {
+	var interrupted *interruptedSendError
+	return errors.As(err, &interrupted) && !interrupted.mayHaveSent && errors.Is(err, context.Canceled)
+}
+
+// retryDelay returns how long to wait before the given attempt, after a
+// failure with err.
+func retryDelay(attempts int, err error) time.Duration {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		return httpErr.RetryAfter
+	}
+	delay := outboxBaseDelay
+	for i := 1; i < attempts && delay < outboxMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxDelay)
+}
+
+// outboxDir returns the directory of the outbox, creating it if needed.
+func outboxDir() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "outbox")
+	if err := os.MkdirAll(dir, 0o700); err != nil { //nolint:gosec // G703: dir is from a trusted source
+		return "", fmt.Errorf("creating outbox directory: %w", err)
+	}
+	return dir, nil
+}
+
+// enqueue puts msg in the outbox after a failed first attempt, and records
+// it in the history as queued.
+func enqueue(account string, sender Sender, msg Message, sendErr error) (*OutboxItem, error) {
+	return addToOutbox(account, sender, msg, func(item *OutboxItem, e *HistoryEntry) {
+		item.failed(sendErr)
+		e.setOutcome(nil, sendErr)
+		e.Status = historyQueued
+	})
+}
+
+// schedule puts msg in the outbox until its SendAt, and records it in the
+// history as scheduled.
+func schedule(account string, sender Sender, msg Message) (*OutboxItem, error) {
+	return addToOutbox(account, sender, msg, func(item *OutboxItem, e *HistoryEntry) {
+		item.SendAt = msg.SendAt.UTC()
+		item.NextAttempt = item.SendAt
+		// It's pop that waits, not the provider.
+		item.Message.SendAt = time.Time{}
+		e.Status = historyScheduled
+	})
+}
+
+// addToOutbox puts msg in the outbox, copying its attachments, and adds it to
+// the history. prepare fills in the item and history entry.
+func addToOutbox(account string, sender Sender, msg Message, prepare func(*OutboxItem, *HistoryEntry)) (*OutboxItem, error) {
+	dir, err := outboxDir()
+	if err != nil {
+		return nil, err
+	}
+	entry := newHistoryEntry(account, sender, msg)
+	item := &OutboxItem{
+		ID:        entry.ID,
+		CreatedAt: entry.CreatedAt,
+		Account:   entry.Account,
+		Transport: entry.Transport,
+		Message:   msg,
+	}
+
+	item.Message.Attachments = make([]string, len(msg.Attachments))
+	for i, a := range msg.Attachments {
+		copied := filepath.Join(dir, item.ID, strconv.Itoa(i), filepath.Base(a))
+		if err := copyFile(a, copied); err != nil {
+			_ = os.RemoveAll(filepath.Join(dir, item.ID))
+			return nil, fmt.Errorf("copying attachment: %w", err)
+		}
+		item.Message.Attachments[i] = copied
+	}
+	prepare(item, entry)
+	if err := saveOutboxItem(item); err != nil {
+		_ = os.RemoveAll(filepath.Join(dir, item.ID))
+		return nil, err
+	}
+
+	if historyEnabled() {
+		if err := saveHistoryEntry(entry); err != nil {
+			logVerbose("Couldn't add the email to the history: %v", err)
+		}
+	}
+	return item, nil
+}
+
+// failed records a failed attempt to send the item and schedules the next.
+func (item *OutboxItem) failed(err error) {
+	item.Attempts++
+	item.LastError = err.Error()
+	item.NextAttempt = time.Now().Add(retryDelay(item.Attempts, err)).UTC()
+	item.Held = !retryable(err) || item.Attempts >= outboxMaxAttempts
+}
+
+// scheduled reports whether the item is waiting for its scheduled time,
+// rather than to be retried.
+func (item *OutboxItem) scheduled() bool {
+	return !item.SendAt.IsZero() && item.Attempts == 0
+}
+
+// due reports whether the item should be sent, or retried, by now.
+func (item *OutboxItem) due(now time.Time) bool {
+	return !item.Held && !now.Before(item.NextAttempt)
+}
+
+// copyFile copies the file at src to dst, creating dst's directory.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src) //nolint:gosec // G304: the user picked the file
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer func() { _ = in.Close() }()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err //nolint:wrapcheck
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600) //nolint:gosec // G304: dst is in the outbox
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err //nolint:wrapcheck
+	}
+	return out.Close() //nolint:wrapcheck
+}
+
+// saveOutboxItem writes the item to the outbox.
+func saveOutboxItem(item *OutboxItem) error {
+	dir, err := outboxDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding outbox item: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, item.ID+".json"), data); err != nil {
+		return fmt.Errorf("writing outbox item: %w", err)
+	}
+	return nil
+}
+
+// removeOutboxItem removes the item and its attachments from the outbox.
+func removeOutboxItem(item *OutboxItem) error {
+	dir, err := outboxDir()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, item.ID+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing outbox item: %w", err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, item.ID)); err != nil {
+		return fmt.Errorf("removing outbox attachments: %w", err)
+	}
+	return nil
+}
+
+// loadOutbox returns the messages in the outbox, oldest first.
+func loadOutbox() ([]*OutboxItem, error) {
+	dir, err := outboxDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("listing outbox: %w", err)
+	}
+	items := make([]*OutboxItem, 0, len(files))
+	for _, f := range files {
+		data, err := os.ReadFile(f) //nolint:gosec // G304: f is in the outbox directory
+		if err != nil {
+			return nil, fmt.Errorf("reading outbox: %w", err)
+		}
+		var item OutboxItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", f, err)
+		}
+		items = append(items, &item)
+	}
+	slices.SortFunc(items, func(a, b *OutboxItem) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return items, nil
+}
+
+// selectOutboxItems returns the items in the outbox with the given IDs, which
+// may be shortened while they're unambiguous.
+func selectOutboxItems(items []*OutboxItem, ids []string) ([]*OutboxItem, error) {
+	selected := make([]*OutboxItem, 0, len(ids))
+	for _, id := range ids {
+		var found *OutboxItem
+		for _, item := range items {
+			if item.ID == id {
+				found = item
+				break
+			}
+			if strings.HasPrefix(item.ID, id) {
+				if found != nil {
+					return nil, fmt.Errorf("%q matches more than one message in the outbox", id)
+				}
+				found = item
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("no message %q in the outbox", id)
+		}
+		selected = append(selected, found)
+	}
+	return selected, nil
+}
+
+// lockOutbox takes an exclusive lock on the outbox, so two flushes don't
+// send the same message twice, and returns a function that releases it.
+func lockOutbox() (func(), error) {
+	dir, err := outboxDir()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "outbox.lock"), os.O_RDWR|os.O_CREATE, 0o600) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("opening outbox lock: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("locking outbox: %w", err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
+
+// sendOutboxItem tries to send the item again. On success it's removed from
+// the outbox; otherwise the failure is recorded and the next attempt
+// scheduled. The history follows along either way, unless ctx is canceled
+// before the message is handed over, or the account needs signing in again,
+// which leave the item as it was.
+func sendOutboxItem(ctx context.Context, item *OutboxItem) (*Receipt, error) {
+	sender, _, err := accountSender(item.Account)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSuppressions(item.Message)
+	var receipt *Receipt
+	if err == nil {
+		receipt, err = sender.Send(ctx, item.Message)
+	}
+	var reauth *reauthError
+	if canceledUnsent(err) || errors.As(err, &reauth) {
+		// Nothing went out, and the item waits as it was.
+		return nil, err
+	}
+	if err != nil {
+		item.failed(err)
+		updateHistoryEntry(item.ID, func(e *HistoryEntry) {
+			e.Error = err.Error()
+			e.Status = historyQueued
+			if item.Held {
+				e.Status = historyFailed
+			}
+		})
+		if saveErr := saveOutboxItem(item); saveErr != nil {
+			return nil, errors.Join(err, saveErr)
+		}
+		return nil, err
+	}
+	updateHistoryEntry(item.ID, func(e *HistoryEntry) {
+		e.setOutcome(receipt, nil)
+	})
+	return receipt, removeOutboxItem(item)
+}
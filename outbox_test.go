@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", &HTTPError{Status: "429 Too Many Requests", RetryAfter: 30 * time.Second}, true},
+		{"server error", &HTTPError{Status: "500 Internal Server Error"}, true},
+		{"bad gateway, wrapped", fmt.Errorf("sending email: %w", &HTTPError{Status: "502 Bad Gateway"}), true},
+		{"validation error", &HTTPError{Status: "422 Unprocessable Entity"}, false},
+		{"bad key", &HTTPError{Status: "401 Unauthorized"}, false},
+		{"SMTP temporary failure", &textproto.Error{Code: 451, Msg: "4.7.1 Try again later"}, true},
+		{"SMTP mailbox busy", &textproto.Error{Code: 450, Msg: "4.2.1 Mailbox busy"}, true},
+		{"SMTP permanent failure", &textproto.Error{Code: 550, Msg: "5.1.1 No such user"}, false},
+		{"SMTP auth failure", &textproto.Error{Code: 535, Msg: "5.7.8 Bad credentials"}, false},
+		{"unknown host", &net.DNSError{Err: "no such host", Name: "smtp.example.invalid", IsNotFound: true}, false},
+		{"DNS server down", &net.DNSError{Err: "server misbehaving", Name: "smtp.example.com", IsTemporary: true}, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"connection dropped", io.ErrUnexpectedEOF, true},
+		{"timed out before sending", &interruptedSendError{err: context.DeadlineExceeded}, true},
+		{"timed out after sending", &interruptedSendError{err: context.DeadlineExceeded, mayHaveSent: true}, false},
+		{"canceled before sending", &interruptedSendError{err: context.Canceled}, false},
+		{"lost the connection after sending", &interruptedSendError{err: io.EOF, mayHaveSent: true}, false},
+		{"suppressed", errors.New("ada@example.com is on the suppression list"), false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("%s: retryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		err      error
+		want     time.Duration
+	}{
+		{1, io.EOF, time.Minute},
+		{2, io.EOF, 2 * time.Minute},
+		{3, io.EOF, 4 * time.Minute},
+		{6, io.EOF, 32 * time.Minute},
+		{7, io.EOF, time.Hour},
+		{10, io.EOF, time.Hour},
+		{1000, io.EOF, time.Hour},
+		{1, &HTTPError{Status: "429 Too Many Requests", RetryAfter: 90 * time.Second}, 90 * time.Second},
+		{9, &HTTPError{Status: "429 Too Many Requests", RetryAfter: 3 * time.Hour}, 3 * time.Hour},
+		{3, &HTTPError{Status: "503 Service Unavailable"}, 4 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts, tt.err); got != tt.want {
+			t.Errorf("retryDelay(%d, %v) = %s, want %s", tt.attempts, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxItemFailed(t *testing.T) {
+	temporary := &textproto.Error{Code: 421, Msg: "4.3.2 Service not available"}
+
+	item := &OutboxItem{}
+	for attempt := 1; attempt < outboxMaxAttempts; attempt++ {
+		before := time.Now()
+		item.failed(temporary)
+		if item.Held {
+			t.Fatalf("held after attempt %d, want it retried", attempt)
+		}
+		if wait := item.NextAttempt.Sub(before); wait < retryDelay(attempt, temporary) {
+			t.Errorf("attempt %d retried after %s, want %s", attempt, wait, retryDelay(attempt, temporary))
+		}
+	}
+	item.failed(temporary)
+	if !item.Held || item.Attempts != outboxMaxAttempts {
+		t.Errorf("after %d attempts held = %v, want the item held", item.Attempts, item.Held)
+	}
+	if item.LastError != temporary.Error() {
+		t.Errorf("last error = %q, want %q", item.LastError, temporary.Error())
+	}
+
+	permanent := &OutboxItem{}
+	permanent.failed(&textproto.Error{Code: 550, Msg: "5.1.1 No such user"})
+	if !permanent.Held {
+		t.Error("a permanent failure wasn't held")
+	}
+}
+
+func TestFlushOutbox(t *testing.T) {
+	useTestDirs(t)
+	stub := startSMTPStub(t, &smtpStub{})
+	useAccounts(t, fmt.Sprintf(`[accounts.plain]
+method = "smtp"
+[accounts.plain.smtp]
+host = %q
+port = %d
+encryption = "none"
+
+[accounts.signed-out]
+method = "resend-oauth"
+`, stub.host, stub.port))
+
+	queue := func(account, subject string) *OutboxItem {
+		t.Helper()
+		item := &OutboxItem{
+			ID:          newHistoryID(),
+			CreatedAt:   time.Now().UTC(),
+			Account:     account,
+			Transport:   transportSMTP,
+			Message:     Message{From: "me@example.com", To: []string{"ada@example.com"}, Subject: subject, Body: "Hello"},
+			Attempts:    1,
+			NextAttempt: time.Now().Add(-time.Minute).UTC(),
+			LastError:   "421 4.3.2 Service not available",
+		}
+		if err := saveOutboxItem(item); err != nil {
+			t.Fatal(err)
+		}
+		return item
+	}
+	outboxIDs := func() map[string]*OutboxItem {
+		t.Helper()
+		items, err := loadOutbox()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := map[string]*OutboxItem{}
+		for _, item := range items {
+			ids[item.ID] = item
+		}
+		return ids
+	}
+	w := colorprofile.NewWriter(io.Discard, os.Environ())
+
+	t.Run("flush stops at a signed-out account", func(t *testing.T) {
+		signedOut := queue("signed-out", "Stuck")
+		_, _, err := flushOutbox(context.Background(), w, nil, false)
+		var reauth *reauthError
+		if !errors.As(err, &reauth) {
+			t.Fatalf("flushOutbox error = %v, want one asking to sign in again", err)
+		}
+		if got := outboxIDs()[signedOut.ID]; got == nil || got.Held || got.Attempts != 1 {
+			t.Errorf("signed-out account's email = %+v, want it left as it was", got)
+		}
+		if err := removeOutboxItem(signedOut); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("run carries on past a signed-out account", func(t *testing.T) {
+		old := queueOpts.run
+		t.Cleanup(func() { queueOpts.run = old })
+		queueOpts.run = true
+
+		first := queue("signed-out", "Stuck")
+		second := queue("signed-out", "Also stuck")
+		sent := queue("plain", "Goes out")
+		later := queue("plain", "Later")
+		later.NextAttempt = time.Now().Add(time.Hour).UTC()
+		if err := saveOutboxItem(later); err != nil {
+			t.Fatal(err)
+		}
+
+		next, failed, err := flushOutbox(context.Background(), w, nil, false)
+		if err != nil {
+			t.Fatalf("flushOutbox: %v", err)
+		}
+		if failed != 0 {
+			t.Errorf("%d emails failed, want none", failed)
+		}
+		if !next.Equal(later.NextAttempt) {
+			t.Errorf("next attempt at %s, want %s", next, later.NextAttempt)
+		}
+		left := outboxIDs()
+		if left[sent.ID] != nil {
+			t.Error("the email due for the SMTP account wasn't sent")
+		}
+		for _, item := range []*OutboxItem{first, second} {
+			if got := left[item.ID]; got == nil || got.Held || got.Attempts != 1 {
+				t.Errorf("signed-out account's email %s = %+v, want it left for a later pass", item.Message.Subject, got)
+			}
+		}
+		if left[later.ID] == nil {
+			t.Error("the email that isn't due yet was sent")
+		}
+	})
+}
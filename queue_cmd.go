@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/spf13/cobra"
+)
+
+var queueOpts struct {
+	json bool
+	all  bool
+	wait bool
+	run  bool
+}
+
+// QueueCmd is the cobra command that lists the outbox.
+var QueueCmd = &cobra.Command{
+	Use:     "queue",
+	Aliases: []string{"outbox"},
+	Short:   "List emails waiting in the outbox",
+	Long: `Lists the emails waiting in the outbox, oldest first.
+
+An email goes to the outbox when sending it fails for a reason that may pass:
+the network or server is down, the provider is rate limiting (429), or the SMTP
+server replied with a temporary (4xx) error. pop queue flush retries it, waiting
+a minute after the first failure and twice as long after each one after that,
+up to an hour, or as long as the provider asks with Retry-After. After ` + strconv.Itoa(outboxMaxAttempts) + `
+attempts, or a permanent error, the email is held until it's flushed by ID.
+
+Emails scheduled with --send-at wait here too, when the provider can't hold on
+to them itself, until pop queue run sends them.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		items, err := loadOutbox()
+		if err != nil {
+			return err
+		}
+		if queueOpts.json {
+			return printJSON(items)
+		}
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		if len(items) == 0 {
+			_, _ = fmt.Fprintf(w, "\n  %s\n\n", placeholderStyle.Render("The outbox is empty."))
+			return nil
+		}
+		for _, item := range items {
+			_, _ = fmt.Fprintln(w, outboxLine(item))
+		}
+		return nil
+	},
+}
+
+// QueueFlushCmd is the cobra command that retries emails in the outbox.
+var QueueFlushCmd = &cobra.Command{
+	Use:   "flush [id...]",
+	Short: "Retry the emails in the outbox that are due",
+	Long: `Tries again to send the emails in the outbox whose next attempt is due, with
+the account each was queued with. Given IDs, or with --all, those emails are
+tried right away, held ones included. --all leaves scheduled emails until their
+time; give their IDs to send them early.
+
+With --wait, pop keeps running until the outbox is empty or only has held
+emails left, sending each email when it's due.`,
+	ValidArgsFunction: completeOutboxIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		if queueOpts.all && len(args) > 0 {
+			return errors.New("give either IDs or --all")
+		}
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		force := queueOpts.all || len(args) > 0
+		var failed int
+		for {
+			next, n, err := flushOutbox(ctx, w, args, force)
+			if err != nil {
+				return err
+			}
+			failed += n
+			if !queueOpts.wait || next.IsZero() {
+				break
+			}
+			// Held emails aren't retried on their own, so only the first
+			// round forces anything.
+			args, force = nil, false
+			_, _ = fmt.Fprintln(w, commentStyle.Render("Next attempt at "+next.Local().Format(time.TimeOnly)+"…"))
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Until(next)):
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d email(s) couldn't be sent; they're still in the outbox", failed)
+		}
+		return nil
+	},
+}
+
+// flushOutbox sends the emails in the outbox that are due, or the ones with
+// the given IDs, or all of them if force is set. It returns when the next
+// email that isn't held is due, or zero if there's none, and how many emails
+// failed to send. Canceling ctx stops it, leaving the email being sent, if
+// it wasn't handed over yet, and the rest as they were.
+//
+// An account whose login needs signing in again stops the flush, except
+// under pop queue run, where the account's emails are left for the next
+// pass and the others still sent.
+func flushOutbox(ctx context.Context, w *colorprofile.Writer, ids []string, force bool) (time.Time, int, error) {
+	unlock, err := lockOutbox()
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer unlock()
+
+	items, err := loadOutbox()
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if len(ids) > 0 {
+		if items, err = selectOutboxItems(items, ids); err != nil {
+			return time.Time{}, 0, err
+		}
+	}
+
+	var (
+		next      time.Time
+		attempted int
+		failed    int
+		now       = time.Now()
+		// signedOut are the accounts that need signing in again.
+		signedOut = map[string]bool{}
+	)
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return time.Time{}, failed, nil
+		}
+		if signedOut[item.Account] {
+			continue
+		}
+		if !item.due(now) && (!force || len(ids) == 0 && item.scheduled()) {
+			if !item.Held && (next.IsZero() || item.NextAttempt.Before(next)) {
+				next = item.NextAttempt
+			}
+			continue
+		}
+		attempted++
+		receipt, err := sendOutboxItem(ctx, item)
+		if canceledUnsent(err) {
+			_, _ = fmt.Fprintln(w, commentStyle.Render("Canceled: "+item.ID+" wasn't sent and is still in the outbox."))
+			return time.Time{}, failed, nil
+		}
+		var reauth *reauthError
+		if errors.As(err, &reauth) {
+			if !queueOpts.run {
+				return time.Time{}, failed, fmt.Errorf("%s: %w; run `%s` and flush again", item.ID, err, authCommand(reauth.login))
+			}
+			signedOut[item.Account] = true
+			_, _ = fmt.Fprintln(w, errorStyle.Render(fmt.Sprintf(
+				"Couldn't send %s: %v; run `%s`, and the account's emails are sent on a later pass",
+				item.ID, err, authCommand(reauth.login))))
+			continue
+		}
+		if err != nil {
+			failed++
+			_, _ = fmt.Fprintln(w, errorStyle.Render("Couldn't send "+item.ID+": "+err.Error()))
+			// An item that couldn't be tried at all, like one whose
+			// account is gone, keeps its old, past attempt time, and
+			// waits for the next pass instead.
+			if !item.Held && item.NextAttempt.After(now) && (next.IsZero() || item.NextAttempt.Before(next)) {
+				next = item.NextAttempt
+			}
+			continue
+		}
+		if queueOpts.json {
+			if err := printJSON(receipt); err != nil {
+				return time.Time{}, failed, err
+			}
+			continue
+		}
+		_, _ = fmt.Fprint(w, emailSummary(item.Message.Subject, receipt))
+	}
+	if attempted == 0 && !queueOpts.json && !queueOpts.run {
+		_, _ = fmt.Fprintf(w, "\n  %s\n\n", placeholderStyle.Render("No emails in the outbox are due."))
+	}
+	return next, failed, nil
+}
+
+// queuePollInterval is how often pop queue run looks for emails added to the
+// outbox since it last looked.
+const queuePollInterval = time.Minute
+
+// QueueRunCmd is the cobra command that keeps draining the outbox.
+var QueueRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Keep sending scheduled and queued emails when they're due",
+	Long: `Keeps running, sending scheduled emails at their time and retrying queued ones
+when they're due, until it's interrupted. Run it in the background, or as a
+service, to send emails scheduled with --send-at through SMTP.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		queueOpts.run = true
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		ew := colorprofile.NewWriter(os.Stderr, os.Environ())
+		for {
+			next, _, err := flushOutbox(ctx, w, nil, false)
+			if err != nil {
+				// Like a locked or unreadable outbox: it may pass, and
+				// stopping would leave every other email unsent.
+				_, _ = fmt.Fprintln(ew, errorStyle.Render("Couldn't flush the outbox: "+err.Error()))
+				next = time.Time{}
+			}
+			wait := queuePollInterval
+			if !next.IsZero() {
+				wait = min(wait, time.Until(next))
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(wait):
+			}
+		}
+	},
+}
+
+// QueueDropCmd is the cobra command that removes emails from the outbox.
+var QueueDropCmd = &cobra.Command{
+	Use:               "drop <id>...",
+	Short:             "Remove emails from the outbox without sending them",
+	Long:              `Removes emails from the outbox without sending them. They're marked as failed in the sent history, so pop log resend can still open them.`,
+	ValidArgsFunction: completeOutboxIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		if queueOpts.all == (len(args) > 0) {
+			return errors.New("give the IDs of the emails to drop, or --all")
+		}
+		unlock, err := lockOutbox()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		items, err := loadOutbox()
+		if err != nil {
+			return err
+		}
+		if !queueOpts.all {
+			if items, err = selectOutboxItems(items, args); err != nil {
+				return err
+			}
+		}
+		for _, item := range items {
+			if err := removeOutboxItem(item); err != nil {
+				return err
+			}
+			updateHistoryEntry(item.ID, func(e *HistoryEntry) {
+				e.Status = historyFailed
+				e.Error = ordefault(e.Error, item.LastError) + " (dropped from the outbox)"
+			})
+			fmt.Printf("Dropped %s from the outbox.\n", item.ID)
+		}
+		return nil
+	},
+}
+
+// outboxLine renders the item as a line of `pop queue`.
+func outboxLine(item *OutboxItem) string {
+	msg := item.Message
+	to := strings.Join(compactAddresses(msg.To), ", ")
+	if others := len(compactAddresses(msg.Cc)) + len(compactAddresses(msg.Bcc)); others > 0 {
+		to += fmt.Sprintf(" +%d", others)
+	}
+	if item.scheduled() {
+		return fmt.Sprintf("%s%s %s %s %s",
+			textStyle.Render(item.ID),
+			commentStyle.Render(item.CreatedAt.Local().Format("2006-01-02 15:04")),
+			linkStyle.Render(to),
+			activeTextStyle.Render(`"`+msg.Subject+`"`),
+			"scheduled for "+activeLabelStyle.Render(item.SendAt.Local().Format("2006-01-02 15:04")),
+		)
+	}
+	state := "retry at " + item.NextAttempt.Local().Format("2006-01-02 15:04")
+	if item.Held {
+		state = errorStyle.Render("held")
+	}
+	return fmt.Sprintf("%s%s %s %s %s\n %s",
+		textStyle.Render(item.ID),
+		commentStyle.Render(item.CreatedAt.Local().Format("2006-01-02 15:04")),
+		linkStyle.Render(to),
+		activeTextStyle.Render(`"`+msg.Subject+`"`),
+		state,
+		commentStyle.Render(fmt.Sprintf("attempt %d: %s", item.Attempts, item.LastError)),
+	)
+}
+
+// printQueued tells the user the email is in the outbox, waiting for its
+// scheduled time, or with --json prints the outbox item. An email that's
+// waiting to be retried is reported on stderr instead, and errQueued
+// returned, so scripts can tell it hasn't gone out.
+func printQueued(item *OutboxItem) error {
+	if item.scheduled() {
+		if jsonOutput {
+			return printJSON(item)
+		}
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		_, _ = fmt.Fprintf(w, "\n  Email %s is scheduled for %s.\n  %s\n\n",
+			activeTextStyle.Render(`"`+item.Message.Subject+`"`),
+			activeLabelStyle.Render(item.SendAt.Local().Format("Mon 2 Jan 15:04")),
+			commentStyle.UnsetPaddingLeft().Render("pop sends it itself: keep `pop queue run` running until then."),
+		)
+		return nil
+	}
+	if jsonOutput {
+		if err := writeJSON(os.Stderr, item); err != nil {
+			return err
+		}
+		return errQueued
+	}
+	w := colorprofile.NewWriter(os.Stderr, os.Environ())
+	_, _ = fmt.Fprintf(w, "\n  Email %s couldn't be sent yet and is in the outbox.\n  %s\n  %s\n\n",
+		activeTextStyle.Render(`"`+item.Message.Subject+`"`),
+		errorStyle.Render(item.LastError),
+		commentStyle.UnsetPaddingLeft().Render("Run `pop queue flush` after "+item.NextAttempt.Local().Format(time.TimeOnly)+" to try again."),
+	)
+	return errQueued
+}
+
+// completeOutboxIDs completes the IDs of the emails in the outbox.
+func completeOutboxIDs(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	items, err := loadOutbox()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID + "\t" + item.Message.Subject
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	QueueCmd.AddCommand(QueueFlushCmd, QueueRunCmd, QueueDropCmd)
+	QueueCmd.Flags().BoolVar(&queueOpts.json, "json", false, "Print the outbox as JSON")
+	QueueFlushCmd.Flags().BoolVar(&queueOpts.all, "all", false, "Try every email now, held ones included")
+	QueueFlushCmd.Flags().BoolVar(&queueOpts.wait, "wait", false, "Keep running until every email that isn't held is sent")
+	QueueFlushCmd.Flags().BoolVar(&queueOpts.json, "json", false, "Print the receipts as JSON")
+	QueueDropCmd.Flags().BoolVar(&queueOpts.all, "all", false, "Drop every email in the outbox")
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/spf13/cobra"
+)
+
+// defaultSendHour is the time of day an email is scheduled for when only a
+// day is given, e.g. "tomorrow".
+const defaultSendHour = 9
+
+// sendAtExamples are offered as completions for --send-at and in the TUI's
+// schedule picker.
+var sendAtExamples = []string{
+	"in 1 hour",
+	"tomorrow 9am",
+	"tomorrow 1pm",
+	"monday 9am",
+}
+
+// parseSendAt parses when to send an email: an RFC 3339 time, a local date
+// and time (2006-01-02 15:04), or a phrase such as "in 2h", "tomorrow 9am",
+// "friday 17:30" or "9am". A day without a time means 9am. The time has to
+// be in the future.
+func parseSendAt(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, errors.New("no time given")
+	}
+	t, err := parseSendAtTime(strings.ToLower(s), now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("%s is in the past", t.Format("Mon 2 Jan 15:04"))
+	}
+	return t, nil
+}
+
+func parseSendAtTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, strings.ToUpper(s)); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", "2006-01-02t15:04"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(s, "in "); ok {
+		d, err := parseSendAtDuration(rest)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	}
+
+	day, clock, _ := strings.Cut(s, " ")
+	clock = strings.TrimPrefix(strings.TrimSpace(clock), "at ")
+	var date time.Time
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch day {
+	case "today":
+		date = midnight
+	case "tomorrow":
+		date = midnight.AddDate(0, 0, 1)
+	default:
+		if t, err := time.ParseInLocation(time.DateOnly, day, now.Location()); err == nil {
+			date = t
+			break
+		}
+		if wd, ok := parseWeekday(day); ok {
+			// The next one, so "monday" on a Monday means next week.
+			days := (int(wd)-int(now.Weekday())+6)%7 + 1
+			date = midnight.AddDate(0, 0, days)
+			break
+		}
+		// A time alone: the next time the clock shows it.
+		h, m, err := parseClock(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("can't tell when %q is: try 2006-01-02 15:04, \"tomorrow 9am\" or \"in 2h\"", s)
+		}
+		t := midnight.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute)
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+
+	h, m := defaultSendHour, 0
+	if clock != "" {
+		var err error
+		if h, m, err = parseClock(clock); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return date.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute), nil
+}
+
+// parseSendAtDuration parses the duration in "in 2h" or "in 3 days".
+func parseSendAtDuration(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(strings.ReplaceAll(s, " ", "")); err == nil {
+		return d, nil
+	}
+	n, unit, _ := strings.Cut(s, " ")
+	count, err := strconv.Atoi(n)
+	if err != nil && (n == "a" || n == "an") {
+		count, err = 1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	switch strings.TrimSuffix(strings.TrimSpace(unit), "s") {
+	case "minute", "min":
+		return time.Duration(count) * time.Minute, nil
+	case "hour", "hr":
+		return time.Duration(count) * time.Hour, nil
+	case "day":
+		return time.Duration(count) * 24 * time.Hour, nil
+	case "week":
+		return time.Duration(count) * 7 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+}
+
+// parseClock parses a time of day such as 9am, 9:30 pm, 17:30 or noon.
+func parseClock(s string) (hour, minute int, err error) {
+	s = strings.ReplaceAll(s, " ", "")
+	switch s {
+	case "noon":
+		return 12, 0, nil //nolint:mnd
+	case "midnight":
+		return 0, 0, nil
+	}
+	for _, layout := range []string{"3pm", "3:04pm", "15:04", "15"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Hour(), t.Minute(), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid time of day %q", s)
+}
+
+// parseWeekday parses a day of the week, in full or its first three letters.
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// scheduler is implemented by senders whose provider can hold an email until
+// its Message.SendAt and send it then.
+type scheduler interface {
+	// cancelScheduled cancels the scheduled email with the provider's ID.
+	cancelScheduled(ctx context.Context, id string) error
+}
+
+// cancelScheduled cancels an email scheduled with Resend.
+func (s *ResendSender) cancelScheduled(ctx context.Context, id string) error {
+	apiKey, err := s.apiKey()
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(resendBaseURL(), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/emails/"+url.PathEscape(id)+"/cancel", nil)
+	if err != nil {
+		return fmt.Errorf("creating cancel request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("canceling email: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("canceling email: %w", &HTTPError{Status: resp.Status, Body: strings.TrimSpace(string(body))})
+	}
+	return nil
+}
+
+// ScheduledEmail is an email waiting to be sent at a later time, by pop or by
+// the provider.
+type ScheduledEmail struct {
+	ID        string    `json:"id"`
+	SendAt    time.Time `json:"send_at"`
+	Account   string    `json:"account,omitempty"`
+	Transport string    `json:"transport"`
+	// By is what will send it: "pop" for the outbox, drained by pop queue
+	// run, or "resend".
+	By      string   `json:"by"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+}
+
+// loadScheduled returns the emails waiting to be sent later, soonest first:
+// the ones in the outbox, and the ones in the history that Resend will send.
+func loadScheduled() ([]ScheduledEmail, error) {
+	items, err := loadOutbox()
+	if err != nil {
+		return nil, err
+	}
+	scheduled := []ScheduledEmail{}
+	for _, item := range items {
+		if !item.scheduled() {
+			continue
+		}
+		scheduled = append(scheduled, ScheduledEmail{
+			ID:        item.ID,
+			SendAt:    item.SendAt,
+			Account:   item.Account,
+			Transport: item.Transport,
+			By:        "pop",
+			To:        compactAddresses(item.Message.To),
+			Subject:   item.Message.Subject,
+		})
+	}
+
+	if historyEnabled() {
+		entries, err := loadHistory()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, e := range entries {
+			if e.Status != historyScheduled || e.Receipt == nil || e.Receipt.ScheduledAt.Before(now) {
+				continue
+			}
+			scheduled = append(scheduled, ScheduledEmail{
+				ID:        e.ID,
+				SendAt:    e.Receipt.ScheduledAt,
+				Account:   e.Account,
+				Transport: e.Transport,
+				By:        e.Receipt.Provider,
+				To:        e.To,
+				Subject:   e.Subject,
+			})
+		}
+	}
+	slices.SortFunc(scheduled, func(a, b ScheduledEmail) int {
+		return a.SendAt.Compare(b.SendAt)
+	})
+	return scheduled, nil
+}
+
+var scheduledJSON bool
+
+// ScheduledCmd is the cobra command that lists scheduled emails.
+var ScheduledCmd = &cobra.Command{
+	Use:   "scheduled",
+	Short: "List emails scheduled to be sent later",
+	Long: `Lists the emails scheduled with --send-at, soonest first. Resend sends the
+ones it accepted itself; the others wait in the outbox for pop queue run.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		scheduled, err := loadScheduled()
+		if err != nil {
+			return err
+		}
+		if scheduledJSON {
+			return printJSON(scheduled)
+		}
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		if len(scheduled) == 0 {
+			_, _ = fmt.Fprintf(w, "\n  %s\n\n", placeholderStyle.Render("No scheduled emails."))
+			return nil
+		}
+		for _, s := range scheduled {
+			_, _ = fmt.Fprintf(w, "%s %s %s %s%s\n",
+				textStyle.Render(s.ID),
+				activeLabelStyle.Render(s.SendAt.Local().Format("Mon 2 Jan 15:04")),
+				linkStyle.Render(strings.Join(s.To, ", ")),
+				activeTextStyle.Render(`"`+s.Subject+`"`),
+				commentStyle.Render("by "+s.By),
+			)
+		}
+		return nil
+	},
+}
+
+// ScheduledListCmd is the cobra command that lists scheduled emails, as
+// ScheduledCmd does.
+var ScheduledListCmd = &cobra.Command{
+	Use:   "list",
+	Short: ScheduledCmd.Short,
+	Args:  cobra.NoArgs,
+	RunE:  ScheduledCmd.RunE,
+}
+
+// ScheduledCancelCmd is the cobra command that cancels scheduled emails.
+var ScheduledCancelCmd = &cobra.Command{
+	Use:   "cancel <id>...",
+	Short: "Cancel scheduled emails",
+	Long: `Cancels scheduled emails, taking them out of the outbox or asking Resend not
+to send them. They stay in the sent history, marked as canceled, so pop log
+resend can still open them.`,
+	Args: cobra.MinimumNArgs(1),
+	ValidArgsFunction: func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		scheduled, err := loadScheduled()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		ids := make([]string, len(scheduled))
+		for i, s := range scheduled {
+			ids[i] = s.ID + "\t" + s.Subject
+		}
+		return ids, cobra.ShellCompDirectiveNoFileComp
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		scheduled, err := loadScheduled()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(cmd.Context(), time.Minute)
+		defer cancel()
+		var errs []error
+		for _, id := range args {
+			s, err := findScheduled(scheduled, id)
+			if err == nil {
+				err = cancelScheduledEmail(ctx, s)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Printf("Canceled %s.\n", s.ID)
+		}
+		return errors.Join(errs...)
+	},
+}
+
+// findScheduled returns the scheduled email whose ID is, or starts with, id.
+func findScheduled(scheduled []ScheduledEmail, id string) (ScheduledEmail, error) {
+	var found []ScheduledEmail
+	for _, s := range scheduled {
+		if s.ID == id {
+			return s, nil
+		}
+		if strings.HasPrefix(s.ID, id) {
+			found = append(found, s)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return ScheduledEmail{}, fmt.Errorf("no scheduled email %q", id)
+	case 1:
+		return found[0], nil
+	default:
+		return ScheduledEmail{}, fmt.Errorf("%q matches more than one scheduled email", id)
+	}
+}
+
+// cancelScheduledEmail cancels the email and marks it canceled in the
+// history.
+func cancelScheduledEmail(ctx context.Context, s ScheduledEmail) error {
+	if s.By == "pop" {
+		unlock, err := lockOutbox()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		items, err := loadOutbox()
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(items, func(item *OutboxItem) bool { return item.ID == s.ID })
+		if i < 0 || !items[i].scheduled() {
+			return fmt.Errorf("%s has already been sent", s.ID)
+		}
+		if err := removeOutboxItem(items[i]); err != nil {
+			return err
+		}
+	} else {
+		e, err := findHistoryEntry(s.ID)
+		if err != nil {
+			return err
+		}
+		account := accountName
+		if account == "" {
+			if account, err = e.sentAccount(); err != nil {
+				return fmt.Errorf("%s: %w", s.ID, err)
+			}
+		}
+		sender, transport, err := accountSender(account)
+		if err != nil {
+			return err
+		}
+		sc, ok := sender.(scheduler)
+		if !ok {
+			return fmt.Errorf("%s: %s can't cancel scheduled emails", s.ID, transport)
+		}
+		if err := sc.cancelScheduled(ctx, e.Receipt.ID); err != nil {
+			return fmt.Errorf("%s: %w", s.ID, err)
+		}
+	}
+	updateHistoryEntry(s.ID, func(e *HistoryEntry) {
+		e.Status = historyCanceled
+	})
+	return nil
+}
+
+func init() {
+	ScheduledCmd.AddCommand(ScheduledListCmd, ScheduledCancelCmd)
+	ScheduledCmd.Flags().BoolVar(&scheduledJSON, "json", false, "Print the emails as JSON")
+	ScheduledListCmd.Flags().BoolVar(&scheduledJSON, "json", false, "Print the emails as JSON")
+}
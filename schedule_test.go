@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSendAt(t *testing.T) {
+	zone := time.FixedZone("CEST", 2*60*60)
+	// A Wednesday morning.
+	now := time.Date(2026, 10, 14, 10, 30, 0, 0, zone)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, zone)
+	}
+
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr string
+	}{
+		{in: "tomorrow 9am", want: at(10, 15, 9, 0)},
+		{in: "Tomorrow at 5:30 PM", want: at(10, 15, 17, 30)},
+		{in: "tomorrow", want: at(10, 15, defaultSendHour, 0)},
+		{in: "today 5pm", want: at(10, 14, 17, 0)},
+		{in: "9 am", want: at(10, 15, 9, 0)},
+		{in: "11am", want: at(10, 14, 11, 0)},
+		{in: "17:30", want: at(10, 14, 17, 30)},
+		{in: "noon", want: at(10, 14, 12, 0)},
+		{in: "midnight", want: at(10, 15, 0, 0)},
+		{in: "wednesday", want: at(10, 21, defaultSendHour, 0)},
+		{in: "wed 8am", want: at(10, 21, 8, 0)},
+		{in: "friday 17:30", want: at(10, 16, 17, 30)},
+		{in: "Mon", want: at(10, 19, defaultSendHour, 0)},
+		{in: "in 2h", want: now.Add(2 * time.Hour)},
+		{in: "in 1h30m", want: now.Add(90 * time.Minute)},
+		{in: "in 90 minutes", want: now.Add(90 * time.Minute)},
+		{in: "in a day", want: now.Add(24 * time.Hour)},
+		{in: "in an hour", want: now.Add(time.Hour)},
+		{in: "in 2 weeks", want: now.Add(14 * 24 * time.Hour)},
+		{in: "2026-10-20T08:00:00+05:00", want: time.Date(2026, 10, 20, 3, 0, 0, 0, time.UTC)},
+		{in: "2026-10-20t06:00:00z", want: time.Date(2026, 10, 20, 6, 0, 0, 0, time.UTC)},
+		{in: "2026-10-20 15:04", want: at(10, 20, 15, 4)},
+		{in: "2026-10-20 15:04:05", want: at(10, 20, 15, 4).Add(5 * time.Second)},
+		{in: "2026-10-20", want: at(10, 20, defaultSendHour, 0)},
+		{in: "2026-10-20 at 7pm", want: at(10, 20, 19, 0)},
+
+		{in: "2026-10-14 10:00", wantErr: "in the past"},
+		{in: "today 9am", wantErr: "in the past"},
+		{in: "2020-01-01T00:00:00Z", wantErr: "in the past"},
+		{in: "in -1h", wantErr: "in the past"},
+		{in: "in 0m", wantErr: "in the past"},
+
+		{in: "", wantErr: "no time given"},
+		{in: "someday", wantErr: "can't tell when"},
+		{in: "in soon", wantErr: "invalid duration"},
+		{in: "in 3 fortnights", wantErr: "invalid duration"},
+		{in: "tomorrow 25:00", wantErr: "invalid time of day"},
+		{in: "friday teatime", wantErr: "invalid time of day"},
+		{in: "2026-13-01 09:00", wantErr: "can't tell when"},
+	}
+	for _, tt := range tests {
+		got, err := parseSendAt(tt.in, now)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseSendAt(%q) = %v, %v; want an error containing %q", tt.in, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSendAt(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseSendAt(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is an email ready to be handed to a Sender.
+type Message struct {
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+	Cc          []string `json:"cc,omitempty"`
+	Bcc         []string `json:"bcc,omitempty"`
+	Subject     string   `json:"subject"`
+	Body        string   `json:"body"`
+	Plaintext   bool     `json:"plaintext,omitempty"`
+	Attachments []string `json:"attachments,omitempty"`
+	// Headers are extra headers to set, such as Reply-To or List-Unsubscribe.
+	Headers map[string]string `json:"headers,omitempty"`
+	// SendAt is when to send the message, if not right away. Senders that
+	// implement scheduler pass it on to their provider.
+	SendAt time.Time `json:"send_at,omitzero"`
+}
+
+// Receipt describes a message a transport accepted, so a send can be matched
+// up with the provider's logs.
+type Receipt struct {
+	// Provider is what accepted the message: "resend", or "smtp".
+	Provider string `json:"provider"`
+	// Server is the SMTP server's address.
+	Server string `json:"server,omitempty"`
+	// ID is the provider's ID for the message: the Resend email ID, or the
+	// queue ID from the SMTP server's final reply, when it has one.
+	ID string `json:"id,omitempty"`
+	// MessageID is the Message-ID header pop set, if it set one.
+	MessageID string `json:"message_id,omitempty"`
+	// Response is the SMTP server's final reply.
+	Response string    `json:"response,omitempty"`
+	SentAt   time.Time `json:"sent_at"`
+	// ScheduledAt is when the provider will send the message, if it's
+	// holding on to it until then.
+	ScheduledAt time.Time `json:"scheduled_at,omitzero"`
+	// Accepted are the recipients the provider accepted, and Rejected the
+	// ones it turned down while accepting the others.
+	Accepted []string            `json:"accepted"`
+	Rejected []RejectedRecipient `json:"rejected,omitempty"`
+}
+
+// RejectedRecipient is a recipient the provider turned down.
+type RejectedRecipient struct {
+	Address string `json:"address"`
+	Error   string `json:"error"`
+}
+
+// Sender delivers a Message using a particular transport.
+type Sender interface {
+	// Send sends the message. Canceling ctx cuts the send short, with an
+	// *interruptedSendError saying whether the message may have gone out.
+	Send(ctx context.Context, msg Message) (*Receipt, error)
+}
+
+// batchSender is implemented by senders that can send several messages at
+// once more cheaply than one by one.
+type batchSender interface {
+	// SendBatch sends the messages and returns a receipt or an error for
+	// each, in the same order.
+	SendBatch(ctx context.Context, msgs []Message) ([]*Receipt, []error)
+}
+
+// Default send timeouts, used when neither --connect-timeout and
+// --send-timeout nor their environment variables say otherwise.
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultSendTimeout    = 30 * time.Second
+)
+
+// PopConnectTimeout is the environment variable that sets how long to wait
+// to connect to the provider, e.g. "10s".
+const PopConnectTimeout = "POP_CONNECT_TIMEOUT"
+
+// PopSendTimeout is the environment variable that sets how long to wait for
+// the provider to take the message once connected, e.g. "30s".
+const PopSendTimeout = "POP_SEND_TIMEOUT"
+
+// timeoutOr returns d, or def if d isn't set.
+func timeoutOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// interruptedSendError is returned when a send is canceled or times out part
+// way through.
+type interruptedSendError struct {
+	// err is why: context.Canceled, context.DeadlineExceeded or the error
+	// the connection failed with.
+	err error
+	// mayHaveSent is set when the provider may already have taken the
+	// message, so it may be delivered even though the send didn't finish.
+	mayHaveSent bool
+}
+
+func (e *interruptedSendError) Error() string {
+	var reason string
+	var netErr net.Error
+	switch {
+	case errors.Is(e.err, context.Canceled):
+		reason = "canceled"
+	case errors.Is(e.err, context.DeadlineExceeded), errors.As(e.err, &netErr) && netErr.Timeout():
+		reason = "timed out"
+	default:
+		reason = fmt.Sprintf("lost the connection (%v)", e.err)
+	}
+	if e.mayHaveSent {
+		return reason + " after the message was handed over: it may have been delivered, so check before sending it again"
+	}
+	return reason + " before the message was handed over: it wasn't sent"
+}
+
+func (e *interruptedSendError) Unwrap() error { return e.err }
+
+// tokenSender is implemented by senders that can authenticate with an OAuth
+// token from pop's token store.
+type tokenSender interface {
+	// tokenLogin returns the login key of the stored OAuth token the
+	// sender uses, or an empty string if it doesn't use one.
+	tokenLogin() string
+}
+
+// Transport is a named delivery method that pop can pick from when deciding
+// how to send an email.
+type Transport struct {
+	// Name identifies the transport, e.g. "smtp" or "resend".
+	Name string
+
+	// Configured reports whether the user has configured this transport
+	// via flags or the environment.
+	Configured func() bool
+
+	// New returns a Sender for this transport. It's only called once the
+	// transport has been selected.
+	New func() (Sender, error)
+}
+
+// transports is the registry of available transports, in the order they're
+// reported to the user.
+var transports []Transport
+
+// RegisterTransport adds a transport to the registry. Registering a transport
+// with a name that's already taken replaces the existing one.
+func RegisterTransport(t Transport) {
+	for i, existing := range transports {
+		if existing.Name == t.Name {
+			transports[i] = t
+			return
+		}
+	}
+	transports = append(transports, t)
+}
+
+// lookupTransport returns the registered transport with the given name.
+func lookupTransport(name string) (Transport, bool) {
+	for _, t := range transports {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Transport{}, false
+}
+
+// configuredTransports returns the registered transports the user has
+// configured.
+func configuredTransports() []Transport {
+	var configured []Transport
+	for _, t := range transports {
+		if t.Configured != nil && t.Configured() {
+			configured = append(configured, t)
+		}
+	}
+	return configured
+}
+
+// errNoTransport is returned when no transport has been configured.
+var errNoTransport = errors.New("missing delivery method")
+
+// ambiguousTransportError is returned when more than one transport has been
+// configured.
+type ambiguousTransportError struct {
+	names []string
+}
+
+func (e *ambiguousTransportError) Error() string {
+	return "more than one delivery method configured: " + strings.Join(e.names, ", ")
+}
+
+// selectTransport picks the single configured transport. It returns
+// errNoTransport when nothing is configured and an *ambiguousTransportError
+// when more than one transport is.
+func selectTransport() (Transport, error) {
+	configured := configuredTransports()
+	switch len(configured) {
+	case 0:
+		return Transport{}, errNoTransport
+	case 1:
+		return configured[0], nil
+	default:
+		names := make([]string, len(configured))
+		for i, t := range configured {
+			names[i] = t.Name
+		}
+		return Transport{}, &ambiguousTransportError{names: names}
+	}
+}
+
+// accountSenders holds the sender of each account set up by accountSender.
+var accountSenders = map[string]Sender{}
+
+// accountSender returns a sender for the named account, or the default
+// configuration when the name is empty, and the name of its transport.
+// Senders are set up once per account, so working through a history or
+// the outbox doesn't set the same one up over and over.
+func accountSender(account string) (Sender, string, error) {
+	if sender, ok := accountSenders[account]; ok {
+		return sender, senderTransport(sender), nil
+	}
+	if _, _, err := useAccount(account); err != nil {
+		return nil, "", err
+	}
+	transport, err := pickTransport()
+	if err != nil {
+		return nil, "", err
+	}
+	sender, err := transport.New()
+	if err != nil {
+		return nil, "", err
+	}
+	accountSenders[account] = sender
+	return sender, transport.Name, nil
+}
+
+// sendInterrupted returns err as an *interruptedSendError if the send was
+// canceled, timed out or lost its connection, rather than being refused.
+// handedOver is whether the provider may already have the message.
+func sendInterrupted(ctx context.Context, err error, handedOver bool) error {
+	if ctx.Err() != nil {
+		return &interruptedSendError{err: ctx.Err(), mayHaveSent: handedOver}
+	}
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return err
+	}
+	var netErr net.Error
+	if handedOver || (errors.As(err, &netErr) && netErr.Timeout()) || errors.Is(err, context.DeadlineExceeded) {
+		return &interruptedSendError{err: err, mayHaveSent: handedOver}
+	}
+	return err
+}
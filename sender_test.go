@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cancelSoon returns a context that's canceled shortly, once the send has
+// got going.
+func cancelSoon(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithCancel(t.Context())
+	timer := time.AfterFunc(100*time.Millisecond, cancel)
+	t.Cleanup(func() {
+		timer.Stop()
+		cancel()
+	})
+	return ctx
+}
+
+func TestCanceledSend(t *testing.T) {
+	const (
+		unsent   = "canceled before the message was handed over: it wasn't sent"
+		maybeOut = "canceled after the message was handed over: it may have been delivered, so check before sending it again"
+	)
+	msg := Message{From: "me@resend.dev", To: []string{"you@example.com"}, Subject: "Hi", Body: "Hello"}
+
+	resendStall := func(t *testing.T) Sender {
+		t.Helper()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Take the email, then never answer.
+			_, _ = io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+		}))
+		t.Cleanup(srv.Close)
+		t.Setenv("RESEND_BASE_URL", srv.URL)
+		return &ResendSender{APIKey: "re_test"}
+	}
+	smtpStall := func(command string) func(t *testing.T) Sender {
+		return func(t *testing.T) Sender {
+			t.Helper()
+			return startSMTPStub(t, &smtpStub{stall: command}).sender()
+		}
+	}
+
+	tests := []struct {
+		name        string
+		sender      func(t *testing.T) Sender
+		mayHaveSent bool
+		want        string
+	}{
+		{name: "SMTP before DATA", sender: smtpStall("MAIL"), want: unsent},
+		{name: "SMTP waiting for DATA to go ahead", sender: smtpStall("DATA"), want: unsent},
+		{name: "SMTP after the message", sender: smtpStall("."), mayHaveSent: true, want: maybeOut},
+		{name: "Resend after the request", sender: resendStall, mayHaveSent: true, want: maybeOut},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDirs(t)
+			sender := tt.sender(t)
+
+			d, err := deliver(cancelSoon(t), "", sender, msg)
+			var interrupted *interruptedSendError
+			if !errors.As(err, &interrupted) {
+				t.Fatalf("deliver error = %v, want an interrupted send", err)
+			}
+			if interrupted.mayHaveSent != tt.mayHaveSent {
+				t.Errorf("mayHaveSent = %v, want %v", interrupted.mayHaveSent, tt.mayHaveSent)
+			}
+			if canceledUnsent(err) == tt.mayHaveSent {
+				t.Errorf("canceledUnsent = %v, want %v", canceledUnsent(err), !tt.mayHaveSent)
+			}
+			// This is what pop prints.
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to say %q", err, tt.want)
+			}
+			if d.queued != nil {
+				t.Error("a canceled send was queued to be tried again")
+			}
+			// Only an email that may have gone out is in the history,
+			// so it can be checked and resent by hand.
+			if got := d.entry != nil; got != tt.mayHaveSent {
+				t.Errorf("recorded in the history = %v, want %v", got, tt.mayHaveSent)
+			} else if d.entry != nil && d.entry.Status != historyFailed {
+				t.Errorf("history status = %q, want %q", d.entry.Status, historyFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"charm.land/bubbles/v2/help"
+	"charm.land/bubbles/v2/key"
+	"charm.land/bubbles/v2/spinner"
+	"charm.land/bubbles/v2/textinput"
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+	"github.com/charmbracelet/x/exp/charmtone"
+	"github.com/charmbracelet/x/term"
+	"github.com/spf13/cobra"
+)
+
+// setupStep is a step of the setup wizard.
+type setupStep int
+
+const (
+	setupStepMethod setupStep = iota
+	setupStepProvider
+	setupStepSMTP
+	setupStepAPIKey
+	setupStepFrom
+	setupStepTest
+	setupStepSending
+	setupStepFailed
+)
+
+// setupMethod is a delivery method offered by the setup wizard.
+type setupMethod struct {
+	transport string
+	label     string
+}
+
+var setupMethods = []setupMethod{
+	{transport: transportResendOAuth, label: "Resend, sign in with the browser"},
+	{transport: transportResend, label: "Resend, with an API key"},
+	{transport: transportSMTP, label: "SMTP"},
+}
+
+// Indices of the inputs on the SMTP and From steps.
+const (
+	setupInputHost = iota
+	setupInputPort
+	setupInputEncryption
+	setupInputUsername
+	setupInputPassword
+)
+
+const (
+	setupInputFrom = iota
+	setupInputAccount
+)
+
+// setupKeyMap represents the key bindings for the setup wizard.
+type setupKeyMap struct {
+	Up       key.Binding
+	Down     key.Binding
+	Continue key.Binding
+	Next     key.Binding
+	Skip     key.Binding
+	Back     key.Binding
+	Cancel   key.Binding
+}
+
+// defaultSetupKeybinds returns the default key bindings for the setup
+// wizard.
+func defaultSetupKeybinds() setupKeyMap {
+	return setupKeyMap{
+		Up: key.NewBinding(
+			key.WithKeys("up", "k"),
+			key.WithHelp("↑/k", "up"),
+		),
+		Down: key.NewBinding(
+			key.WithKeys("down", "j"),
+			key.WithHelp("↓/j", "down"),
+		),
+		Continue: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "continue"),
+		),
+		Next: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "next"),
+		),
+		Skip: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "skip test"),
+		),
+		Back: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "back"),
+		),
+		Cancel: key.NewBinding(
+			key.WithKeys("ctrl+c"),
+			key.WithHelp("ctrl+c", "cancel"),
+		),
+	}
+}
+
+// ShortHelp returns the key bindings for the short help screen.
+func (k setupKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Up, k.Down, k.Next, k.Continue, k.Skip, k.Back, k.Cancel}
+}
+
+// FullHelp returns the key bindings for the full help screen.
+func (k setupKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{k.ShortHelp()}
+}
+
+// updateSetupKeymap enables/disables key bindings based on the current step.
+func (m *setupModel) updateSetupKeymap() {
+	choosing := m.step == setupStepMethod || m.step == setupStepProvider
+	m.keymap.Up.SetEnabled(choosing)
+	m.keymap.Down.SetEnabled(choosing)
+	m.keymap.Next.SetEnabled(len(m.inputs) > 1)
+	m.keymap.Continue.SetEnabled(m.step != setupStepSending)
+	m.keymap.Skip.SetEnabled(m.step == setupStepTest)
+	m.keymap.Back.SetEnabled(m.step != setupStepMethod && m.step != setupStepSending)
+
+	switch m.step {
+	case setupStepTest:
+		m.keymap.Continue.SetHelp("enter", "send test")
+	case setupStepFailed:
+		m.keymap.Continue.SetHelp("enter", "retry")
+	default:
+		m.keymap.Continue.SetHelp("enter", "continue")
+	}
+}
+
+// setupModel is the Bubble Tea model for the setup wizard.
+type setupModel struct {
+	step     setupStep
+	method   int
+	provider int
+
+	// inputs are the text inputs of the current step, if any.
+	inputs []textinput.Model
+	focus  int
+
+	// account is the account being set up and name is what it'll be
+	// saved as.
+	account Account
+	name    string
+
+	spinner spinner.Model
+	help    help.Model
+	keymap  setupKeyMap
+
+	// needsAuth is set when the user picked OAuth but hasn't authenticated
+	// yet. The wizard quits so the caller can run the OAuth flow, then
+	// resumes.
+	needsAuth bool
+	saved     bool
+	canceled  bool
+	quitting  bool
+	err       error
+	width     int
+}
+
+type setupTestMsg struct {
+	err error
+}
+
+type setupSavedMsg struct {
+	err error
+}
+
+func newSetupModel() setupModel {
+	s := spinner.New()
+	s.Style = lipgloss.NewStyle().Foreground(charmtone.Julep)
+	s.Spinner = spinner.Dot
+	m := setupModel{
+		step:    setupStepMethod,
+		spinner: s,
+		help:    help.New(),
+		keymap:  defaultSetupKeybinds(),
+	}
+	m.updateSetupKeymap()
+	return m
+}
+
+// setupInputWidth is the width of the setup wizard's text inputs.
+const setupInputWidth = 48
+
+// newSetupInput returns a text input styled like the rest of Pop.
+func newSetupInput(prompt, placeholder, value string) textinput.Model {
+	ti := textinput.New()
+	ti.Prompt = prompt
+	ti.Placeholder = placeholder
+	styles := textinput.DefaultDarkStyles()
+	styles.Focused.Prompt = activeLabelStyle
+	styles.Focused.Text = activeTextStyle
+	styles.Focused.Placeholder = placeholderStyle
+	styles.Blurred.Prompt = labelStyle
+	styles.Blurred.Text = textStyle
+	styles.Blurred.Placeholder = placeholderStyle
+	styles.Cursor.Color = whiteColor
+	ti.SetStyles(styles)
+	ti.SetWidth(setupInputWidth)
+	ti.SetValue(value)
+	return ti
+}
+
+// enterStep moves the wizard to the given step, setting up its inputs.
+func (m *setupModel) enterStep(step setupStep) tea.Cmd {
+	m.step = step
+	m.inputs = nil
+	m.focus = 0
+	m.err = nil
+
+	switch step {
+	case setupStepSMTP:
+		smtp := m.account.SMTP
+		port := ""
+		if smtp.Port != 0 {
+			port = strconv.Itoa(smtp.Port)
+		}
+		password := newSetupInput("Password ", "", smtp.Password)
+		password.EchoMode = textinput.EchoPassword
+		m.inputs = []textinput.Model{
+			newSetupInput("Host ", "smtp.example.com", smtp.Host),
+			newSetupInput("Port ", "587", port),
+			newSetupInput("Encryption ", "starttls, ssl, or none", smtp.Encryption),
+			newSetupInput("Username ", "me@example.com", smtp.Username),
+			password,
+		}
+	case setupStepAPIKey:
+		apiKey := newSetupInput("API key ", "re_xxxxxxxx", m.account.Resend.APIKey)
+		apiKey.EchoMode = textinput.EchoPassword
+		m.inputs = []textinput.Model{apiKey}
+	case setupStepFrom:
+		from := m.account.From
+		if from == "" {
+			from = m.account.SMTP.Username
+		}
+		m.inputs = []textinput.Model{
+			newSetupInput("From ", "me@example.com", from),
+			newSetupInput("Account ", "default", m.name),
+		}
+	case setupStepMethod, setupStepProvider, setupStepTest, setupStepSending, setupStepFailed:
+	}
+
+	m.updateSetupKeymap()
+	if len(m.inputs) > 0 {
+		return m.inputs[0].Focus()
+	}
+	return nil
+}
+
+// credentialsStep returns the step where the credentials for the chosen
+// method are entered.
+func (m setupModel) credentialsStep() setupStep {
+	switch m.account.Method {
+	case transportSMTP:
+		return setupStepSMTP
+	case transportResend:
+		return setupStepAPIKey
+	default:
+		return setupStepMethod
+	}
+}
+
+// Init initializes the setup wizard.
+func (m setupModel) Init() tea.Cmd {
+	if len(m.inputs) > 0 {
+		return m.inputs[m.focus].Focus()
+	}
+	return nil
+}
+
+// Update handles messages for the setup wizard.
+func (m setupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case setupTestMsg:
+		if msg.err != nil {
+			m.step = setupStepFailed
+			m.err = msg.err
+			m.updateSetupKeymap()
+			return m, nil
+		}
+		return m, saveSetupCmd(m.name, m.account)
+
+	case setupSavedMsg:
+		if msg.err != nil {
+			m.step = setupStepFailed
+			m.err = msg.err
+			m.updateSetupKeymap()
+			return m, nil
+		}
+		m.saved = true
+		m.quitting = true
+		return m, tea.Quit
+
+	case spinner.TickMsg:
+		if m.step != setupStepSending {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.help.SetWidth(msg.Width)
+		return m, nil
+
+	case tea.KeyPressMsg:
+		switch {
+		case key.Matches(msg, m.keymap.Cancel):
+			m.canceled = true
+			m.quitting = true
+			return m, tea.Quit
+		case key.Matches(msg, m.keymap.Back):
+			return m, m.back()
+		case key.Matches(msg, m.keymap.Up):
+			m.move(-1)
+			return m, nil
+		case key.Matches(msg, m.keymap.Down):
+			m.move(1)
+			return m, nil
+		case key.Matches(msg, m.keymap.Next):
+			return m, m.nextInput()
+		case key.Matches(msg, m.keymap.Skip):
+			return m, saveSetupCmd(m.name, m.account)
+		case key.Matches(msg, m.keymap.Continue):
+			return m.next()
+		}
+	}
+
+	if len(m.inputs) > 0 {
+		var cmd tea.Cmd
+		m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// move moves the cursor in the current list of choices.
+func (m *setupModel) move(delta int) {
+	switch m.step {
+	case setupStepMethod:
+		m.method = (m.method + delta + len(setupMethods)) % len(setupMethods)
+	case setupStepProvider:
+		// The last choice is "Other".
+		n := len(smtpPresets) + 1
+		m.provider = (m.provider + delta + n) % n
+	case setupStepSMTP, setupStepAPIKey, setupStepFrom, setupStepTest, setupStepSending, setupStepFailed:
+	}
+}
+
+// nextInput focuses the next input on the current step, wrapping around.
+func (m *setupModel) nextInput() tea.Cmd {
+	if len(m.inputs) == 0 {
+		return nil
+	}
+	m.inputs[m.focus].Blur()
+	m.focus = (m.focus + 1) % len(m.inputs)
+	return m.inputs[m.focus].Focus()
+}
+
+// back returns to the previous step.
+func (m *setupModel) back() tea.Cmd {
+	switch m.step {
+	case setupStepProvider, setupStepAPIKey:
+		return m.enterStep(setupStepMethod)
+	case setupStepSMTP:
+		return m.enterStep(setupStepProvider)
+	case setupStepFrom:
+		return m.enterStep(m.credentialsStep())
+	case setupStepTest:
+		return m.enterStep(setupStepFrom)
+	case setupStepFailed:
+		return m.enterStep(m.credentialsStep())
+	case setupStepMethod, setupStepSending:
+	}
+	return nil
+}
+
+// next completes the current step and moves on.
+func (m setupModel) next() (tea.Model, tea.Cmd) {
+	// Within a form, enter moves to the next input until the last one.
+	if len(m.inputs) > 0 && m.focus < len(m.inputs)-1 {
+		return m, m.nextInput()
+	}
+
+	switch m.step {
+	case setupStepMethod:
+		m.account.Method = setupMethods[m.method].transport
+		switch m.account.Method {
+		case transportSMTP:
+			return m, m.enterStep(setupStepProvider)
+		case transportResend:
+			return m, m.enterStep(setupStepAPIKey)
+		default:
+			return m, m.enterStep(setupStepFrom)
+		}
+
+	case setupStepProvider:
+		m.account.SMTP.InsecureSkipVerify = false
+		if m.provider < len(smtpPresets) {
+			preset := smtpPresets[m.provider]
+			m.account.SMTP.Host = preset.Host
+			m.account.SMTP.Port = preset.Port
+			m.account.SMTP.Encryption = preset.Encryption
+			m.account.SMTP.InsecureSkipVerify = preset.InsecureSkipVerify
+		}
+		return m, m.enterStep(setupStepSMTP)
+
+	case setupStepSMTP:
+		host := strings.TrimSpace(m.inputs[setupInputHost].Value())
+		if host == "" {
+			m.err = errors.New("host is required")
+			return m, nil
+		}
+		port := 0
+		if v := strings.TrimSpace(m.inputs[setupInputPort].Value()); v != "" {
+			p, err := strconv.Atoi(v)
+			if err != nil {
+				m.err = fmt.Errorf("invalid port %q", v)
+				return m, nil
+			}
+			port = p
+		}
+		encryption := strings.ToLower(strings.TrimSpace(m.inputs[setupInputEncryption].Value()))
+		switch encryption {
+		case "", encryptionSTARTTLS, encryptionSSL, encryptionNone:
+		default:
+			m.err = fmt.Errorf("invalid encryption %q", encryption)
+			return m, nil
+		}
+		m.account.SMTP = SMTPAccount{
+			Host:       host,
+			Port:       port,
+			Encryption: encryption,
+			Username:   strings.TrimSpace(m.inputs[setupInputUsername].Value()),
+			Password:   m.inputs[setupInputPassword].Value(),
+			// Only the preset's own host gets its exemption.
+			InsecureSkipVerify: m.account.SMTP.InsecureSkipVerify && host == m.account.SMTP.Host,
+		}
+		return m, m.enterStep(setupStepFrom)
+
+	case setupStepAPIKey:
+		apiKey := strings.TrimSpace(m.inputs[0].Value())
+		if apiKey == "" {
+			m.err = errors.New("API key is required")
+			return m, nil
+		}
+		m.account.Resend.APIKey = apiKey
+		return m, m.enterStep(setupStepFrom)
+
+	case setupStepFrom:
+		from := strings.TrimSpace(m.inputs[setupInputFrom].Value())
+		name := strings.TrimSpace(m.inputs[setupInputAccount].Value())
+		if from == "" {
+			m.err = errors.New("from address is required")
+			return m, nil
+		}
+		if name == "" {
+			name = "default"
+		}
+		if validateLoginName(name) != nil {
+			m.err = fmt.Errorf("invalid account name %q: use letters, digits, - and _", name)
+			return m, nil
+		}
+		m.account.From = from
+		m.name = name
+		cmd := m.enterStep(setupStepTest)
+		if m.account.Method == transportResendOAuth {
+			if _, err := loadAuth(providerResend); err != nil {
+				m.needsAuth = true
+				m.quitting = true
+				return m, tea.Quit
+			}
+		}
+		return m, cmd
+
+	case setupStepTest, setupStepFailed:
+		m.step = setupStepSending
+		m.err = nil
+		m.updateSetupKeymap()
+		return m, tea.Batch(m.spinner.Tick, sendTestCmd(m.account))
+
+	case setupStepSending:
+	}
+	return m, nil
+}
+
+// setupSender returns a sender for the account being set up.
+func setupSender(acct Account) (Sender, error) {
+	switch acct.Method {
+	case transportSMTP:
+		return &SMTPSender{
+			Host:               acct.SMTP.Host,
+			Port:               acct.SMTP.Port,
+			Username:           acct.SMTP.Username,
+			Password:           acct.SMTP.Password,
+			Encryption:         acct.SMTP.Encryption,
+			InsecureSkipVerify: acct.SMTP.InsecureSkipVerify,
+		}, nil
+	case transportResend:
+		return &ResendSender{APIKey: acct.Resend.APIKey}, nil
+	case transportResendOAuth:
+		return &ResendSender{OAuthLogin: providerResend}, nil
+	default:
+		return nil, fmt.Errorf("unknown method %q", acct.Method)
+	}
+}
+
+// sendTestCmd sends a test message from the account to itself.
+func sendTestCmd(acct Account) tea.Cmd {
+	return func() tea.Msg {
+		sender, err := setupSender(acct)
+		if err != nil {
+			return setupTestMsg{err: err}
+		}
+		_, err = sender.Send(context.Background(), Message{
+			From:    acct.From,
+			To:      []string{acct.From},
+			Subject: "Hello from Pop!",
+			Body:    "This is a test message from [Pop](https://github.com/charmbracelet/pop). If you’re reading this, you’re all set.",
+		})
+		return setupTestMsg{err: err}
+	}
+}
+
+// saveSetupCmd adds the account to the config file, with its password or API
+// key in the token store. The account becomes the default if there isn't one
+// yet.
+func saveSetupCmd(name string, acct Account) tea.Cmd {
+	return func() tea.Msg {
+		if err := storeAccountSecret(name, &acct); err != nil {
+			return setupSavedMsg{err: fmt.Errorf("saving the account's secret: %w", err)}
+		}
+		return setupSavedMsg{err: saveAccount(name, acct)}
+	}
+}
+
+func setupHeader() string {
+	return fmt.Sprintf("\n  %s %s", noticeHeaderStyle.SetString("Charm Pop"), "Let’s set up email")
+}
+
+// View renders the setup wizard.
+func (m setupModel) View() tea.View {
+	if m.quitting {
+		return tea.NewView("")
+	}
+
+	var s strings.Builder
+	s.WriteString(setupHeader())
+	s.WriteString("\n\n")
+
+	choice := func(label string, selected bool) {
+		if selected {
+			s.WriteString("  " + activeLabelStyle.Render("• "+label) + "\n")
+		} else {
+			s.WriteString("  " + textStyle.Render("  "+label) + "\n")
+		}
+	}
+
+	switch m.step {
+	case setupStepMethod:
+		s.WriteString("  How would you like to send email?\n\n")
+		for i, method := range setupMethods {
+			choice(method.label, i == m.method)
+		}
+	case setupStepProvider:
+		s.WriteString("  Which provider do you use?\n\n")
+		for i, preset := range smtpPresets {
+			choice(preset.Label, i == m.provider)
+		}
+		choice("Other", m.provider == len(smtpPresets))
+	case setupStepSMTP:
+		s.WriteString("  Enter your SMTP server details.\n\n")
+	case setupStepAPIKey:
+		s.WriteString("  Paste your Resend API key. You can create one at " + linkStyle.Render("https://resend.com/api-keys") + ".\n")
+		s.WriteString("  With full access, Pop can check your From address against your verified domains.\n\n")
+	case setupStepFrom:
+		s.WriteString("  Who are you sending as, and what should we call this account?\n\n")
+	case setupStepTest:
+		s.WriteString("  Let’s send a test email to " + linkStyle.Render(m.account.From) + " to make sure everything works.\n")
+	case setupStepSending:
+		s.WriteString("  " + m.spinner.View() + "Sending test email...\n")
+	case setupStepFailed:
+		s.WriteString("  " + errorStyle.Render("That didn’t work.") + " Retry, or go back and check your settings.\n")
+	}
+
+	for _, input := range m.inputs {
+		s.WriteString("  " + input.View() + "\n")
+	}
+
+	if m.err != nil {
+		wrap := lipgloss.NewStyle().MaxWidth(max(m.width-2, 20)) //nolint:mnd
+		s.WriteString("\n  " + errorStyle.Render(wrap.Render(m.err.Error())) + "\n")
+	}
+
+	s.WriteString("\n  " + m.help.View(m.keymap))
+	return tea.NewView(s.String())
+}
+
+// runSetup runs the setup wizard, running the OAuth flow along the way if
+// needed. It returns the name of the saved account, or an empty string if
+// the user canceled.
+func runSetup() (string, error) {
+	// The account's secret goes in the token store.
+	if err := unlockTokenStore(true); err != nil {
+		return "", err
+	}
+	m := newSetupModel()
+	for {
+		final, err := tea.NewProgram(m).Run()
+		if err != nil {
+			return "", fmt.Errorf("running setup: %w", err)
+		}
+		var ok bool
+		m, ok = final.(setupModel)
+		if !ok {
+			return "", errors.New("unexpected setup program model")
+		}
+		if !m.needsAuth {
+			break
+		}
+
+		// Authenticate, then pick up where we left off.
+		provider, err := lookupOAuthProvider(providerResend)
+		if err != nil {
+			return "", err
+		}
+		if err := startOAuthFlowTUI(provider, authOptions{}); err != nil {
+			return "", err
+		}
+		if _, err := loadAuth(providerResend); err != nil {
+			// The user canceled authentication.
+			return "", nil
+		}
+		m.needsAuth = false
+		m.quitting = false
+	}
+	if !m.saved {
+		return "", nil
+	}
+	return m.name, nil
+}
+
+func setupSuccessView(name string) string {
+	path, _ := configFilePath()
+	return fmt.Sprintf("\n  %s Saved account %s to %s\n",
+		noticeHeaderStyle.SetString("OKAY!"),
+		inlineCodeStyle.Render(name),
+		tildePath(path))
+}
+
+// SetupCmd runs the interactive setup wizard.
+var SetupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Set up email delivery interactively",
+	Long:  `Walk through choosing Resend or SMTP, entering credentials, and sending a test email. The result is saved as an account in the config file.`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		if !term.IsTerminal(os.Stdin.Fd()) {
+			return errors.New("setup needs an interactive terminal")
+		}
+		name, err := runSetup()
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			fmt.Println("\n  Setup canceled.")
+			return nil
+		}
+		fmt.Println(setupSuccessView(name))
+		return nil
+	},
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SASL mechanisms.
+const (
+	saslPlain       = "PLAIN"
+	saslLogin       = "LOGIN"
+	saslXOAUTH2     = "XOAUTH2"
+	saslOAuthBearer = "OAUTHBEARER"
+)
+
+// smtpAuthAuto lets pop pick the SASL mechanism.
+const smtpAuthAuto = "auto"
+
+// smtpAuthMechanisms are the values accepted by --smtp.auth.
+var smtpAuthMechanisms = []string{smtpAuthAuto, "plain", "login", "xoauth2", "oauthbearer"}
+
+// plainAuth implements the PLAIN SASL mechanism. Unlike smtp.PlainAuth it
+// doesn't refuse to authenticate over an unencrypted connection, matching
+// what pop does when sending.
+type plainAuth struct {
+	username, password string
+}
+
+func (a plainAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return saslPlain, []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a plainAuth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("unexpected server challenge")
+	}
+	return nil, nil
+}
+
+// loginAuth implements the LOGIN SASL mechanism.
+type loginAuth struct {
+	username, password string
+}
+
+func (a loginAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return saslLogin, nil, nil
+}
+
+func (a loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSuffix(string(fromServer), ":")) {
+	case "username":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	default:
+		return nil, errors.New("unexpected server challenge: " + string(fromServer))
+	}
+}
+
+// passwordAuth picks PLAIN or LOGIN, depending on what the server advertises
+// in its AUTH extension. It returns nil if neither is supported.
+func passwordAuth(mechanisms, username, password string) smtp.Auth {
+	offered := strings.Fields(strings.ToUpper(mechanisms))
+	switch {
+	case slices.Contains(offered, saslPlain):
+		return plainAuth{username, password}
+	case slices.Contains(offered, saslLogin):
+		return loginAuth{username, password}
+	default:
+		return nil
+	}
+}
+
+// xoauth2Auth implements Google's and Microsoft's XOAUTH2 SASL mechanism.
+type xoauth2Auth struct {
+	username, token string
+}
+
+func (a xoauth2Auth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return saslXOAUTH2, []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a xoauth2Auth) Next(_ []byte, more bool) ([]byte, error) {
+	// On failure the server sends a JSON error as a challenge and expects
+	// an empty response before replying with the actual error.
+	if more {
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// oauthBearerAuth implements the OAUTHBEARER SASL mechanism (RFC 7628).
+type oauthBearerAuth struct {
+	username, token string
+	host            string
+	port            int
+}
+
+func (a oauthBearerAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	// "=" and "," are escaped in the authorization identity (RFC 5801).
+	user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(a.username)
+	resp := "n,a=" + user + ",\x01"
+	if a.host != "" {
+		resp += "host=" + a.host + "\x01"
+	}
+	if a.port != 0 {
+		resp += "port=" + strconv.Itoa(a.port) + "\x01"
+	}
+	resp += "auth=Bearer " + a.token + "\x01\x01"
+	return saslOAuthBearer, []byte(resp), nil
+}
+
+func (a oauthBearerAuth) Next(_ []byte, more bool) ([]byte, error) {
+	// RFC 7628 has the client acknowledge an error challenge with a
+	// single ^A, after which the server reports the failure.
+	if more {
+		return []byte{0x01}, nil
+	}
+	return nil, nil
+}
+
+// mechanism returns the SASL mechanism selected with --smtp.auth, in upper
+// case, or an empty string for auto.
+func (s *SMTPSender) mechanism() (string, error) {
+	m := strings.ToLower(s.Auth)
+	switch {
+	case m == "" || m == smtpAuthAuto:
+		return "", nil
+	case slices.Contains(smtpAuthMechanisms, m):
+		return strings.ToUpper(m), nil
+	default:
+		return "", fmt.Errorf("unknown SMTP auth mechanism %q (expected one of %s)", s.Auth, strings.Join(smtpAuthMechanisms, ", "))
+	}
+}
+
+// auth returns the smtp.Auth to log in with, given the mechanisms the server
+// advertises in its AUTH extension.
+func (s *SMTPSender) auth(mechanisms string) (smtp.Auth, error) {
+	mechanism, err := s.mechanism()
+	if err != nil {
+		return nil, err
+	}
+	offered := strings.Fields(strings.ToUpper(mechanisms))
+	if len(offered) == 0 {
+		return nil, errors.New("server doesn't advertise AUTH")
+	}
+
+	if mechanism == "" {
+		// Without a password, fall back to a bearer token if one is
+		// available.
+		if s.Password == "" && s.hasTokenSource() {
+			switch {
+			case slices.Contains(offered, saslXOAUTH2):
+				mechanism = saslXOAUTH2
+			case slices.Contains(offered, saslOAuthBearer):
+				mechanism = saslOAuthBearer
+			}
+		}
+		if mechanism == "" {
+			if auth := passwordAuth(mechanisms, s.Username, s.Password); auth != nil {
+				return auth, nil
+			}
+			return nil, fmt.Errorf("no supported auth mechanism in %q", mechanisms)
+		}
+	}
+	if !slices.Contains(offered, mechanism) {
+		return nil, fmt.Errorf("server doesn't offer %s (offers %s)", mechanism, strings.Join(offered, ", "))
+	}
+
+	switch mechanism {
+	case saslPlain:
+		return plainAuth{s.Username, s.Password}, nil
+	case saslLogin:
+		return loginAuth{s.Username, s.Password}, nil
+	}
+	token, err := s.bearerToken()
+	if err != nil {
+		return nil, err
+	}
+	if mechanism == saslXOAUTH2 {
+		return xoauth2Auth{s.Username, token}, nil
+	}
+	host, port := s.addr()
+	return oauthBearerAuth{username: s.Username, token: token, host: host, port: port}, nil
+}
+
+// oauthProvider returns the name of the OAuth provider whose stored token is
+// used for this sender, based on its provider preset.
+func (s *SMTPSender) oauthProvider() string {
+	preset, ok := s.preset()
+	if !ok {
+		return ""
+	}
+	return preset.OAuth
+}
+
+// hasTokenSource reports whether a bearer token command is configured or a
+// token for the sender's provider has been stored.
+func (s *SMTPSender) hasTokenSource() bool {
+	if s.TokenCmd != "" {
+		return true
+	}
+	provider := s.oauthProvider()
+	if provider == "" {
+		return false
+	}
+	_, err := loadAuth(provider)
+	return err == nil
+}
+
+// bearerToken returns the OAuth bearer token to authenticate with, running
+// TokenCmd if set and otherwise reading (and if need be refreshing) the
+// provider's token from the token store.
+func (s *SMTPSender) bearerToken() (string, error) {
+	if s.TokenCmd != "" {
+		token, err := runSecretCmd(s.TokenCmd)
+		if err != nil {
+			return "", fmt.Errorf("getting SMTP token: %w", err)
+		}
+		if token == "" {
+			return "", fmt.Errorf("getting SMTP token: %q printed nothing", s.TokenCmd)
+		}
+		return token, nil
+	}
+
+	provider := s.oauthProvider()
+	if provider == "" {
+		return "", errors.New("no SMTP OAuth token: set $" + PopSMTPTokenCmd)
+	}
+	token, err := getValidAccessToken(provider)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", &reauthError{
+			login: provider,
+			err:   fmt.Errorf("no %s OAuth token stored: run `%s` or set $%s", provider, authCommand(provider), PopSMTPTokenCmd),
+		}
+	}
+	return token, nil
+}
+
+// tokenLogin returns the login key of the stored OAuth token the sender
+// authenticates with, if any. It mirrors the choice auth makes, short of
+// knowing which mechanisms the server offers.
+func (s *SMTPSender) tokenLogin() string {
+	if s.Username == "" || s.TokenCmd != "" {
+		return ""
+	}
+	mechanism, err := s.mechanism()
+	if err != nil {
+		return ""
+	}
+	switch mechanism {
+	case saslXOAUTH2, saslOAuthBearer:
+		return s.oauthProvider()
+	case "":
+		if s.Password == "" && s.hasTokenSource() {
+			return s.oauthProvider()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// googleAuthChallenge is the error challenge Gmail sends when it rejects a
+// token.
+const googleAuthChallenge = `{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`
+
+func TestSMTPOAuthExchanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     string
+		username string
+		// wantInitial is the initial client response; %PORT% is the
+		// stub's port.
+		wantInitial string
+	}{
+		{
+			name:        "xoauth2",
+			auth:        "xoauth2",
+			username:    "me@example.com",
+			wantInitial: "user=me@example.com\x01auth=Bearer tok123\x01\x01",
+		},
+		{
+			name:        "oauthbearer",
+			auth:        "oauthbearer",
+			username:    "me@example.com",
+			wantInitial: "n,a=me@example.com,\x01host=127.0.0.1\x01port=%PORT%\x01auth=Bearer tok123\x01\x01",
+		},
+		{
+			name:        "oauthbearer escapes the authzid",
+			auth:        "oauthbearer",
+			username:    "odd=name,x@example.com",
+			wantInitial: "n,a=odd=3Dname=2Cx@example.com,\x01host=127.0.0.1\x01port=%PORT%\x01auth=Bearer tok123\x01\x01",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := startSMTPStub(t, &smtpStub{mechanisms: "PLAIN XOAUTH2 OAUTHBEARER"})
+			sender := stub.sender()
+			sender.Username = tt.username
+			sender.Auth = tt.auth
+			sender.TokenCmd = "echo tok123"
+
+			_, err := sender.Send(context.Background(), Message{
+				From:    "me@example.com",
+				To:      []string{"you@example.com"},
+				Subject: "Hi",
+				Body:    "Hello",
+			})
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			auths := stub.recordedAuths()
+			if len(auths) != 1 {
+				t.Fatalf("got %d AUTH exchanges, want 1", len(auths))
+			}
+			want := strings.ReplaceAll(tt.wantInitial, "%PORT%", strconv.Itoa(stub.port))
+			if got := auths[0]; got.mechanism != strings.ToUpper(tt.auth) || got.initial != want {
+				t.Errorf("AUTH %s %q, want AUTH %s %q", got.mechanism, got.initial, strings.ToUpper(tt.auth), want)
+			}
+		})
+	}
+}
+
+func TestSMTPOAuthErrorChallenge(t *testing.T) {
+	tests := []struct {
+		auth             string
+		wantContinuation string
+	}{
+		// Google and Microsoft expect an empty response.
+		{"xoauth2", ""},
+		// RFC 7628 has the client send a single ^A.
+		{"oauthbearer", "\x01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.auth, func(t *testing.T) {
+			stub := startSMTPStub(t, &smtpStub{
+				mechanisms: "XOAUTH2 OAUTHBEARER",
+				rejectAuth: true,
+				challenge:  googleAuthChallenge,
+			})
+			sender := stub.sender()
+			sender.Username = "me@example.com"
+			sender.Auth = tt.auth
+			sender.TokenCmd = "echo expired"
+
+			_, err := sender.Send(context.Background(), Message{
+				From:    "me@example.com",
+				To:      []string{"you@example.com"},
+				Subject: "Hi",
+				Body:    "Hello",
+			})
+			if err == nil || !strings.Contains(err.Error(), "535") {
+				t.Fatalf("Send error = %v, want the server's 535", err)
+			}
+
+			auths := stub.recordedAuths()
+			if len(auths) != 1 {
+				t.Fatalf("got %d AUTH exchanges, want 1", len(auths))
+			}
+			if got := auths[0].continuation; got != tt.wantContinuation {
+				t.Errorf("response to the error challenge = %q, want %q", got, tt.wantContinuation)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+)
+
+// PopDNSServer is the environment variable that points SMTP discovery at a
+// specific DNS server (host:port) instead of the system resolver.
+const PopDNSServer = "POP_DNS_SERVER"
+
+// discoveryTimeout bounds the SRV lookups made during SMTP discovery.
+const discoveryTimeout = 5 * time.Second
+
+// srvResolver looks up DNS SRV records. *net.Resolver satisfies it.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// smtpResolver is the resolver used for SMTP discovery. It's a variable so it
+// can be swapped for a stand-in.
+var smtpResolver srvResolver = newSRVResolver(os.Getenv(PopDNSServer))
+
+// newSRVResolver returns a resolver that queries the given DNS server, or the
+// system resolver if server is empty.
+func newSRVResolver(server string) srvResolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// discoveredSMTP is a submission server found through DNS.
+type discoveredSMTP struct {
+	Host       string
+	Port       int
+	Encryption string
+	// Record is the SRV record name the server was found under.
+	Record string
+}
+
+// errNoSubmissionService is returned when a domain has no usable RFC 6186
+// submission records.
+var errNoSubmissionService = errors.New("no submission SRV records")
+
+// discoverSMTP finds the submission server for domain using the RFC 6186
+// _submissions._tcp (implicit TLS) and _submission._tcp (STARTTLS) SRV
+// records. The record with the lowest priority wins, preferring implicit TLS
+// on a tie as RFC 8314 recommends.
+func discoverSMTP(ctx context.Context, r srvResolver, domain string) (discoveredSMTP, error) {
+	services := []struct {
+		service    string
+		encryption string
+	}{
+		{"submissions", encryptionSSL},
+		{"submission", encryptionSTARTTLS},
+	}
+
+	var (
+		best    discoveredSMTP
+		bestPri = -1
+		errs    []error
+	)
+	for _, svc := range services {
+		cname, addrs, err := r.LookupSRV(ctx, svc.service, "tcp", domain)
+		if err != nil {
+			var dnsErr *net.DNSError
+			if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		srv := preferredSRV(addrs)
+		if srv == nil {
+			continue
+		}
+		// The lookups are in order of preference, so a tie keeps the
+		// earlier service.
+		if bestPri < 0 || int(srv.Priority) < bestPri {
+			bestPri = int(srv.Priority)
+			best = discoveredSMTP{
+				Host:       strings.TrimSuffix(srv.Target, "."),
+				Port:       int(srv.Port),
+				Encryption: svc.encryption,
+				Record:     strings.TrimSuffix(cname, "."),
+			}
+		}
+	}
+	if bestPri >= 0 {
+		return best, nil
+	}
+	if len(errs) > 0 {
+		return discoveredSMTP{}, fmt.Errorf("looking up SRV records for %s: %w", domain, errors.Join(errs...))
+	}
+	return discoveredSMTP{}, fmt.Errorf("%s: %w", domain, errNoSubmissionService)
+}
+
+// preferredSRV returns the usable record with the lowest priority, and the
+// highest weight among those, or nil if there is none. It doesn't rely on the
+// resolver's order: net.Resolver shuffles records of equal priority by
+// weight, and a stand-in may not sort them at all.
+func preferredSRV(addrs []*net.SRV) *net.SRV {
+	var best *net.SRV
+	for _, srv := range addrs {
+		// A target of "." means the service is decidedly not available
+		// at this domain.
+		if strings.TrimSuffix(srv.Target, ".") == "" || srv.Port == 0 {
+			continue
+		}
+		if best == nil || srv.Priority < best.Priority ||
+			srv.Priority == best.Priority && srv.Weight > best.Weight {
+			best = srv
+		}
+	}
+	return best
+}
+
+// discoverSMTPServer runs SMTP discovery for the current settings. Discovery
+// only happens when no host or provider preset applies, using the domain of
+// the username, or of the From address if the username isn't an address.
+func discoverSMTPServer() (discoveredSMTP, bool) {
+	if smtpHost != "" {
+		return discoveredSMTP{}, false
+	}
+	if _, ok := (&SMTPSender{Provider: smtpProvider, Username: smtpUsername}).preset(); ok || smtpProvider != "" {
+		return discoveredSMTP{}, false
+	}
+	domain := addressDomain(smtpUsername)
+	if domain == "" {
+		domain = addressDomain(from)
+	}
+	if domain == "" {
+		return discoveredSMTP{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	d, err := discoverSMTP(ctx, smtpResolver, domain)
+	if err != nil {
+		logVerbose("SMTP discovery: %v", err)
+		return discoveredSMTP{}, false
+	}
+	logVerbose("SMTP discovery: found %s:%d (%s) via %s", d.Host, d.Port, d.Encryption, d.Record)
+	return d, true
+}
+
+// addressDomain returns the lowercased domain of an email address, or an
+// empty string if it doesn't look like one.
+func addressDomain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(address[at+1:]), ">"))
+}
+
+// logVerbose prints a diagnostic line to stderr when --verbose is set.
+func logVerbose(format string, args ...any) {
+	if !verbose {
+		return
+	}
+	w := colorprofile.NewWriter(os.Stderr, os.Environ())
+	_, _ = fmt.Fprintln(w, commentStyle.Render(fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeSRVResolver answers SRV lookups from a table keyed by service. Services
+// that aren't in the table don't exist.
+type fakeSRVResolver map[string]fakeSRVAnswer
+
+type fakeSRVAnswer struct {
+	addrs []*net.SRV
+	err   error
+}
+
+func (r fakeSRVResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	record := "_" + service + "._" + proto + "." + name + "."
+	answer, ok := r[service]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: record, IsNotFound: true}
+	}
+	if answer.err != nil {
+		return "", nil, answer.err
+	}
+	return record, answer.addrs, nil
+}
+
+func TestDiscoverSMTP(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver fakeSRVResolver
+		want     discoveredSMTP
+		wantErr  error
+	}{
+		{
+			name: "lowest priority wins",
+			resolver: fakeSRVResolver{
+				"submission": {addrs: []*net.SRV{
+					{Target: "backup.example.com.", Port: 587, Priority: 20, Weight: 100},
+					{Target: "smtp.example.com.", Port: 587, Priority: 10},
+				}},
+			},
+			want: discoveredSMTP{Host: "smtp.example.com", Port: 587, Encryption: encryptionSTARTTLS, Record: "_submission._tcp.example.com"},
+		},
+		{
+			name: "highest weight wins a priority tie",
+			resolver: fakeSRVResolver{
+				"submissions": {addrs: []*net.SRV{
+					{Target: "light.example.com.", Port: 465, Priority: 10, Weight: 10},
+					{Target: "heavy.example.com.", Port: 465, Priority: 10, Weight: 90},
+					{Target: "backup.example.com.", Port: 465, Priority: 20, Weight: 100},
+				}},
+			},
+			want: discoveredSMTP{Host: "heavy.example.com", Port: 465, Encryption: encryptionSSL, Record: "_submissions._tcp.example.com"},
+		},
+		{
+			name: "implicit TLS wins a tie with STARTTLS",
+			resolver: fakeSRVResolver{
+				"submissions": {addrs: []*net.SRV{{Target: "tls.example.com.", Port: 465, Priority: 10}}},
+				"submission":  {addrs: []*net.SRV{{Target: "starttls.example.com.", Port: 587, Priority: 10, Weight: 50}}},
+			},
+			want: discoveredSMTP{Host: "tls.example.com", Port: 465, Encryption: encryptionSSL, Record: "_submissions._tcp.example.com"},
+		},
+		{
+			name: "STARTTLS with a lower priority wins",
+			resolver: fakeSRVResolver{
+				"submissions": {addrs: []*net.SRV{{Target: "tls.example.com.", Port: 465, Priority: 20}}},
+				"submission":  {addrs: []*net.SRV{{Target: "starttls.example.com.", Port: 587, Priority: 10}}},
+			},
+			want: discoveredSMTP{Host: "starttls.example.com", Port: 587, Encryption: encryptionSTARTTLS, Record: "_submission._tcp.example.com"},
+		},
+		{
+			name: "no-service target is skipped",
+			resolver: fakeSRVResolver{
+				"submissions": {addrs: []*net.SRV{{Target: ".", Port: 0, Priority: 0}}},
+				"submission":  {addrs: []*net.SRV{{Target: "smtp.example.com.", Port: 587, Priority: 10}}},
+			},
+			want: discoveredSMTP{Host: "smtp.example.com", Port: 587, Encryption: encryptionSTARTTLS, Record: "_submission._tcp.example.com"},
+		},
+		{
+			name: "only no-service targets",
+			resolver: fakeSRVResolver{
+				"submissions": {addrs: []*net.SRV{{Target: ".", Port: 0}}},
+				"submission":  {addrs: []*net.SRV{{Target: ".", Port: 0}}},
+			},
+			wantErr: errNoSubmissionService,
+		},
+		{
+			name:     "no records",
+			resolver: fakeSRVResolver{},
+			wantErr:  errNoSubmissionService,
+		},
+		{
+			name: "a failed lookup doesn't hide the other service",
+			resolver: fakeSRVResolver{
+				"submissions": {err: &net.DNSError{Err: "server misbehaving", IsTemporary: true}},
+				"submission":  {addrs: []*net.SRV{{Target: "smtp.example.com.", Port: 587}}},
+			},
+			want: discoveredSMTP{Host: "smtp.example.com", Port: 587, Encryption: encryptionSTARTTLS, Record: "_submission._tcp.example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := discoverSMTP(context.Background(), tt.resolver, "example.com")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("discoverSMTP error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("discoverSMTP: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("discoverSMTP = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("failed lookups are reported", func(t *testing.T) {
+		lookupErr := &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+		_, err := discoverSMTP(context.Background(), fakeSRVResolver{"submission": {err: lookupErr}}, "example.com")
+		if !errors.Is(err, lookupErr) || errors.Is(err, errNoSubmissionService) {
+			t.Errorf("discoverSMTP error = %v, want the lookup error", err)
+		}
+	})
+}
+
+func TestDiscoverSMTPServer(t *testing.T) {
+	resolver := fakeSRVResolver{
+		"submission": {addrs: []*net.SRV{{Target: "mail.example.org.", Port: 587}}},
+	}
+	tests := []struct {
+		name     string
+		host     string
+		provider string
+		username string
+		from     string
+		found    bool
+	}{
+		{name: "username domain", username: "me@example.org", found: true},
+		{name: "From domain", username: "me", from: "Me <me@example.org>", found: true},
+		{name: "host set", host: "smtp.example.org", username: "me@example.org"},
+		{name: "provider set", provider: "fastmail", username: "me@example.org"},
+		{name: "preset from the username", username: "me@gmail.com"},
+		{name: "no domain", username: "me"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldResolver, oldHost, oldProvider, oldUsername := smtpResolver, smtpHost, smtpProvider, smtpUsername
+			t.Cleanup(func() {
+				smtpResolver, smtpHost, smtpProvider, smtpUsername = oldResolver, oldHost, oldProvider, oldUsername
+			})
+			smtpResolver, smtpHost, smtpProvider, smtpUsername = resolver, tt.host, tt.provider, tt.username
+			useFrom(t, tt.from)
+
+			d, ok := discoverSMTPServer()
+			if ok != tt.found {
+				t.Fatalf("discoverSMTPServer found = %v, want %v", ok, tt.found)
+			}
+			if ok && d.Host != "mail.example.org" {
+				t.Errorf("discovered host = %q, want mail.example.org", d.Host)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// defaultSMTPPort is the submission port used when neither the user nor a
+// preset picks one.
+const defaultSMTPPort = 587
+
+// smtpPreset holds the submission settings of a well-known mail provider.
+type smtpPreset struct {
+	// Name identifies the preset, e.g. "gmail".
+	Name string
+	// Label is the provider's display name.
+	Label      string
+	Host       string
+	Port       int
+	Encryption string
+	// InsecureSkipVerify skips TLS verification, for a server on this
+	// machine with a self-signed certificate.
+	InsecureSkipVerify bool
+	// OAuth is the OAuth provider whose stored token is used for XOAUTH2
+	// and OAUTHBEARER, if any.
+	OAuth string
+	// Domains are the address domains the preset is inferred from when no
+	// provider is given explicitly.
+	Domains []string
+}
+
+// smtpPresets are the known providers, in the order they're offered to the
+// user.
+var smtpPresets = []smtpPreset{
+	{
+		Name: "gmail", Label: "Gmail",
+		Host: "smtp.gmail.com", Port: 587, Encryption: encryptionSTARTTLS,
+		OAuth:   providerGoogle,
+		Domains: []string{"gmail.com", "googlemail.com"},
+	},
+	{
+		Name: "outlook", Label: "Outlook / Office 365",
+		Host: "smtp.office365.com", Port: 587, Encryption: encryptionSTARTTLS,
+		OAuth:   providerMicrosoft,
+		Domains: []string{"outlook.com", "hotmail.com", "live.com", "msn.com"},
+	},
+	{
+		Name: "fastmail", Label: "Fastmail",
+		Host: "smtp.fastmail.com", Port: 465, Encryption: encryptionSSL,
+		Domains: []string{"fastmail.com", "fastmail.fm"},
+	},
+	{
+		Name: "icloud", Label: "iCloud",
+		Host: "smtp.mail.me.com", Port: 587, Encryption: encryptionSTARTTLS,
+		Domains: []string{"icloud.com", "me.com", "mac.com"},
+	},
+	{
+		Name: "yahoo", Label: "Yahoo",
+		Host: "smtp.mail.yahoo.com", Port: 465, Encryption: encryptionSSL,
+		Domains: []string{"yahoo.com", "ymail.com", "rocketmail.com"},
+	},
+	{
+		Name: "zoho", Label: "Zoho Mail",
+		Host: "smtp.zoho.com", Port: 465, Encryption: encryptionSSL,
+		Domains: []string{"zoho.com", "zohomail.com"},
+	},
+	{
+		// Proton Mail only supports SMTP through the Bridge app, which
+		// listens locally with a self-signed certificate.
+		Name: "proton-bridge", Label: "Proton Mail Bridge",
+		Host: "127.0.0.1", Port: 1025, Encryption: encryptionSTARTTLS,
+		InsecureSkipVerify: true,
+		Domains:            []string{"proton.me", "protonmail.com", "pm.me"},
+	},
+	{
+		Name: "mailgun", Label: "Mailgun",
+		Host: "smtp.mailgun.org", Port: 587, Encryption: encryptionSTARTTLS,
+	},
+	{
+		Name: "sendgrid", Label: "SendGrid",
+		Host: "smtp.sendgrid.net", Port: 587, Encryption: encryptionSTARTTLS,
+	},
+}
+
+// lookupSMTPPreset returns the preset with the given name.
+func lookupSMTPPreset(name string) (smtpPreset, error) {
+	for _, p := range smtpPresets {
+		if strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	return smtpPreset{}, fmt.Errorf("unknown SMTP provider %q (expected one of %s)", name, strings.Join(smtpPresetNames(), ", "))
+}
+
+// inferSMTPPreset returns the preset whose domains include the domain of the
+// given address.
+func inferSMTPPreset(address string) (smtpPreset, bool) {
+	domain := addressDomain(address)
+	if domain == "" {
+		return smtpPreset{}, false
+	}
+	for _, p := range smtpPresets {
+		if slices.Contains(p.Domains, domain) {
+			return p, true
+		}
+	}
+	return smtpPreset{}, false
+}
+
+// smtpPresetNames returns the names of the known presets.
+func smtpPresetNames() []string {
+	names := make([]string, len(smtpPresets))
+	for i, p := range smtpPresets {
+		names[i] = p.Name
+	}
+	return names
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookupSMTPPreset(t *testing.T) {
+	for _, name := range []string{"gmail", "GMail", "proton-bridge", "sendgrid"} {
+		p, err := lookupSMTPPreset(name)
+		if err != nil {
+			t.Errorf("lookupSMTPPreset(%q): %v", name, err)
+			continue
+		}
+		if !strings.EqualFold(p.Name, name) {
+			t.Errorf("lookupSMTPPreset(%q) = %s", name, p.Name)
+		}
+	}
+	_, err := lookupSMTPPreset("no-such-provider")
+	if err == nil || !strings.Contains(err.Error(), `unknown SMTP provider "no-such-provider"`) || !strings.Contains(err.Error(), "gmail, outlook") {
+		t.Errorf("lookupSMTPPreset(no-such-provider) error = %v, want one listing the providers", err)
+	}
+}
+
+func TestInferSMTPPreset(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"me@gmail.com", "gmail"},
+		{"me@googlemail.com", "gmail"},
+		{"Me <me@Hotmail.com>", "outlook"},
+		{"me@fastmail.fm", "fastmail"},
+		{"me@pm.me", "proton-bridge"},
+		{"me@example.com", ""},
+		{"me@gmail.com.example", ""},
+		{"nobody", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		p, ok := inferSMTPPreset(tt.address)
+		if ok != (tt.want != "") || p.Name != tt.want {
+			t.Errorf("inferSMTPPreset(%q) = %q, %v; want %q", tt.address, p.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestSMTPSenderPreset(t *testing.T) {
+	tests := []struct {
+		name       string
+		sender     SMTPSender
+		host       string
+		port       int
+		encryption string
+		insecure   bool
+	}{
+		{
+			name:   "provider",
+			sender: SMTPSender{Provider: "fastmail"},
+			host:   "smtp.fastmail.com", port: 465, encryption: encryptionSSL,
+		},
+		{
+			name:   "inferred from the username",
+			sender: SMTPSender{Username: "me@yahoo.com"},
+			host:   "smtp.mail.yahoo.com", port: 465, encryption: encryptionSSL,
+		},
+		{
+			name:   "provider beats the username",
+			sender: SMTPSender{Provider: "gmail", Username: "me@yahoo.com"},
+			host:   "smtp.gmail.com", port: 587, encryption: encryptionSTARTTLS,
+		},
+		{
+			name:   "explicit port and encryption beat the preset",
+			sender: SMTPSender{Provider: "fastmail", Port: 587, Encryption: encryptionSTARTTLS},
+			host:   "smtp.fastmail.com", port: 587, encryption: encryptionSTARTTLS,
+		},
+		{
+			name:   "explicit host turns off inference",
+			sender: SMTPSender{Host: "mail.example.com", Username: "me@gmail.com"},
+			host:   "mail.example.com", port: defaultSMTPPort, encryption: encryptionSTARTTLS,
+		},
+		{
+			name:   "explicit host with a provider keeps its port",
+			sender: SMTPSender{Provider: "zoho", Host: "smtp.zoho.eu"},
+			host:   "smtp.zoho.eu", port: 465, encryption: encryptionSSL,
+		},
+		{
+			name:   "Proton Mail Bridge skips verification",
+			sender: SMTPSender{Provider: "proton-bridge"},
+			host:   "127.0.0.1", port: 1025, encryption: encryptionSTARTTLS, insecure: true,
+		},
+		{
+			name:   "but not on another host",
+			sender: SMTPSender{Provider: "proton-bridge", Host: "bridge.example.com"},
+			host:   "bridge.example.com", port: 1025, encryption: encryptionSTARTTLS,
+		},
+		{
+			name:   "no preset",
+			sender: SMTPSender{Username: "me@example.com"},
+			host:   "", port: defaultSMTPPort, encryption: encryptionSTARTTLS,
+		},
+	}
+	for _, tt := range tests {
+		host, port := tt.sender.addr()
+		if host != tt.host || port != tt.port {
+			t.Errorf("%s: addr() = %s:%d, want %s:%d", tt.name, host, port, tt.host, tt.port)
+		}
+		if got := tt.sender.encryption(); got != tt.encryption {
+			t.Errorf("%s: encryption() = %s, want %s", tt.name, got, tt.encryption)
+		}
+		if got := tt.sender.insecureSkipVerify(); got != tt.insecure {
+			t.Errorf("%s: insecureSkipVerify() = %v, want %v", tt.name, got, tt.insecure)
+		}
+	}
+}
+
+func TestSMTPPresetOverrides(t *testing.T) {
+	useTestDirs(t)
+	t.Setenv(PopSMTPPort, "")
+	t.Setenv(PopSMTPEncryption, "")
+	useAccounts(t, `[accounts.preset]
+method = "smtp"
+[accounts.preset.smtp]
+provider = "fastmail"
+username = "me@fastmail.com"
+
+[accounts.custom]
+method = "smtp"
+[accounts.custom.smtp]
+provider = "fastmail"
+username = "me@fastmail.com"
+port = 2525
+encryption = "starttls"
+`)
+	newSender := func(account string) *SMTPSender {
+		t.Helper()
+		if _, _, err := useAccount(account); err != nil {
+			t.Fatal(err)
+		}
+		transport, _ := lookupTransport(transportSMTP)
+		sender, err := transport.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sender.(*SMTPSender)
+	}
+	check := func(name string, s *SMTPSender, port int, encryption string) {
+		t.Helper()
+		if _, got := s.addr(); got != port {
+			t.Errorf("%s: port %d, want %d", name, got, port)
+		}
+		if got := s.encryption(); got != encryption {
+			t.Errorf("%s: encryption %s, want %s", name, got, encryption)
+		}
+	}
+
+	check("preset", newSender("preset"), 465, encryptionSSL)
+	check("set by the account", newSender("custom"), 2525, encryptionSTARTTLS)
+
+	t.Run("flags", func(t *testing.T) {
+		for name, value := range map[string]string{"smtp.port": "25", "smtp.encryption": encryptionNone} {
+			f := accountFlags.Lookup(name)
+			old := f.Value.String()
+			t.Cleanup(func() {
+				_ = f.Value.Set(old)
+				f.Changed = false
+			})
+			if err := accountFlags.Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check("set by flags", newSender("custom"), 25, encryptionNone)
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		// Like the flags, whose defaults come from the environment.
+		t.Setenv(PopSMTPPort, "587")
+		t.Setenv(PopSMTPEncryption, encryptionSTARTTLS)
+		oldPort, oldEncryption := smtpPort, smtpEncryption
+		t.Cleanup(func() { smtpPort, smtpEncryption = oldPort, oldEncryption })
+		smtpPort, smtpEncryption = 587, encryptionSTARTTLS
+		check("set by the environment", newSender("preset"), 587, encryptionSTARTTLS)
+	})
+}
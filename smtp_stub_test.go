@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStub is a minimal SMTP server for tests. It accepts any mail, and
+// records the AUTH exchanges it sees.
+type smtpStub struct {
+	host string
+	port int
+
+	// mechanisms are the SASL mechanisms advertised in EHLO.
+	mechanisms string
+	// tlsConfig, when set, makes the server offer STARTTLS with it.
+	tlsConfig *tls.Config
+	// rejectAuth makes AUTH fail with 535. When challenge is set, the
+	// server first sends it as an error challenge and waits for the
+	// client's response.
+	rejectAuth bool
+	challenge  string
+	// stall makes the server stop replying once it gets the named
+	// command, or "." for the end of the message.
+	stall string
+
+	mu    sync.Mutex
+	auths []smtpStubAuth
+	// conns and messages count the connections and messages seen.
+	conns    int
+	messages int
+}
+
+// smtpStubAuth is an AUTH exchange seen by smtpStub.
+type smtpStubAuth struct {
+	mechanism string
+	// initial is the decoded initial client response.
+	initial string
+	// continuation is the decoded response to the error challenge, if one
+	// was sent.
+	continuation string
+}
+
+// startSMTPStub starts stub on a loopback port until the test ends.
+func startSMTPStub(t *testing.T, stub *smtpStub) *smtpStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	addr := ln.Addr().(*net.TCPAddr)
+	stub.host, stub.port = addr.IP.String(), addr.Port
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	return stub
+}
+
+// sender returns an SMTP sender for the stub, without encryption unless the
+// stub offers STARTTLS.
+func (s *smtpStub) sender() *SMTPSender {
+	encryption := encryptionNone
+	if s.tlsConfig != nil {
+		encryption = encryptionSTARTTLS
+	}
+	return &SMTPSender{Host: s.host, Port: s.port, Encryption: encryption}
+}
+
+// counts returns how many connections and messages the stub has seen.
+func (s *smtpStub) counts() (conns, messages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, s.messages
+}
+
+// recordedAuths returns the AUTH exchanges seen so far.
+func (s *smtpStub) recordedAuths() []smtpStubAuth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpStubAuth(nil), s.auths...)
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	s.mu.Lock()
+	s.conns++
+	s.mu.Unlock()
+	c := textproto.NewConn(conn)
+	reply := func(lines ...string) {
+		for _, l := range lines {
+			_ = c.PrintfLine("%s", l)
+		}
+	}
+	reply("220 stub ESMTP")
+	inData := false
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		if inData {
+			if line == "." && s.stall == "." {
+				_, _ = io.Copy(io.Discard, conn)
+				return
+			}
+			if line == "." {
+				inData = false
+				s.mu.Lock()
+				s.messages++
+				s.mu.Unlock()
+				reply("250 2.0.0 Ok: queued as STUB1")
+			}
+			continue
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		if s.stall != "" && strings.EqualFold(verb, s.stall) {
+			_, _ = io.Copy(io.Discard, conn)
+			return
+		}
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"250-stub"}
+			if s.mechanisms != "" {
+				lines = append(lines, "250-AUTH "+s.mechanisms)
+			}
+			if s.tlsConfig != nil {
+				if _, ok := conn.(*tls.Conn); !ok {
+					lines = append(lines, "250-STARTTLS")
+				}
+			}
+			reply(append(lines, "250 8BITMIME")...)
+		case "STARTTLS":
+			reply("220 2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			c = textproto.NewConn(conn)
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			auth := smtpStubAuth{mechanism: mechanism, initial: decodeBase64(initial)}
+			ok := !s.rejectAuth
+			if s.rejectAuth {
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte(s.challenge)))
+				resp, err := c.ReadLine()
+				if err != nil {
+					return
+				}
+				auth.continuation = decodeBase64(resp)
+			}
+			s.mu.Lock()
+			s.auths = append(s.auths, auth)
+			s.mu.Unlock()
+			if ok {
+				reply("235 2.7.0 Accepted")
+			} else {
+				reply("535 5.7.8 Username and Password not accepted")
+			}
+		case "DATA":
+			inData = true
+			reply("354 End data with <CR><LF>.<CR><LF>")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("250 2.0.0 Ok")
+		}
+	}
+}
+
+func decodeBase64(s string) string {
+	b, _ := base64.StdEncoding.DecodeString(s)
+	return string(b)
+}
+
+// selfSignedTLSConfig returns a server TLS config with a certificate for
+// 127.0.0.1 that no client trusts.
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stub"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/resendlabs/resend-go"
+	"github.com/spf13/cobra"
+)
+
+var statusJSON bool
+
+// StatusCmd is the cobra command that asks the provider what became of sent
+// emails.
+var StatusCmd = &cobra.Command{
+	Use:   "status <id>...",
+	Short: "Check the delivery status of sent emails",
+	Long: `Asks the provider what became of emails in the sent history (delivered,
+bounced, complained, opened and so on) and records the answer in the history.
+Takes history IDs, shortened as long as they're unambiguous, or the provider's
+email IDs. Only Resend reports delivery status; SMTP servers don't.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeHistoryIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		entries := make([]*HistoryEntry, len(args))
+		for i, id := range args {
+			e, err := findHistoryEntry(id)
+			if err != nil {
+				return err
+			}
+			entries[i] = e
+		}
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), time.Minute)
+		defer cancel()
+		var errs []error
+		for _, e := range entries {
+			if err := refreshDelivery(ctx, e); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", e.ID, err))
+			}
+		}
+
+		if statusJSON {
+			if err := printJSON(entries); err != nil {
+				return err
+			}
+		} else {
+			w := colorprofile.NewWriter(os.Stdout, os.Environ())
+			for _, e := range entries {
+				_, _ = fmt.Fprintln(w, historyLine(e))
+			}
+		}
+		return errors.Join(errs...)
+	},
+}
+
+// errNoDeliveryStatus is returned for emails whose provider doesn't report
+// what became of them.
+var errNoDeliveryStatus = errors.New("only emails sent through Resend have a delivery status")
+
+// statusChecker is implemented by senders whose provider reports the
+// delivery status of the emails it accepted.
+type statusChecker interface {
+	// deliveryStatus returns the latest event of the email with the
+	// provider's ID, e.g. "delivered".
+	deliveryStatus(ctx context.Context, id string) (string, error)
+}
+
+// deliveryStatus returns the last event Resend recorded for the email.
+func (s *ResendSender) deliveryStatus(ctx context.Context, id string) (string, error) {
+	apiKey, err := s.apiKey()
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(resendBaseURL(), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/emails/"+url.PathEscape(id), nil)
+	if err != nil {
+		return "", fmt.Errorf("creating email request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("getting email: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("no email %s in the Resend account: was it sent with another one?", id)
+	default:
+		return "", fmt.Errorf("getting email: %w", &HTTPError{Status: resp.Status, Body: strings.TrimSpace(string(body))})
+	}
+
+	var email resend.Email
+	if err := json.Unmarshal(body, &email); err != nil {
+		return "", fmt.Errorf("parsing email: %w", err)
+	}
+	return email.LastEvent, nil
+}
+
+// statusCheckerFor returns a status checker for the account the entry was
+// sent with, or the one given with --account.
+func statusCheckerFor(e *HistoryEntry) (statusChecker, error) {
+	account := accountName
+	if account == "" {
+		var err error
+		if account, err = e.sentAccount(); err != nil {
+			return nil, err
+		}
+	}
+	sender, transport, err := accountSender(account)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := sender.(statusChecker)
+	if !ok {
+		return nil, fmt.Errorf("%s sends with %s: %w", ordefault(account, "the configured account"), transport, errNoDeliveryStatus)
+	}
+	return sc, nil
+}
+
+// refreshDelivery asks the provider for the entry's delivery status and
+// records it in the history.
+func refreshDelivery(ctx context.Context, e *HistoryEntry) error {
+	if e.Receipt == nil || e.Receipt.ID == "" || e.Receipt.Provider != transportResend {
+		return errNoDeliveryStatus
+	}
+	sc, err := statusCheckerFor(e)
+	if err != nil {
+		return err
+	}
+	status, err := sc.deliveryStatus(ctx, e.Receipt.ID)
+	if err != nil {
+		return err
+	}
+	e.Delivery = status
+	e.DeliveryUpdatedAt = time.Now().UTC()
+	return saveHistoryEntry(e)
+}
+
+func init() {
+	StatusCmd.Flags().BoolVar(&statusJSON, "json", false, "Print the emails as JSON")
+}
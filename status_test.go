@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resendStandIn is a stand-in for Resend's email API that knows the emails
+// sent with each API key.
+type resendStandIn struct {
+	emails map[string]map[string]string
+
+	mu   sync.Mutex
+	keys []string
+}
+
+// useResendStandIn points RESEND_BASE_URL at a stand-in for the emails.
+func useResendStandIn(t *testing.T, emails map[string]map[string]string) *resendStandIn {
+	t.Helper()
+	s := &resendStandIn{emails: emails}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	t.Setenv("RESEND_BASE_URL", srv.URL)
+	return s
+}
+
+// askedWith returns the API keys the stand-in was asked with.
+func (s *resendStandIn) askedWith() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.keys)
+}
+
+func (s *resendStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	s.keys = append(s.keys, key)
+	s.mu.Unlock()
+	id := path.Base(r.URL.Path)
+	event, ok := s.emails[key][id]
+	if r.Method != http.MethodGet || path.Dir(r.URL.Path) != "/emails" || !ok {
+		http.Error(w, `{"name":"not_found"}`, http.StatusNotFound)
+		return
+	}
+	_, _ = w.Write([]byte(`{"object":"email","id":"` + id + `","last_event":"` + event + `"}`))
+}
+
+// useAccounts writes a config file with the given contents, and forgets the
+// senders and settings of accounts used during the test.
+func useAccounts(t *testing.T, config string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv(PopConfig, file)
+	if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		clear(accountSenders)
+		_ = applyAccount(accountFlags, Account{})
+	})
+}
+
+func TestRefreshDelivery(t *testing.T) {
+	const config = `default = "home"
+
+[accounts.home]
+method = "resend"
+[accounts.home.resend]
+api_key = "re_home"
+
+[accounts.work]
+method = "resend"
+[accounts.work.resend]
+api_key = "re_work"
+`
+	tests := []struct {
+		name      string
+		account   string
+		transport string
+		provider  string
+		env       string
+		want      string
+		wantKey   string
+		wantErr   error
+	}{
+		{
+			name:      "sent with an account",
+			account:   "work",
+			transport: transportResend,
+			want:      "delivered",
+			wantKey:   "re_work",
+		},
+		{
+			name:      "sent with the environment",
+			account:   noAccount,
+			transport: transportResend,
+			env:       "re_env",
+			want:      "bounced",
+			wantKey:   "re_env",
+		},
+		{
+			name:      "no account recorded",
+			transport: transportResend,
+			wantErr:   errUnknownAccount,
+		},
+		{
+			name:    "no transport recorded",
+			account: "work",
+			wantErr: errUnknownAccount,
+		},
+		{
+			name:      "sent through SMTP",
+			account:   "work",
+			transport: transportSMTP,
+			provider:  transportSMTP,
+			wantErr:   errNoDeliveryStatus,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDirs(t)
+			useAccounts(t, config)
+			if tt.env != "" {
+				// The flag's default comes from the environment when
+				// pop starts.
+				t.Setenv(ResendAPIKey, tt.env)
+				old := resendAPIKey
+				t.Cleanup(func() { resendAPIKey = old })
+				resendAPIKey = tt.env
+			}
+			resend := useResendStandIn(t, map[string]map[string]string{
+				"re_home": {"em_home": "opened"},
+				"re_work": {"em_1": "delivered"},
+				"re_env":  {"em_1": "bounced"},
+			})
+			e := &HistoryEntry{
+				ID:        "a1b2c3d4e5f6a7b8",
+				CreatedAt: time.Now().UTC(),
+				Account:   tt.account,
+				Transport: tt.transport,
+				Status:    historySent,
+				Receipt:   &Receipt{Provider: ordefault(tt.provider, transportResend), ID: "em_1"},
+			}
+			if err := saveHistoryEntry(e); err != nil {
+				t.Fatal(err)
+			}
+
+			err := refreshDelivery(t.Context(), e)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("refreshDelivery error = %v, want %v", err, tt.wantErr)
+				}
+				if keys := resend.askedWith(); len(keys) > 0 {
+					t.Errorf("asked Resend with %v, want no request", keys)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("refreshDelivery: %v", err)
+			}
+			if keys := resend.askedWith(); !slices.Equal(keys, []string{tt.wantKey}) {
+				t.Errorf("asked Resend with %v, want [%s]", keys, tt.wantKey)
+			}
+			saved, err := findHistoryEntry(e.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Delivery != tt.want || saved.DeliveryUpdatedAt.IsZero() {
+				t.Errorf("recorded delivery %q at %s, want %q", saved.Delivery, saved.DeliveryUpdatedAt, tt.want)
+			}
+		})
+	}
+
+	t.Run("email of another Resend account", func(t *testing.T) {
+		useTestDirs(t)
+		useAccounts(t, config)
+		useResendStandIn(t, map[string]map[string]string{"re_work": {"em_1": "delivered"}})
+		e := &HistoryEntry{
+			ID:        "b1b2c3d4e5f6a7b8",
+			Account:   "home",
+			Transport: transportResend,
+			Receipt:   &Receipt{Provider: transportResend, ID: "em_1"},
+		}
+		if err := refreshDelivery(t.Context(), e); err == nil {
+			t.Fatal("refreshDelivery succeeded with the wrong account")
+		}
+	})
+}
+
+func TestHistoryEntryRecordsAccount(t *testing.T) {
+	sender := &ResendSender{APIKey: "re_test"}
+	for account, want := range map[string]string{"work": "work", "": noAccount} {
+		e := newHistoryEntry(account, sender, Message{From: "me@example.com", To: []string{"you@example.com"}})
+		if e.Account != want || e.Transport != transportResend {
+			t.Errorf("newHistoryEntry(%q) recorded account %q and transport %q, want %q and %q",
+				account, e.Account, e.Transport, want, transportResend)
+		}
+	}
+}
@@ -73,20 +73,38 @@ var (
 			Underline(true)
 )
 
-// emailSummary returns a summary of the email that was sent. It is used when
-// the user has sent an email successfully.
-func emailSummary(to []string, subject string) string {
+// emailSummary returns a summary of the email that was sent, from the
+// transport's receipt. It is used when the user has sent an email
+// successfully.
+func emailSummary(subject string, receipt *Receipt) string {
 	var s strings.Builder
 	s.WriteString("\n  Email ")
 	s.WriteString(activeTextStyle.Render("\"" + subject + "\""))
-	s.WriteString(" sent to ")
-	for i, t := range to {
+	if receipt.ScheduledAt.IsZero() {
+		s.WriteString(" sent to ")
+	} else {
+		s.WriteString(" scheduled for " + activeLabelStyle.Render(receipt.ScheduledAt.Local().Format("Mon 2 Jan 15:04")) + " to ")
+	}
+	for i, t := range receipt.Accepted {
 		if i > 0 {
 			s.WriteString(", ")
 		}
 		s.WriteString(linkStyle.Render(t))
 	}
-	s.WriteString("\n\n")
+	s.WriteString("\n")
+
+	details := []string{"via " + ordefault(receipt.Server, receipt.Provider)}
+	if receipt.ID != "" {
+		details = append(details, "ID "+receipt.ID)
+	}
+	if receipt.MessageID != "" {
+		details = append(details, "Message-ID "+receipt.MessageID)
+	}
+	s.WriteString(" " + commentStyle.Render(strings.Join(details, " · ")) + "\n")
+	for _, r := range receipt.Rejected {
+		s.WriteString("  " + errorStyle.Render("Not sent to "+r.Address+": "+r.Error) + "\n")
+	}
+	s.WriteString("\n")
 
 	return s.String()
 }
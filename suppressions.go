@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/colorprofile"
+	"github.com/spf13/cobra"
+)
+
+// Suppression is an address pop won't send to any more, because mail to it
+// bounced or its owner marked some as spam.
+type Suppression struct {
+	Address string `json:"address"`
+	// Reason is the event that put the address on the list: "bounced" or
+	// "complained".
+	Reason string `json:"reason"`
+	// Detail is what the provider said about it, e.g. the bounce message.
+	Detail  string    `json:"detail,omitempty"`
+	EmailID string    `json:"email_id,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// suppressionsPath returns the path to the suppression list.
+func suppressionsPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "suppressions.json"), nil
+}
+
+// loadSuppressions reads the suppression list, keyed by lowercased address.
+func loadSuppressions() (map[string]Suppression, error) {
+	path, err := suppressionsPath()
+	if err != nil {
+		return nil, err
+	}
+	suppressions := map[string]Suppression{}
+	data, err := os.ReadFile(path) //nolint:gosec // G304: path is in the data directory
+	if errors.Is(err, os.ErrNotExist) {
+		return suppressions, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading suppression list: %w", err)
+	}
+	if err := json.Unmarshal(data, &suppressions); err != nil {
+		return nil, fmt.Errorf("parsing suppression list: %w", err)
+	}
+	return suppressions, nil
+}
+
+// updateSuppressions changes the suppression list with update, holding a
+// lock so concurrent pop processes don't lose each other's changes.
+func updateSuppressions(update func(map[string]Suppression)) error {
+	path, err := suppressionsPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("opening suppression list lock: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("locking suppression list: %w", err)
+	}
+	defer func() { _ = unlockFile(f) }()
+
+	suppressions, err := loadSuppressions()
+	if err != nil {
+		return err
+	}
+	update(suppressions)
+	data, err := json.MarshalIndent(suppressions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding suppression list: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("writing suppression list: %w", err)
+	}
+	return nil
+}
+
+// suppressedError is returned when an email is addressed to suppressed
+// recipients.
+type suppressedError struct {
+	suppressions []Suppression
+}
+
+func (e *suppressedError) Error() string {
+	parts := make([]string, len(e.suppressions))
+	for i, s := range e.suppressions {
+		parts[i] = s.Address + " (" + s.Reason + ")"
+	}
+	return "not sending to suppressed recipients: " + strings.Join(parts, ", ") + "; remove them with `pop suppressions remove`"
+}
+
+// checkSuppressions returns a *suppressedError if any of the message's
+// recipients is on the suppression list.
+func checkSuppressions(msg Message) error {
+	suppressions, err := loadSuppressions()
+	if err != nil || len(suppressions) == 0 {
+		return err
+	}
+	var found []Suppression
+	for _, a := range compactAddresses(slices.Concat(msg.To, msg.Cc, msg.Bcc)) {
+		if s, ok := suppressions[suppressionKey(a)]; ok {
+			found = append(found, s)
+		}
+	}
+	if len(found) > 0 {
+		return &suppressedError{suppressions: found}
+	}
+	return nil
+}
+
+// suppressionKey returns the key of the address in the suppression list: its
+// bare address, lowercased.
+func suppressionKey(address string) string {
+	address = strings.TrimSpace(address)
+	if i := strings.LastIndex(address, "<"); i >= 0 {
+		address = strings.TrimSuffix(address[i+1:], ">")
+	}
+	return strings.ToLower(address)
+}
+
+var suppressionsJSON bool
+
+// SuppressionsCmd is the cobra command that lists the suppression list.
+var SuppressionsCmd = &cobra.Command{
+	Use:   "suppressions",
+	Short: "List the addresses pop won't send to",
+	Long: `Lists the addresses pop refuses to send to because mail to them bounced
+permanently or their owner complained, as reported by pop events listen.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		suppressions, err := loadSuppressions()
+		if err != nil {
+			return err
+		}
+		list := make([]Suppression, 0, len(suppressions))
+		for _, key := range slices.Sorted(maps.Keys(suppressions)) {
+			list = append(list, suppressions[key])
+		}
+		if suppressionsJSON {
+			return printJSON(list)
+		}
+		w := colorprofile.NewWriter(os.Stdout, os.Environ())
+		if len(list) == 0 {
+			_, _ = fmt.Fprintf(w, "\n  %s\n\n", placeholderStyle.Render("No suppressed addresses."))
+			return nil
+		}
+		for _, s := range list {
+			_, _ = fmt.Fprintf(w, "%s %s%s\n", linkStyle.Render(s.Address), errorStyle.Render(s.Reason),
+				commentStyle.Render(s.AddedAt.Local().Format("2006-01-02 15:04")+" "+s.Detail))
+		}
+		return nil
+	},
+}
+
+// SuppressionsRemoveCmd is the cobra command that takes addresses off the
+// suppression list.
+var SuppressionsRemoveCmd = &cobra.Command{
+	Use:   "remove <address>...",
+	Short: "Let pop send to suppressed addresses again",
+	Args:  cobra.MinimumNArgs(1),
+	ValidArgsFunction: func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		suppressions, err := loadSuppressions()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return slices.Sorted(maps.Keys(suppressions)), cobra.ShellCompDirectiveNoFileComp
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		var missing []string
+		err := updateSuppressions(func(suppressions map[string]Suppression) {
+			for _, a := range args {
+				if _, ok := suppressions[suppressionKey(a)]; !ok {
+					missing = append(missing, a)
+					continue
+				}
+				delete(suppressions, suppressionKey(a))
+				fmt.Printf("Removed %s from the suppression list.\n", a)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("not on the suppression list: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	},
+}
+
+func init() {
+	SuppressionsCmd.AddCommand(SuppressionsRemoveCmd)
+	SuppressionsCmd.Flags().BoolVar(&suppressionsJSON, "json", false, "Print the list as JSON")
+}
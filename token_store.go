@@ -0,0 +1,650 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PopTokenStore is the environment variable that selects where OAuth tokens
+// are stored, overriding token_store in the config file.
+const PopTokenStore = "POP_TOKEN_STORE"
+
+// PopTokenPassphrase is the environment variable holding the passphrase of
+// the encrypted-file token store.
+const PopTokenPassphrase = "POP_TOKEN_PASSPHRASE"
+
+// Names of the token store backends.
+const (
+	tokenStoreFile      = "file"
+	tokenStoreEncrypted = "encrypted-file"
+	tokenStoreKeyring   = "keyring"
+	tokenStoreKeyctl    = "keyctl"
+)
+
+// tokenStoreNames are the token store backends, in the order they're listed
+// to the user.
+var tokenStoreNames = []string{tokenStoreFile, tokenStoreEncrypted, tokenStoreKeyring, tokenStoreKeyctl}
+
+// keyringService is the service name pop's tokens are stored under in the
+// system keyring.
+const keyringService = "pop"
+
+// tokenStore persists OAuth tokens, one per login. Logins are identified by
+// their login key (see loginKey).
+type tokenStore interface {
+	// Load returns the login's token. If there is none, the error
+	// satisfies os.IsNotExist.
+	Load(login string) (*OAuthToken, error)
+	// Save stores the login's token, replacing any existing one.
+	Save(login string, token *OAuthToken) error
+	// Delete removes the login's token. If there is none, the error
+	// satisfies os.IsNotExist.
+	Delete(login string) error
+	// Location describes where the login's token is kept.
+	Location(login string) string
+	// List returns the keys of the logins with a stored token, sorted.
+	List() ([]string, error)
+}
+
+// activeTokenStore returns the token store selected by $POP_TOKEN_STORE or
+// the config file, defaulting to the plaintext file store.
+var activeTokenStore = sync.OnceValues(func() (tokenStore, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return openTokenStore(configuredTokenStore(cfg), cfg)
+})
+
+// configuredTokenStore returns the name of the token store selected by
+// $POP_TOKEN_STORE or the config file.
+func configuredTokenStore(cfg *Config) string {
+	if env := os.Getenv(PopTokenStore); env != "" {
+		return env
+	}
+	if cfg.TokenStore != "" {
+		return cfg.TokenStore
+	}
+	return tokenStoreFile
+}
+
+// openTokenStore returns the named token store backend.
+func openTokenStore(name string, cfg *Config) (tokenStore, error) {
+	switch strings.ToLower(name) {
+	case tokenStoreFile, "":
+		return fileTokenStore{}, nil
+	case tokenStoreEncrypted:
+		return &encryptedTokenStore{passphraseCmd: cfg.TokenPassphraseCmd}, nil
+	case tokenStoreKeyring:
+		return secretTokenStore{keyringSecrets{}}, nil
+	case tokenStoreKeyctl:
+		secrets, err := newKeyctlSecrets()
+		if err != nil {
+			return nil, err
+		}
+		return secretTokenStore{secrets}, nil
+	default:
+		return nil, fmt.Errorf("unknown token store %q (expected one of %s)", name, strings.Join(tokenStoreNames, ", "))
+	}
+}
+
+// unlockTokenStore asks the user for anything the active token store needs,
+// such as its passphrase, so they aren't prompted while a TUI owns the
+// terminal. Unless a token is about to be saved, an empty store is left
+// locked.
+func unlockTokenStore(saving bool) error {
+	store, err := activeTokenStore()
+	if err != nil {
+		return err
+	}
+	if s, ok := store.(*encryptedTokenStore); ok {
+		initialized := s.initialized()
+		if !initialized && !saving {
+			return nil
+		}
+		_, err := s.passphrase(!initialized)
+		return err
+	}
+	return nil
+}
+
+// fileTokenStore keeps each token as plaintext JSON in the data directory,
+// protected only by file permissions.
+type fileTokenStore struct{}
+
+func (fileTokenStore) Load(login string) (*OAuthToken, error) {
+	path, err := authFilePath(login)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err //nolint:wrapcheck // caller checks os.IsNotExist
+		}
+		return nil, fmt.Errorf("reading auth file: %w", err)
+	}
+	var token OAuthToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("parsing auth file: %w", err)
+	}
+	return &token, nil
+}
+
+func (fileTokenStore) Save(login string, token *OAuthToken) error {
+	path, err := authFilePath(login)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(token, "", "  ") //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("marshaling auth: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("writing auth file: %w", err)
+	}
+	return nil
+}
+
+func (fileTokenStore) Delete(login string) error {
+	path, err := authFilePath(login)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return err //nolint:wrapcheck // caller checks os.IsNotExist
+		}
+		return fmt.Errorf("deleting auth file: %w", err)
+	}
+	return nil
+}
+
+func (fileTokenStore) Location(login string) string {
+	path, _ := authFilePath(login)
+	return path
+}
+
+func (fileTokenStore) List() ([]string, error) {
+	return listAuthFiles("")
+}
+
+// listAuthFiles returns the login keys of the token files in the data
+// directory whose names end in authFilePath's plus suffix.
+func listAuthFiles(suffix string) ([]string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing auth files: %w", err)
+	}
+	var logins []string
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json"+suffix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if name == "auth" {
+			logins = append(logins, providerResend)
+		} else if login, ok := strings.CutPrefix(name, "auth-"); ok {
+			logins = append(logins, login)
+		}
+	}
+	slices.Sort(logins)
+	return logins, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path, readable only
+// by the user, and renames it over path, so readers never see a partially
+// written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err //nolint:wrapcheck
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err //nolint:wrapcheck
+	}
+	if err := f.Close(); err != nil {
+		return err //nolint:wrapcheck
+	}
+	return os.Rename(f.Name(), path) //nolint:wrapcheck
+}
+
+// scrypt parameters for deriving the encrypted-file store's key, as
+// recommended for interactive logins.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// encryptedToken is the on-disk format of the encrypted-file store: the
+// token's JSON sealed with AES-256-GCM under a key derived from the
+// passphrase with scrypt.
+type encryptedToken struct {
+	KDF   string `json:"kdf"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// encryptedTokenStore keeps each token in a file encrypted with a
+// passphrase. The passphrase comes from $POP_TOKEN_PASSPHRASE, the config's
+// token_passphrase_cmd, or a prompt, and is remembered for the rest of the
+// process.
+type encryptedTokenStore struct {
+	passphraseCmd string
+
+	mu   sync.Mutex
+	pass []byte
+}
+
+func (s *encryptedTokenStore) path(login string) (string, error) {
+	path, err := authFilePath(login)
+	if err != nil {
+		return "", err
+	}
+	return path + ".enc", nil
+}
+
+// initialized reports whether any token has been encrypted yet, in which
+// case a new passphrase doesn't need confirming.
+func (s *encryptedTokenStore) initialized() bool {
+	logins, err := s.List()
+	return err == nil && len(logins) > 0
+}
+
+// passphrase returns the store's passphrase, prompting for it (twice, if
+// confirm is set) when it isn't configured.
+func (s *encryptedTokenStore) passphrase(confirm bool) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pass != nil {
+		return s.pass, nil
+	}
+
+	var pass []byte
+	switch {
+	case os.Getenv(PopTokenPassphrase) != "":
+		pass = []byte(os.Getenv(PopTokenPassphrase))
+	case s.passphraseCmd != "":
+		out, err := runSecretCmd(s.passphraseCmd)
+		if err != nil {
+			return nil, fmt.Errorf("getting token store passphrase: %w", err)
+		}
+		pass = []byte(out)
+	default:
+		if !term.IsTerminal(os.Stdin.Fd()) {
+			return nil, fmt.Errorf("the %s token store needs a passphrase: set $%s or token_passphrase_cmd", tokenStoreEncrypted, PopTokenPassphrase)
+		}
+		var err error
+		pass, err = readPassphrase("Token store passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if confirm {
+			again, err := readPassphrase("Confirm passphrase: ")
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(pass, again) {
+				return nil, errors.New("passphrases don't match")
+			}
+		}
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("token store passphrase is empty")
+	}
+	if err := s.check(pass); err != nil {
+		return nil, err
+	}
+	s.pass = pass
+	return pass, nil
+}
+
+// errWrongPassphrase is returned when a passphrase doesn't decrypt any of
+// the stored tokens.
+var errWrongPassphrase = errors.New("wrong passphrase for the encrypted token store")
+
+// check verifies pass against the stored tokens, so a mistyped passphrase
+// fails right away rather than encrypting new tokens with a different key.
+// It passes when there are no tokens yet or any of them decrypts.
+func (s *encryptedTokenStore) check(pass []byte) error {
+	logins, err := s.List()
+	if err != nil || len(logins) == 0 {
+		return nil //nolint:nilerr // nothing to check against
+	}
+	for _, login := range logins {
+		path, err := s.path(login)
+		if err != nil {
+			return err
+		}
+		enc, err := readEncryptedToken(path)
+		if err != nil {
+			// A file that can't be parsed says nothing about the
+			// passphrase.
+			continue
+		}
+		if _, err := s.open(pass, login, enc); err == nil {
+			return nil
+		}
+	}
+	return errWrongPassphrase
+}
+
+// readPassphrase prompts for a passphrase on stderr without echoing it.
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+	return pass, nil
+}
+
+// cipher returns the AES-GCM cipher for the given scrypt parameters.
+func (s *encryptedTokenStore) cipher(pass []byte, enc *encryptedToken) (cipher.AEAD, error) {
+	key, err := scrypt.Key(pass, enc.Salt, enc.N, enc.R, enc.P, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	return gcm, nil
+}
+
+func (s *encryptedTokenStore) Load(login string) (*OAuthToken, error) {
+	path, err := s.path(login)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := readEncryptedToken(path)
+	if err != nil {
+		return nil, err
+	}
+	pass, err := s.passphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := s.open(pass, login, enc)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w", path, err)
+	}
+	var token OAuthToken
+	if err := json.Unmarshal(plain, &token); err != nil {
+		return nil, fmt.Errorf("parsing auth file: %w", err)
+	}
+	return &token, nil
+}
+
+// readEncryptedToken reads and parses an encrypted token file.
+func readEncryptedToken(path string) (*encryptedToken, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err //nolint:wrapcheck // caller checks os.IsNotExist
+		}
+		return nil, fmt.Errorf("reading auth file: %w", err)
+	}
+	var enc encryptedToken
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return nil, fmt.Errorf("parsing auth file: %w", err)
+	}
+	if enc.KDF != "scrypt" {
+		return nil, fmt.Errorf("parsing auth file: unsupported key derivation %q", enc.KDF)
+	}
+	return &enc, nil
+}
+
+// open decrypts the login's token.
+func (s *encryptedTokenStore) open(pass []byte, login string, enc *encryptedToken) ([]byte, error) {
+	gcm, err := s.cipher(pass, enc)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, enc.Nonce, enc.Data, []byte(login))
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted file")
+	}
+	return plain, nil
+}
+
+func (s *encryptedTokenStore) Save(login string, token *OAuthToken) error {
+	path, err := s.path(login)
+	if err != nil {
+		return err
+	}
+	pass, err := s.passphrase(!s.initialized())
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(token) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("marshaling auth: %w", err)
+	}
+	enc := encryptedToken{KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 16)} //nolint:mnd
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return fmt.Errorf("generating salt: %w", err)
+	}
+	gcm, err := s.cipher(pass, &enc)
+	if err != nil {
+		return err
+	}
+	enc.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return fmt.Errorf("generating nonce: %w", err)
+	}
+	// The login is authenticated along with the token so a file can't be
+	// passed off as another login's.
+	enc.Data = gcm.Seal(nil, enc.Nonce, plain, []byte(login))
+	data, err := json.MarshalIndent(enc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling auth: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("writing auth file: %w", err)
+	}
+	return nil
+}
+
+func (s *encryptedTokenStore) Delete(login string) error {
+	path, err := s.path(login)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return err //nolint:wrapcheck // caller checks os.IsNotExist
+		}
+		return fmt.Errorf("deleting auth file: %w", err)
+	}
+	return nil
+}
+
+func (s *encryptedTokenStore) Location(login string) string {
+	path, _ := s.path(login)
+	return path
+}
+
+func (s *encryptedTokenStore) List() ([]string, error) {
+	return listAuthFiles(".enc")
+}
+
+// secrets is a key-value store for secrets, such as the system keyring,
+// that tokens can be kept in.
+type secrets interface {
+	// get returns the secret stored under key. If there is none, the error
+	// is os.ErrNotExist.
+	get(key string) ([]byte, error)
+	// set stores a secret under key, replacing any existing one.
+	set(key string, data []byte) error
+	// remove deletes the secret stored under key. If there is none, the
+	// error is os.ErrNotExist.
+	remove(key string) error
+	// location describes where the secret under key is kept.
+	location(key string) string
+}
+
+// loginIndexKey is the key secretTokenStore keeps its list of logins under,
+// since secret stores can't be enumerated. It can't clash with a login key,
+// which starts with a provider's name.
+const loginIndexKey = "_logins"
+
+// secretTokenStore keeps each token as JSON in a secret store.
+type secretTokenStore struct {
+	secrets secrets
+}
+
+func (s secretTokenStore) Load(login string) (*OAuthToken, error) {
+	data, err := s.secrets.get(login)
+	if err != nil {
+		return nil, err
+	}
+	var token OAuthToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("parsing token from %s: %w", s.secrets.location(login), err)
+	}
+	return &token, nil
+}
+
+func (s secretTokenStore) Save(login string, token *OAuthToken) error {
+	data, err := json.Marshal(token) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("marshaling auth: %w", err)
+	}
+	if err := s.secrets.set(login, data); err != nil {
+		return err
+	}
+	return s.updateIndex(func(logins []string) []string {
+		if slices.Contains(logins, login) {
+			return logins
+		}
+		return append(logins, login)
+	})
+}
+
+func (s secretTokenStore) Delete(login string) error {
+	if err := s.secrets.remove(login); err != nil {
+		return err
+	}
+	return s.updateIndex(func(logins []string) []string {
+		return slices.DeleteFunc(logins, func(l string) bool { return l == login })
+	})
+}
+
+func (s secretTokenStore) Location(login string) string {
+	return s.secrets.location(login)
+}
+
+func (s secretTokenStore) List() ([]string, error) {
+	data, err := s.secrets.get(loginIndexKey)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// Tokens stored before the index existed are only found by their
+	// provider's name.
+	logins := strings.Fields(string(data))
+	for _, provider := range oauthProviderNames {
+		if slices.Contains(logins, provider) {
+			continue
+		}
+		if _, err := s.secrets.get(provider); err == nil {
+			logins = append(logins, provider)
+		}
+	}
+	slices.Sort(logins)
+	return logins, nil
+}
+
+// updateIndex rewrites the store's list of logins with update, holding a
+// lock so concurrent logins don't drop each other's entries.
+func (s secretTokenStore) updateIndex(update func([]string) []string) error {
+	unlock, err := lockAuth(loginIndexKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := s.secrets.get(loginIndexKey)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	logins := update(strings.Fields(string(data)))
+	if len(logins) == 0 {
+		if err := s.secrets.remove(loginIndexKey); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return s.secrets.set(loginIndexKey, []byte(strings.Join(logins, "\n")))
+}
+
+// keyringSecrets keeps secrets in the system keyring: the Secret Service
+// (GNOME Keyring, KWallet) on Linux, the Keychain on macOS and the Credential
+// Manager on Windows.
+type keyringSecrets struct{}
+
+func (keyringSecrets) get(key string) ([]byte, error) {
+	data, err := keyring.Get(keyringService, key)
+	if err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("reading token from keyring: %w", err)
+	}
+	return []byte(data), nil
+}
+
+func (keyringSecrets) set(key string, data []byte) error {
+	if err := keyring.Set(keyringService, key, string(data)); err != nil {
+		return fmt.Errorf("writing token to keyring: %w", err)
+	}
+	return nil
+}
+
+func (keyringSecrets) remove(key string) error {
+	if err := keyring.Delete(keyringService, key); err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return os.ErrNotExist
+		}
+		return fmt.Errorf("deleting token from keyring: %w", err)
+	}
+	return nil
+}
+
+func (keyringSecrets) location(key string) string {
+	return "system keyring (" + keyringService + "/" + key + ")"
+}
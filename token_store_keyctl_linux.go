@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// keyctlSecrets keeps secrets in the Linux kernel's user keyring. It needs no
+// daemon, which suits headless machines, but tokens don't survive a reboot.
+type keyctlSecrets struct{}
+
+func newKeyctlSecrets() (secrets, error) {
+	return keyctlSecrets{}, nil
+}
+
+// description returns the description the key is stored under.
+func (keyctlSecrets) description(key string) string {
+	return keyringService + ":" + key
+}
+
+// find returns the ID of the kernel key for key.
+func (s keyctlSecrets) find(key string) (int, error) {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", s.description(key), 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOKEY) {
+			return 0, os.ErrNotExist
+		}
+		return 0, fmt.Errorf("searching kernel keyring: %w", err)
+	}
+	return id, nil
+}
+
+func (s keyctlSecrets) get(key string) ([]byte, error) {
+	id, err := s.find(key)
+	if err != nil {
+		return nil, err
+	}
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading token from kernel keyring: %w", err)
+	}
+	data := make([]byte, size)
+	if _, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, data, 0); err != nil {
+		return nil, fmt.Errorf("reading token from kernel keyring: %w", err)
+	}
+	return data, nil
+}
+
+func (s keyctlSecrets) set(key string, data []byte) error {
+	// Adding a key with an existing description updates it in place.
+	if _, err := unix.AddKey("user", s.description(key), data, unix.KEY_SPEC_USER_KEYRING); err != nil {
+		return fmt.Errorf("writing token to kernel keyring: %w", err)
+	}
+	return nil
+}
+
+func (s keyctlSecrets) remove(key string) error {
+	id, err := s.find(key)
+	if err != nil {
+		return err
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, id, unix.KEY_SPEC_USER_KEYRING, 0, 0); err != nil {
+		return fmt.Errorf("deleting token from kernel keyring: %w", err)
+	}
+	return nil
+}
+
+func (s keyctlSecrets) location(key string) string {
+	return "kernel user keyring (" + s.description(key) + ")"
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func newKeyctlSecrets() (secrets, error) {
+	return nil, fmt.Errorf("the %s token store is only available on Linux", tokenStoreKeyctl)
+}
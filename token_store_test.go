@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEncryptedTokenStorePassphrase(t *testing.T) {
+	useTestDirs(t)
+	t.Setenv(PopTokenPassphrase, "right")
+	token := &OAuthToken{AccessToken: "access", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := (&encryptedTokenStore{}).Save(providerGoogle, token); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	t.Run("wrong passphrase can't save", func(t *testing.T) {
+		t.Setenv(PopTokenPassphrase, "wrong")
+		store := &encryptedTokenStore{}
+		if err := store.Save(providerMicrosoft, token); !errors.Is(err, errWrongPassphrase) {
+			t.Fatalf("Save error = %v, want %v", err, errWrongPassphrase)
+		}
+		logins, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(logins, []string{providerGoogle}) {
+			t.Errorf("stored logins = %v, want only %s", logins, providerGoogle)
+		}
+		if store.pass != nil {
+			t.Error("wrong passphrase was remembered")
+		}
+	})
+
+	t.Run("wrong passphrase can't load", func(t *testing.T) {
+		t.Setenv(PopTokenPassphrase, "wrong")
+		if _, err := (&encryptedTokenStore{}).Load(providerGoogle); !errors.Is(err, errWrongPassphrase) {
+			t.Fatalf("Load error = %v, want %v", err, errWrongPassphrase)
+		}
+	})
+
+	t.Run("right passphrase", func(t *testing.T) {
+		store := &encryptedTokenStore{}
+		if err := store.Save(providerMicrosoft, token); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		got, err := store.Load(providerMicrosoft)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if got.AccessToken != "access" {
+			t.Errorf("access token = %q, want access", got.AccessToken)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+)
+
+// PopUndoSend is the environment variable that sets how long the TUI waits
+// before sending, so a send can be undone, overriding undo_send in the
+// config file. 0 sends right away.
+const PopUndoSend = "POP_UNDO_SEND"
+
+// defaultUndoSend is how long the TUI waits before sending when neither
+// $POP_UNDO_SEND nor undo_send says otherwise.
+const defaultUndoSend = 10 * time.Second
+
+// undoSendWindow returns how long the TUI waits before sending an email.
+func undoSendWindow() (time.Duration, error) {
+	value, source := os.Getenv(PopUndoSend), "$"+PopUndoSend
+	if value == "" {
+		cfg, err := loadConfig()
+		if err != nil {
+			return 0, err
+		}
+		value, source = cfg.UndoSend, "undo_send"
+	}
+	if value == "" {
+		return defaultUndoSend, nil
+	}
+	d, err := parseUndoSend(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", source, err)
+	}
+	return d, nil
+}
+
+// parseUndoSend parses a duration such as 10s, or a number of seconds.
+func parseUndoSend(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		d, err = time.ParseDuration(value + "s")
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// errSendCanceled is returned when the user cancels a send while pop waits
+// to send it.
+var errSendCanceled = errors.New("canceled: nothing was sent")
+
+// waitToSend waits for the delay before a non-interactive send, counting
+// down on w, and returns errSendCanceled if ctx is canceled first.
+func waitToSend(ctx context.Context, w io.Writer, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	interactive := term.IsTerminal(os.Stderr.Fd())
+	if !interactive {
+		_, _ = fmt.Fprintf(w, "Sending in %s; interrupt to cancel.\n", delay)
+	}
+	deadline := time.Now().Add(delay)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			if interactive {
+				_, _ = fmt.Fprint(w, "\r\033[K")
+			}
+			return nil
+		}
+		if interactive {
+			_, _ = fmt.Fprintf(w, "\r\033[K%s%s", textStyle.Render(fmt.Sprintf("Sending in %s…", left.Round(time.Second))), commentStyle.Render("ctrl+c to cancel"))
+		}
+		select {
+		case <-ctx.Done():
+			if interactive {
+				_, _ = fmt.Fprintln(w)
+			}
+			return errSendCanceled
+		case <-ticker.C:
+		case <-time.After(left):
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/resendlabs/resend-go"
+)
+
+func TestUndoSendWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		config  string
+		want    time.Duration
+		wantErr string
+	}{
+		{name: "default", want: defaultUndoSend},
+		{name: "seconds", env: "5", want: 5 * time.Second},
+		{name: "duration", env: "1m30s", want: 90 * time.Second},
+		{name: "0 disables", env: "0", want: 0},
+		{name: "0s disables", env: "0s", want: 0},
+		{name: "config", config: `undo_send = "3s"`, want: 3 * time.Second},
+		{name: "config disables", config: `undo_send = "0"`, want: 0},
+		{name: "env beats config", env: "7", config: `undo_send = "3s"`, want: 7 * time.Second},
+		{name: "negative", env: "-5", wantErr: `$POP_UNDO_SEND: invalid duration "-5"`},
+		{name: "not a duration", env: "soon", wantErr: `$POP_UNDO_SEND: invalid duration "soon"`},
+		{name: "bad config", config: `undo_send = "10 seconds"`, wantErr: `undo_send: invalid duration "10 seconds"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDirs(t)
+			useAccounts(t, tt.config)
+			t.Setenv(PopUndoSend, tt.env)
+
+			got, err := undoSendWindow()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("undoSendWindow() = %s, %v; want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("undoSendWindow: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("undoSendWindow() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitToSendCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitToSend(ctx, io.Discard, time.Hour); !errors.Is(err, errSendCanceled) {
+		t.Fatalf("waitToSend with a canceled context = %v, want errSendCanceled", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if err := waitToSend(ctx, io.Discard, time.Hour); !errors.Is(err, errSendCanceled) {
+		t.Fatalf("waitToSend canceled while waiting = %v, want errSendCanceled", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("waitToSend returned %s after the cancel, want right away", waited)
+	}
+
+	if err := waitToSend(ctx, io.Discard, 0); err != nil {
+		t.Errorf("waitToSend with no delay = %v, want nil", err)
+	}
+}
+
+// countingSender counts the emails it's asked to send.
+type countingSender struct {
+	sends atomic.Int32
+}
+
+func (s *countingSender) Send(context.Context, Message) (*Receipt, error) {
+	s.sends.Add(1)
+	return &Receipt{}, nil
+}
+
+func TestModelUndoSend(t *testing.T) {
+	sender := &countingSender{}
+	m := NewModel(resend.SendEmailRequest{
+		From:    "me@example.com",
+		To:      []string{"ada@example.com"},
+		Subject: "Hi",
+		Text:    "Hello",
+	}, sender)
+	m.undoSend = 50 * time.Millisecond
+	m.state = hoveringSendButton
+	m.updateKeymap()
+
+	m, tick := m.send()
+	if m.state != waitingToSend || tick == nil {
+		t.Fatalf("after send, state = %v, want the countdown", m.state)
+	}
+	updated, cmd := m.Update(tea.KeyPressMsg{Code: tea.KeyEscape})
+	m = updated.(Model)
+	if m.state != hoveringSendButton || cmd != nil {
+		t.Fatalf("after undo, state = %v, want back on the send button", m.state)
+	}
+
+	// The countdown's tick still arrives once its time is up, and must not
+	// send.
+	updated, cmd = m.Update(tick())
+	m = updated.(Model)
+	runCmd(cmd)
+	if m.state != hoveringSendButton {
+		t.Errorf("after the undone countdown's tick, state = %v, want the send button", m.state)
+	}
+	if n := sender.sends.Load(); n != 0 {
+		t.Errorf("the sender was called %d times, want none", n)
+	}
+}
+
+// runCmd runs cmd and any commands it batches, dropping their messages.
+func runCmd(cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	if batch, ok := cmd().(tea.BatchMsg); ok {
+		for _, cmd := range batch {
+			runCmd(cmd)
+		}
+	}
+}